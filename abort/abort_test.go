@@ -0,0 +1,269 @@
+package abort
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+)
+
+func runScript(t *testing.T, script string) goja.Value {
+	loop := eventloop.NewEventLoop()
+	var res goja.Value
+	var scriptErr error
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		Enable(vm)
+		res, scriptErr = vm.RunString(script)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scriptErr != nil {
+		t.Fatal(scriptErr)
+	}
+	return res
+}
+
+func TestEventTarget(t *testing.T) {
+	runScript(t, `
+	function assert(cond, msg) {
+		if (!cond) {
+			throw new Error("Assertion failed: " + msg);
+		}
+	}
+
+	const et = new EventTarget();
+	const calls = [];
+	function l1(e) {
+		calls.push("l1", e.type, this === et, e.target === et, e.currentTarget === et, e.eventPhase);
+	}
+	et.addEventListener("test", l1);
+	et.addEventListener("test", l1); // duplicate, ignored
+	et.addEventListener("test", { handleEvent(e) { calls.push("handleEvent", this !== et); } });
+	et.addEventListener("test", () => calls.push("once"), { once: true });
+	const ev = new Event("test");
+	assert(et.dispatchEvent(ev), "dispatchEvent");
+	assert(ev.currentTarget === null && ev.target === et && ev.eventPhase === 0, "after dispatch");
+	assert(calls.join() === "l1,test,true,true,true,2,handleEvent,true,once", calls.join());
+
+	calls.length = 0;
+	et.removeEventListener("test", l1);
+	et.dispatchEvent(new Event("test"));
+	assert(calls.join() === "handleEvent,true", calls.join());
+
+	const et1 = new EventTarget();
+	et1.addEventListener("x", e => { e.preventDefault(); e.stopImmediatePropagation(); });
+	et1.addEventListener("x", () => { throw new Error("should not be called"); });
+	assert(et1.dispatchEvent(new Event("x", { cancelable: true })) === false, "cancelled");
+	assert(et1.dispatchEvent(new Event("x")) === true, "not cancelable");
+
+	const et2 = new EventTarget();
+	et2.addEventListener("x", e => e.preventDefault(), { passive: true });
+	assert(et2.dispatchEvent(new Event("x", { cancelable: true })) === true, "passive");
+
+	class MyTarget extends EventTarget {
+		constructor() {
+			super();
+			this.fired = false;
+		}
+	}
+	const mt = new MyTarget();
+	mt.addEventListener("x", function() { this.fired = true; });
+	mt.dispatchEvent(new Event("x"));
+	assert(mt.fired && mt instanceof EventTarget, "subclass");
+
+	const expectThrow = (fn, code) => {
+		try {
+			fn();
+		} catch (e) {
+			assert(e.code === code, e);
+			return;
+		}
+		throw new Error("should have thrown " + code);
+	};
+	expectThrow(() => new Event(), "ERR_MISSING_ARGS");
+	expectThrow(() => et.dispatchEvent({ type: "x" }), "ERR_INVALID_ARG_TYPE");
+	expectThrow(() => et.addEventListener("x", 1), "ERR_INVALID_ARG_TYPE");
+	expectThrow(() => EventTarget.prototype.addEventListener.call({}, "x", () => {}), "ERR_INVALID_THIS");
+	expectThrow(() => new AbortSignal(), "ERR_ILLEGAL_CONSTRUCTOR");
+	const rec = new Event("r");
+	et.addEventListener("r", e => expectThrow(() => et.dispatchEvent(e), "ERR_EVENT_RECURSION"));
+	et.dispatchEvent(rec);
+	`)
+}
+
+func TestAbortController(t *testing.T) {
+	res := runScript(t, `
+	const ac = new AbortController();
+	const signal = ac.signal;
+	const calls = [];
+	signal.onabort = e => calls.push("onabort", e.type, e.isTrusted);
+	signal.addEventListener("abort", () => calls.push("listener", signal.aborted, signal.reason));
+	const et = new EventTarget();
+	et.addEventListener("x", () => calls.push("x"), { signal });
+	calls.push(signal instanceof AbortSignal, signal instanceof EventTarget, String(signal));
+	signal.throwIfAborted();
+	ac.abort("reason");
+	ac.abort("ignored");
+	et.dispatchEvent(new Event("x"));
+	try {
+		signal.throwIfAborted();
+	} catch (e) {
+		calls.push(e);
+	}
+	const defaultReason = AbortSignal.abort().reason;
+	calls.push(defaultReason.name, defaultReason.code);
+	calls.join();
+	`)
+	if s := res.String(); s != "true,true,[object AbortSignal],onabort,abort,true,listener,true,reason,reason,AbortError,20" {
+		t.Fatal(s)
+	}
+}
+
+func TestAbortSignalAny(t *testing.T) {
+	res := runScript(t, `
+	const ac1 = new AbortController(), ac2 = new AbortController();
+	const any = AbortSignal.any([ac1.signal, ac2.signal]);
+	const calls = [any.aborted];
+	any.onabort = () => calls.push(any.reason);
+	ac2.abort("second");
+	ac1.abort("first");
+	calls.push(AbortSignal.any([new AbortController().signal, AbortSignal.abort("already")]).reason);
+	calls.join();
+	`)
+	if s := res.String(); s != "false,second,already" {
+		t.Fatal(s)
+	}
+}
+
+func TestAbortSignalAnyUnsubscribes(t *testing.T) {
+	loop := eventloop.NewEventLoop()
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		Enable(vm)
+		long := NewSignal(vm)
+		vm.Set("long", long.Object())
+		_, err := vm.RunString(`
+		for (let i = 0; i < 10; i++) {
+			const ac = new AbortController();
+			AbortSignal.any([long, ac.signal, long]);
+			ac.abort();
+		}
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(long.algorithms); n != 0 {
+			t.Fatalf("%d listeners left on the long-lived signal", n)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAbortSignalTimeout(t *testing.T) {
+	res := runScript(t, `
+	const calls = [];
+	const signal = AbortSignal.timeout(10);
+	signal.onabort = () => calls.push(signal.reason.name, signal.reason.code);
+	// the timeout signal does not keep the loop alive on its own
+	setTimeout(() => {}, 50);
+	AbortSignal.timeout(100000);
+	require("timers/promises").setTimeout(100000, null, { signal: AbortSignal.timeout(20) }).catch(e => {
+		calls.push(e.name, e.cause.name);
+	});
+	calls;
+	`)
+	if s := res.String(); s != "TimeoutError,23,AbortError,TimeoutError" {
+		t.Fatal(s)
+	}
+}
+
+func TestListenerException(t *testing.T) {
+	loop := eventloop.NewEventLoop()
+	var res goja.Value
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		Enable(vm)
+		_, err := vm.RunString(`
+		var calls = [];
+		require("process").on("uncaughtException", e => calls.push(e.message));
+		const et = new EventTarget();
+		et.addEventListener("x", () => { throw new Error("first"); });
+		et.addEventListener("x", () => calls.push("second"));
+		et.dispatchEvent(new Event("x"));
+		calls.push("dispatched");
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		res = vm.Get("calls")
+	})
+	if s := res.String(); s != "second,dispatched,first" {
+		t.Fatal(s)
+	}
+}
+
+func TestContextSignal(t *testing.T) {
+	loop := eventloop.NewEventLoop()
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx1, cancel1 := context.WithCancelCause(context.Background())
+	var res goja.Value
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		signal, release := NewContextSignal(vm, loop, ctx)
+		signal1, release1 := NewContextSignal(vm, loop, ctx1)
+		vm.Set("signal", signal.Object())
+		vm.Set("signal1", signal1.Object())
+		vm.Set("release", func() {
+			release()
+			release1()
+		})
+		_, err := vm.RunString(`
+		var calls = [];
+		// the signals do not keep the loop alive
+		const keepAlive = setInterval(() => {}, 1000);
+		function done() {
+			if (calls.length === 2) {
+				clearInterval(keepAlive);
+				release();
+			}
+		}
+		signal.onabort = () => { calls.push(signal.reason.name); done(); };
+		signal1.onabort = () => { calls.push(signal1.reason.message); done(); };
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(10*time.Millisecond, func() {
+			cancel()
+			cancel1(errors.New("shutting down"))
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		res = vm.Get("calls")
+	})
+	if s := res.String(); s != "AbortError,shutting down" && s != "shutting down,AbortError" {
+		t.Fatal(s)
+	}
+
+	err = loop.RunChecked(func(vm *goja.Runtime) {
+		signal, release := NewContextSignal(vm, loop, ctx)
+		release()
+		if !signal.Aborted() {
+			t.Fatal("signal is not aborted")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
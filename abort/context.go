@@ -0,0 +1,65 @@
+package abort
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// Runner runs functions on the goroutine that runs the runtime. It is implemented by *eventloop.EventLoop.
+type Runner interface {
+	RunOnLoop(fn func(*goja.Runtime)) bool
+}
+
+// NewContextSignal creates an AbortSignal that is aborted (on the loop, using runner.RunOnLoop()) when ctx is
+// done. The reason is a 'TimeoutError' DOMException if the context's deadline has been exceeded, an
+// 'AbortError' DOMException if it has been cancelled, or the cause (wrapped as a GoError) if it has been cancelled
+// with a cause (see context.WithCancelCause()).
+//
+// Unless ctx has no Done channel, a goroutine waits for it, so the returned function must be called once the
+// signal is no longer needed. After that the signal is not aborted when ctx is done.
+//
+// This function must be called from the loop. The signal does not keep the loop alive.
+func NewContextSignal(runtime *goja.Runtime, runner Runner, ctx context.Context) (*Signal, func()) {
+	s := NewSignal(runtime)
+	if ctx.Err() != nil {
+		s.Abort(contextReason(runtime, ctx))
+		return s, func() {}
+	}
+	done := ctx.Done()
+	if done == nil {
+		return s, func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			runner.RunOnLoop(func(r *goja.Runtime) {
+				select {
+				case <-stop:
+				default:
+					s.Abort(contextReason(r, ctx))
+				}
+			})
+		case <-stop:
+		}
+	}()
+	var once sync.Once
+	return s, func() {
+		once.Do(func() {
+			close(stop)
+		})
+	}
+}
+
+func contextReason(r *goja.Runtime, ctx context.Context) goja.Value {
+	err := ctx.Err()
+	if cause := context.Cause(ctx); cause != nil && cause != err {
+		return r.NewGoError(cause)
+	}
+	if err == context.DeadlineExceeded {
+		return newTimeoutReason(r)
+	}
+	return newAbortReason(r)
+}
@@ -0,0 +1,184 @@
+package abort
+
+import (
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+)
+
+const (
+	phaseNone     = 0
+	phaseAtTarget = 2
+)
+
+type event struct {
+	typ        string
+	bubbles    bool
+	cancelable bool
+	composed   bool
+	isTrusted  bool
+	timeStamp  float64
+
+	target        goja.Value
+	currentTarget goja.Value
+	phase         int
+
+	canceled                 bool
+	stopPropagation          bool
+	stopImmediatePropagation bool
+	inPassiveListener        bool
+	dispatching              bool
+}
+
+var symEvent = goja.NewSymbol("event")
+
+func getEvent(v goja.Value) *event {
+	if o, ok := v.(*goja.Object); ok {
+		if s := o.GetSymbol(symEvent); s != nil {
+			if e, ok := s.Export().(*event); ok {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (m *abortModule) toEvent(v goja.Value) *event {
+	if e := getEvent(v); e != nil {
+		return e
+	}
+	panic(newInvalidThisError(m.r, "Event"))
+}
+
+func (m *abortModule) initEvent(o *goja.Object, e *event) {
+	e.timeStamp = float64(time.Since(m.start)) / float64(time.Millisecond)
+	o.DefineDataPropertySymbol(symEvent, m.r.ToValue(e), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+// newEvent creates a trusted event, i.e. one that is dispatched by the implementation rather than by a script.
+func (m *abortModule) newEvent(typ string) (*goja.Object, *event) {
+	o := m.r.CreateObject(m.eventProto)
+	e := &event{
+		typ:       typ,
+		isTrusted: true,
+	}
+	m.initEvent(o, e)
+	return o, e
+}
+
+func (m *abortModule) createEventConstructor() {
+	r := m.r
+	m.eventCtor, m.eventProto = m.newClass("Event", func(call goja.ConstructorCall) {
+		if len(call.Arguments) == 0 {
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "type" argument must be specified`))
+		}
+		e := &event{
+			typ: call.Argument(0).String(),
+		}
+		if opts := call.Argument(1); !isNullish(opts) {
+			o, ok := opts.(*goja.Object)
+			if !ok {
+				panic(errors.NewNotCorrectTypeError(r, "options", "object"))
+			}
+			e.bubbles = optionFlag(o, "bubbles")
+			e.cancelable = optionFlag(o, "cancelable")
+			e.composed = optionFlag(o, "composed")
+		}
+		m.initEvent(call.This, e)
+	})
+
+	for i, name := range []string{"NONE", "CAPTURING_PHASE", "AT_TARGET", "BUBBLING_PHASE"} {
+		m.eventCtor.DefineDataProperty(name, r.ToValue(i), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_TRUE)
+		m.eventProto.DefineDataProperty(name, r.ToValue(i), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	}
+
+	p := m.eventProto
+	getter := func(get func(e *event) goja.Value) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			return get(m.toEvent(call.This))
+		}
+	}
+	m.defineAccessor(p, "type", getter(func(e *event) goja.Value {
+		return r.ToValue(e.typ)
+	}), nil)
+	m.defineAccessor(p, "bubbles", getter(func(e *event) goja.Value {
+		return r.ToValue(e.bubbles)
+	}), nil)
+	m.defineAccessor(p, "cancelable", getter(func(e *event) goja.Value {
+		return r.ToValue(e.cancelable)
+	}), nil)
+	m.defineAccessor(p, "composed", getter(func(e *event) goja.Value {
+		return r.ToValue(e.composed)
+	}), nil)
+	m.defineAccessor(p, "isTrusted", getter(func(e *event) goja.Value {
+		return r.ToValue(e.isTrusted)
+	}), nil)
+	m.defineAccessor(p, "timeStamp", getter(func(e *event) goja.Value {
+		return r.ToValue(e.timeStamp)
+	}), nil)
+	m.defineAccessor(p, "defaultPrevented", getter(func(e *event) goja.Value {
+		return r.ToValue(e.cancelable && e.canceled)
+	}), nil)
+	m.defineAccessor(p, "returnValue", getter(func(e *event) goja.Value {
+		return r.ToValue(!e.cancelable || !e.canceled)
+	}), nil)
+	m.defineAccessor(p, "eventPhase", getter(func(e *event) goja.Value {
+		return r.ToValue(e.phase)
+	}), nil)
+	targetGetter := getter(func(e *event) goja.Value {
+		if e.target == nil {
+			return goja.Null()
+		}
+		return e.target
+	})
+	m.defineAccessor(p, "target", targetGetter, nil)
+	m.defineAccessor(p, "srcElement", targetGetter, nil)
+	m.defineAccessor(p, "currentTarget", getter(func(e *event) goja.Value {
+		if e.currentTarget == nil {
+			return goja.Null()
+		}
+		return e.currentTarget
+	}), nil)
+	m.defineAccessor(p, "cancelBubble", getter(func(e *event) goja.Value {
+		return r.ToValue(e.stopPropagation)
+	}), func(call goja.FunctionCall) goja.Value {
+		e := m.toEvent(call.This)
+		if call.Argument(0).ToBoolean() {
+			e.stopPropagation = true
+		}
+		return goja.Undefined()
+	})
+
+	p.Set("preventDefault", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		e := m.toEvent(call.This)
+		if e.cancelable && !e.inPassiveListener {
+			e.canceled = true
+		}
+		return goja.Undefined()
+	}))
+	p.Set("stopPropagation", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		m.toEvent(call.This).stopPropagation = true
+		return goja.Undefined()
+	}))
+	p.Set("stopImmediatePropagation", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		e := m.toEvent(call.This)
+		e.stopPropagation = true
+		e.stopImmediatePropagation = true
+		return goja.Undefined()
+	}))
+	p.Set("composedPath", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		e := m.toEvent(call.This)
+		if e.dispatching && e.currentTarget != nil {
+			return r.NewArray(e.currentTarget)
+		}
+		return r.NewArray()
+	}))
+}
+
+func optionFlag(o *goja.Object, name string) bool {
+	if v := o.Get(name); v != nil {
+		return v.ToBoolean()
+	}
+	return false
+}
@@ -0,0 +1,302 @@
+package abort
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/process"
+)
+
+type listener struct {
+	callback goja.Value
+	capture  bool
+	once     bool
+	passive  bool
+	removed  bool
+
+	// removes the abort algorithm added for the 'signal' option
+	unsubscribe func()
+}
+
+// eventHandler is an event handler attribute, such as AbortSignal.prototype.onabort.
+type eventHandler struct {
+	value    goja.Value
+	listener *listener
+}
+
+type eventTarget struct {
+	// The slices are never modified in place, so that a dispatch can iterate over a snapshot.
+	listeners map[string][]*listener
+	handlers  map[string]*eventHandler
+}
+
+var symEventTarget = goja.NewSymbol("eventTarget")
+
+func getEventTarget(v goja.Value) *eventTarget {
+	if o, ok := v.(*goja.Object); ok {
+		if s := o.GetSymbol(symEventTarget); s != nil {
+			if t, ok := s.Export().(*eventTarget); ok {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func (m *abortModule) toEventTarget(v goja.Value) *eventTarget {
+	if t := getEventTarget(v); t != nil {
+		return t
+	}
+	panic(newInvalidThisError(m.r, "EventTarget"))
+}
+
+func (m *abortModule) initEventTarget(o *goja.Object) *eventTarget {
+	t := &eventTarget{
+		listeners: make(map[string][]*listener),
+	}
+	o.DefineDataPropertySymbol(symEventTarget, m.r.ToValue(t), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return t
+}
+
+func (t *eventTarget) find(typ string, callback goja.Value, capture bool) *listener {
+	for _, l := range t.listeners[typ] {
+		if l.capture == capture && l.callback.SameAs(callback) {
+			return l
+		}
+	}
+	return nil
+}
+
+func (t *eventTarget) add(typ string, l *listener) {
+	t.listeners[typ] = append(t.listeners[typ], l)
+}
+
+func (t *eventTarget) remove(typ string, l *listener) {
+	if l.removed {
+		return
+	}
+	l.removed = true
+	if l.unsubscribe != nil {
+		l.unsubscribe()
+		l.unsubscribe = nil
+	}
+	list := t.listeners[typ]
+	newList := make([]*listener, 0, len(list))
+	for _, l1 := range list {
+		if l1 != l {
+			newList = append(newList, l1)
+		}
+	}
+	if len(newList) > 0 {
+		t.listeners[typ] = newList
+	} else {
+		delete(t.listeners, typ)
+	}
+}
+
+func (m *abortModule) createEventTargetConstructor() {
+	r := m.r
+	m.eventTargetCtor, m.eventTargetProto = m.newClass("EventTarget", func(call goja.ConstructorCall) {
+		m.initEventTarget(call.This)
+	})
+
+	p := m.eventTargetProto
+	p.Set("addEventListener", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		t := m.toEventTarget(call.This)
+		if len(call.Arguments) < 2 {
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "type" and "listener" arguments must be specified`))
+		}
+		callback := call.Argument(1)
+		if isNullish(callback) {
+			return goja.Undefined()
+		}
+		if _, ok := callback.(*goja.Object); !ok {
+			panic(errors.NewTypeError(r, errors.ErrCodeInvalidArgType, `The "listener" argument must be an instance of EventListener.`))
+		}
+		typ := call.Argument(0).String()
+		l := &listener{
+			callback: callback,
+		}
+		var signal *Signal
+		switch opts := call.Argument(2).(type) {
+		case *goja.Object:
+			l.capture = optionFlag(opts, "capture")
+			l.once = optionFlag(opts, "once")
+			l.passive = optionFlag(opts, "passive")
+			if v := opts.Get("signal"); !isNullish(v) {
+				signal = GetSignal(v)
+				if signal == nil {
+					panic(errors.NewTypeError(r, errors.ErrCodeInvalidArgType, `The "options.signal" property must be an instance of AbortSignal.`))
+				}
+			}
+		default:
+			l.capture = opts.ToBoolean()
+		}
+		if signal != nil && signal.aborted {
+			return goja.Undefined()
+		}
+		if t.find(typ, callback, l.capture) != nil {
+			return goja.Undefined()
+		}
+		t.add(typ, l)
+		if signal != nil {
+			l.unsubscribe = signal.OnAbort(func() {
+				t.remove(typ, l)
+			})
+		}
+		return goja.Undefined()
+	}))
+
+	p.Set("removeEventListener", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		t := m.toEventTarget(call.This)
+		if len(call.Arguments) < 2 {
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "type" and "listener" arguments must be specified`))
+		}
+		var capture bool
+		switch opts := call.Argument(2).(type) {
+		case *goja.Object:
+			capture = optionFlag(opts, "capture")
+		default:
+			capture = opts.ToBoolean()
+		}
+		typ := call.Argument(0).String()
+		if l := t.find(typ, call.Argument(1), capture); l != nil {
+			t.remove(typ, l)
+		}
+		return goja.Undefined()
+	}))
+
+	p.Set("dispatchEvent", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		t := m.toEventTarget(call.This)
+		ev := call.Argument(0)
+		e := getEvent(ev)
+		if e == nil {
+			panic(errors.NewTypeError(r, errors.ErrCodeInvalidArgType, `The "event" argument must be an instance of Event.`))
+		}
+		if e.dispatching {
+			panic(errors.NewTypeError(r, errors.ErrCodeEventRecursion, `The event "%s" is already being dispatched`, e.typ))
+		}
+		return r.ToValue(m.dispatch(call.This.(*goja.Object), t, ev.(*goja.Object), e))
+	}))
+}
+
+// dispatch calls the listeners and returns false if the event has been cancelled. Exceptions thrown by the
+// listeners are reported as uncaught exceptions (see reportException()).
+func (m *abortModule) dispatch(target *goja.Object, t *eventTarget, ev *goja.Object, e *event) bool {
+	e.dispatching = true
+	e.target = target
+	e.currentTarget = target
+	e.phase = phaseAtTarget
+	defer func() {
+		e.dispatching = false
+		e.currentTarget = nil
+		e.phase = phaseNone
+		e.stopPropagation = false
+		e.stopImmediatePropagation = false
+		e.inPassiveListener = false
+	}()
+
+	var unreported *goja.Exception
+	for _, l := range t.listeners[e.typ] {
+		if l.removed {
+			continue
+		}
+		if l.once {
+			t.remove(e.typ, l)
+		}
+		e.inPassiveListener = l.passive
+		err := m.callListener(l, target, ev)
+		e.inPassiveListener = false
+		if err != nil {
+			ex, ok := err.(*goja.Exception)
+			if !ok {
+				// uncatchable, e.g. *goja.InterruptedError
+				panic(err)
+			}
+			if !m.reportException(ex) && unreported == nil {
+				unreported = ex
+			}
+		}
+		if e.stopImmediatePropagation {
+			break
+		}
+	}
+	if unreported != nil {
+		panic(unreported)
+	}
+	return !e.cancelable || !e.canceled
+}
+
+func (m *abortModule) callListener(l *listener, target, ev goja.Value) error {
+	if fn, ok := goja.AssertFunction(l.callback); ok {
+		_, err := fn(target, ev)
+		return err
+	}
+	if o, ok := l.callback.(*goja.Object); ok {
+		if fn, ok := goja.AssertFunction(o.Get("handleEvent")); ok {
+			_, err := fn(o, ev)
+			return err
+		}
+	}
+	return nil
+}
+
+// reportException re-throws the exception from a process.nextTick() callback (the same way nodejs does), so
+// that it is handled by the loop as an uncaught exception. Returns false if process.nextTick() is not available
+// (i.e. the runtime is not run by an eventloop.EventLoop), in which case the exception is re-thrown by
+// dispatchEvent() once all listeners have been called.
+func (m *abortModule) reportException(ex *goja.Exception) bool {
+	if _, ok := goja.AssertFunction(m.r.Get("require")); !ok {
+		return false
+	}
+	nextTick, ok := goja.AssertFunction(process.GetApi(m.r).Object().Get("nextTick"))
+	if !ok {
+		return false
+	}
+	_, err := nextTick(nil, m.r.ToValue(func(goja.FunctionCall) goja.Value {
+		panic(ex)
+	}))
+	return err == nil
+}
+
+// defineEventHandler defines an event handler attribute (such as 'onabort') on the prototype.
+func (m *abortModule) defineEventHandler(p *goja.Object, typ string) {
+	r := m.r
+	m.defineAccessor(p, "on"+typ, func(call goja.FunctionCall) goja.Value {
+		t := m.toEventTarget(call.This)
+		if h := t.handlers[typ]; h != nil {
+			return h.value
+		}
+		return goja.Null()
+	}, func(call goja.FunctionCall) goja.Value {
+		t := m.toEventTarget(call.This)
+		h := t.handlers[typ]
+		v := call.Argument(0)
+		if _, ok := goja.AssertFunction(v); !ok {
+			if h != nil {
+				t.remove(typ, h.listener)
+				delete(t.handlers, typ)
+			}
+			return goja.Undefined()
+		}
+		if h == nil {
+			h = &eventHandler{}
+			h.listener = &listener{
+				callback: r.ToValue(func(call goja.FunctionCall) goja.Value {
+					if fn, ok := goja.AssertFunction(h.value); ok {
+						if _, err := fn(call.This, call.Argument(0)); err != nil {
+							panic(err)
+						}
+					}
+					return goja.Undefined()
+				}),
+			}
+			if t.handlers == nil {
+				t.handlers = make(map[string]*eventHandler)
+			}
+			t.handlers[typ] = h
+			t.add(typ, h.listener)
+		}
+		h.value = v
+		return goja.Undefined()
+	})
+}
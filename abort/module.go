@@ -0,0 +1,105 @@
+// Package abort implements the WHATWG EventTarget, Event, AbortController and AbortSignal classes.
+//
+// AbortSignal.timeout() uses the timers of the eventloop.EventLoop the runtime is run by, i.e. it is only available
+// if the runtime has been created by an EventLoop.
+package abort
+
+import (
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/goutil"
+)
+
+type abortModule struct {
+	r     *goja.Runtime
+	start time.Time
+
+	eventTargetCtor  *goja.Object
+	eventTargetProto *goja.Object
+	eventCtor        *goja.Object
+	eventProto       *goja.Object
+	signalCtor       *goja.Object
+	signalProto      *goja.Object
+	controllerCtor   *goja.Object
+	controllerProto  *goja.Object
+}
+
+var symModule = goja.NewSymbol("abort")
+
+// getModule returns the module instance for the runtime creating it if necessary. The instance is kept in a
+// non-enumerable symbol property of the global object.
+func getModule(r *goja.Runtime) *abortModule {
+	global := r.GlobalObject()
+	if v := global.GetSymbol(symModule); v != nil {
+		if m, ok := v.Export().(*abortModule); ok {
+			return m
+		}
+	}
+	m := &abortModule{
+		r:     r,
+		start: time.Now(),
+	}
+	m.createEventConstructor()
+	m.createEventTargetConstructor()
+	m.createAbortSignalConstructor()
+	m.createAbortControllerConstructor()
+	global.DefineDataPropertySymbol(symModule, r.ToValue(m), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return m
+}
+
+// Enable adds EventTarget, Event, AbortController and AbortSignal to the global object.
+func Enable(runtime *goja.Runtime) {
+	m := getModule(runtime)
+	runtime.Set("EventTarget", m.eventTargetCtor)
+	runtime.Set("Event", m.eventCtor)
+	runtime.Set("AbortController", m.controllerCtor)
+	runtime.Set("AbortSignal", m.signalCtor)
+}
+
+// newClass is like goutil.NewClass(), but also sets Symbol.toStringTag of the prototype to the name.
+func (m *abortModule) newClass(name string, ctor func(call goja.ConstructorCall)) (*goja.Object, *goja.Object) {
+	c, proto := goutil.NewClass(m.r, name, ctor)
+	proto.DefineDataPropertySymbol(goja.SymToStringTag, m.r.ToValue(name), goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	return c, proto
+}
+
+func (m *abortModule) defineAccessor(p *goja.Object, name string, getter func(call goja.FunctionCall) goja.Value, setter func(call goja.FunctionCall) goja.Value) {
+	var getterVal, setterVal goja.Value
+	if getter != nil {
+		getterVal = m.r.ToValue(getter)
+	}
+	if setter != nil {
+		setterVal = m.r.ToValue(setter)
+	}
+	p.DefineAccessorProperty(name, getterVal, setterVal, goja.FLAG_FALSE, goja.FLAG_TRUE)
+}
+
+func newInvalidThisError(r *goja.Runtime, typ string) *goja.Object {
+	return errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type %s`, typ)
+}
+
+// newDOMException creates an error that resembles a DOMException with the specified name and legacy code.
+func newDOMException(r *goja.Runtime, name, msg string, code int) *goja.Object {
+	ctor, _ := r.Get("Error").(*goja.Object)
+	e, err := r.New(ctor, r.ToValue(msg))
+	if err != nil {
+		panic(err)
+	}
+	e.DefineDataProperty("name", r.ToValue(name), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	e.DefineDataProperty("code", r.ToValue(code), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	return e
+}
+
+func newAbortReason(r *goja.Runtime) *goja.Object {
+	return newDOMException(r, "AbortError", "This operation was aborted", 20)
+}
+
+func newTimeoutReason(r *goja.Runtime) *goja.Object {
+	return newDOMException(r, "TimeoutError", "The operation was aborted due to timeout", 23)
+}
+
+func isNullish(v goja.Value) bool {
+	return v == nil || goja.IsUndefined(v) || goja.IsNull(v)
+}
@@ -0,0 +1,265 @@
+package abort
+
+import (
+	"math"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+// Signal is the Go side of an AbortSignal. All its methods must be called from the goroutine that runs the
+// runtime (i.e. the loop).
+type Signal struct {
+	m       *abortModule
+	obj     *goja.Object
+	target  *eventTarget
+	aborted bool
+	reason  goja.Value
+
+	algorithms []*abortAlgorithm
+}
+
+type abortAlgorithm struct {
+	fn func()
+}
+
+var (
+	symSignal     = goja.NewSymbol("signal")
+	symController = goja.NewSymbol("controller")
+)
+
+// GetSignal returns the Signal for an AbortSignal object, or nil if the value is not an AbortSignal.
+func GetSignal(v goja.Value) *Signal {
+	if o, ok := v.(*goja.Object); ok {
+		if s := o.GetSymbol(symSignal); s != nil {
+			if signal, ok := s.Export().(*Signal); ok {
+				return signal
+			}
+		}
+	}
+	return nil
+}
+
+// NewSignal creates an AbortSignal which can only be aborted from Go (see Signal.Abort()).
+func NewSignal(runtime *goja.Runtime) *Signal {
+	return getModule(runtime).newSignal()
+}
+
+func (m *abortModule) newSignal() *Signal {
+	o := m.r.CreateObject(m.signalProto)
+	s := &Signal{
+		m:      m,
+		obj:    o,
+		target: m.initEventTarget(o),
+		reason: goja.Undefined(),
+	}
+	o.DefineDataPropertySymbol(symSignal, m.r.ToValue(s), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return s
+}
+
+// Object returns the AbortSignal object.
+func (s *Signal) Object() *goja.Object {
+	return s.obj
+}
+
+// Aborted returns true if the signal has been aborted.
+func (s *Signal) Aborted() bool {
+	return s.aborted
+}
+
+// Reason returns the abort reason, or undefined if the signal has not been aborted.
+func (s *Signal) Reason() goja.Value {
+	return s.reason
+}
+
+// Abort aborts the signal, the same way AbortController.prototype.abort() does. If the reason is nil or
+// undefined, an 'AbortError' DOMException is used. Exceptions thrown by the 'abort' event listeners are
+// reported as uncaught exceptions.
+func (s *Signal) Abort(reason goja.Value) {
+	if s.aborted {
+		return
+	}
+	if reason == nil || goja.IsUndefined(reason) {
+		reason = newAbortReason(s.m.r)
+	}
+	s.aborted = true
+	s.reason = reason
+	algorithms := s.algorithms
+	s.algorithms = nil
+	for _, a := range algorithms {
+		a.fn()
+	}
+	ev, e := s.m.newEvent("abort")
+	s.m.dispatch(s.obj, s.target, ev, e)
+}
+
+// OnAbort registers a function that is called when the signal is aborted, before the 'abort' event is dispatched.
+// Unlike event listeners it cannot be removed by scripts. Returns a function that unregisters it.
+// If the signal has already been aborted, fn is never called.
+func (s *Signal) OnAbort(fn func()) func() {
+	a := &abortAlgorithm{fn: fn}
+	s.algorithms = append(s.algorithms, a)
+	return func() {
+		for i, a1 := range s.algorithms {
+			if a1 == a {
+				copy(s.algorithms[i:], s.algorithms[i+1:])
+				s.algorithms[len(s.algorithms)-1] = nil
+				s.algorithms = s.algorithms[:len(s.algorithms)-1]
+				break
+			}
+		}
+	}
+}
+
+func (m *abortModule) toSignal(v goja.Value) *Signal {
+	if s := GetSignal(v); s != nil {
+		return s
+	}
+	panic(newInvalidThisError(m.r, "AbortSignal"))
+}
+
+func (m *abortModule) createAbortSignalConstructor() {
+	r := m.r
+	m.signalCtor, m.signalProto = m.newClass("AbortSignal", func(call goja.ConstructorCall) {
+		panic(errors.NewTypeError(r, errors.ErrCodeIllegalConstructor, "Illegal constructor"))
+	})
+	m.signalCtor.SetPrototype(m.eventTargetCtor)
+	m.signalProto.SetPrototype(m.eventTargetProto)
+
+	p := m.signalProto
+	m.defineAccessor(p, "aborted", func(call goja.FunctionCall) goja.Value {
+		return r.ToValue(m.toSignal(call.This).aborted)
+	}, nil)
+	m.defineAccessor(p, "reason", func(call goja.FunctionCall) goja.Value {
+		return m.toSignal(call.This).reason
+	}, nil)
+	p.Set("throwIfAborted", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		if s := m.toSignal(call.This); s.aborted {
+			panic(s.reason)
+		}
+		return goja.Undefined()
+	}))
+	m.defineEventHandler(p, "abort")
+
+	c := m.signalCtor
+	c.Set("abort", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		s := m.newSignal()
+		s.Abort(call.Argument(0))
+		return s.obj
+	}))
+	c.Set("timeout", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		return m.timeoutSignal(call.Argument(0)).obj
+	}))
+	c.Set("any", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		return m.anySignal(call.Argument(0)).obj
+	}))
+}
+
+// timeoutSignal creates a signal that is aborted with a 'TimeoutError' DOMException after the specified number of
+// milliseconds. The timer is created by the 'timers' module and does not keep the loop alive.
+func (m *abortModule) timeoutSignal(delay goja.Value) *Signal {
+	r := m.r
+	switch delay.Export().(type) {
+	case int64, float64:
+	default:
+		panic(errors.NewArgumentNotNumberTypeError(r, "delay"))
+	}
+	if f := delay.ToFloat(); f < 0 || f > math.MaxUint32 || f != math.Trunc(f) {
+		panic(errors.NewArgumentOutOfRangeError(r, "delay", delay))
+	}
+
+	s := m.newSignal()
+	timers := require.Require(r, "timers").ToObject(r)
+	setTimeout, _ := goja.AssertFunction(timers.Get("setTimeout"))
+	t, err := setTimeout(nil, r.ToValue(func(goja.FunctionCall) goja.Value {
+		s.Abort(newTimeoutReason(r))
+		return goja.Undefined()
+	}), delay)
+	if err != nil {
+		panic(err)
+	}
+	timer := t.ToObject(r)
+	if unref, ok := goja.AssertFunction(timer.Get("unref")); ok {
+		if _, err := unref(timer); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// anySignal creates a signal that is aborted when any of the signals in the iterable is aborted. Once any of them
+// is aborted, the result unsubscribes from all of them. Until then, each source keeps a reference to the result,
+// so a result that is never aborted lives as long as the longest-lived of its sources (e.g. a signal combined
+// with a long-lived one is only released when that one is aborted or becomes unreachable).
+func (m *abortModule) anySignal(signals goja.Value) *Signal {
+	r := m.r
+	if _, ok := signals.(*goja.Object); !ok {
+		panic(errors.NewNotCorrectTypeError(r, "signals", "Array"))
+	}
+	var sources []*Signal
+	seen := make(map[*Signal]struct{})
+	i := 0
+	r.ForOf(signals, func(v goja.Value) bool {
+		s := GetSignal(v)
+		if s == nil {
+			panic(errors.NewTypeError(r, errors.ErrCodeInvalidArgType, `The "signals[%d]" argument must be an instance of AbortSignal.`, i))
+		}
+		i++
+		// a signal passed more than once is only subscribed to once
+		if _, exists := seen[s]; !exists {
+			seen[s] = struct{}{}
+			sources = append(sources, s)
+		}
+		return true
+	})
+
+	res := m.newSignal()
+	for _, s := range sources {
+		if s.aborted {
+			res.Abort(s.reason)
+			return res
+		}
+	}
+	unsubscribe := make([]func(), 0, len(sources))
+	for _, s := range sources {
+		s := s
+		unsubscribe = append(unsubscribe, s.OnAbort(func() {
+			res.Abort(s.reason)
+		}))
+	}
+	res.OnAbort(func() {
+		for _, f := range unsubscribe {
+			f()
+		}
+	})
+	return res
+}
+
+func (m *abortModule) createAbortControllerConstructor() {
+	r := m.r
+	m.controllerCtor, m.controllerProto = m.newClass("AbortController", func(call goja.ConstructorCall) {
+		s := m.newSignal()
+		call.This.DefineDataPropertySymbol(symController, r.ToValue(s), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	})
+
+	toController := func(v goja.Value) *Signal {
+		if o, ok := v.(*goja.Object); ok {
+			if s := o.GetSymbol(symController); s != nil {
+				if signal, ok := s.Export().(*Signal); ok {
+					return signal
+				}
+			}
+		}
+		panic(newInvalidThisError(r, "AbortController"))
+	}
+
+	p := m.controllerProto
+	m.defineAccessor(p, "signal", func(call goja.FunctionCall) goja.Value {
+		return toController(call.This).obj
+	}, nil)
+	p.Set("abort", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		toController(call.This).Abort(call.Argument(0))
+		return goja.Undefined()
+	}))
+}
@@ -1,17 +1,28 @@
 package errors
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
+	"io/fs"
 
 	"github.com/dop251/goja"
 )
 
 const (
-	ErrCodeInvalidArgType  = "ERR_INVALID_ARG_TYPE"
-	ErrCodeInvalidArgValue = "ERR_INVALID_ARG_VALUE"
-	ErrCodeInvalidThis     = "ERR_INVALID_THIS"
-	ErrCodeMissingArgs     = "ERR_MISSING_ARGS"
-	ErrCodeOutOfRange      = "ERR_OUT_OF_RANGE"
+	ErrCodeAbort                            = "ABORT_ERR"
+	ErrCodeEventRecursion                   = "ERR_EVENT_RECURSION"
+	ErrCodeGoError                          = "ERR_GO_ERROR"
+	ErrCodeIllegalConstructor               = "ERR_ILLEGAL_CONSTRUCTOR"
+	ErrCodeInvalidArgType                   = "ERR_INVALID_ARG_TYPE"
+	ErrCodeInvalidArgValue                  = "ERR_INVALID_ARG_VALUE"
+	ErrCodeInvalidThis                      = "ERR_INVALID_THIS"
+	ErrCodeMissingArgs                      = "ERR_MISSING_ARGS"
+	ErrCodeOutOfRange                       = "ERR_OUT_OF_RANGE"
+	ErrCodePerformanceInvalidTimestamp      = "ERR_PERFORMANCE_INVALID_TIMESTAMP"
+	ErrCodePerformanceMeasureInvalidOptions = "ERR_PERFORMANCE_MEASURE_INVALID_OPTIONS"
+	ErrCodeWorkerPath                       = "ERR_WORKER_PATH"
+	ErrCodeWorkerUnserializableError        = "ERR_WORKER_UNSERIALIZABLE_ERROR"
 )
 
 func error_toString(call goja.FunctionCall, r *goja.Runtime) goja.Value {
@@ -95,3 +106,50 @@ func NewNotCorrectTypeError(r *goja.Runtime, name, _type string) *goja.Object {
 func NewArgumentOutOfRangeError(r *goja.Runtime, name string, v any) *goja.Object {
 	return NewRangeError(r, ErrCodeOutOfRange, "The value of \"%s\" %v is out of range.", name, v)
 }
+
+// NewAbortError creates an AbortError, which is used to reject the operations cancelled by an AbortSignal.
+// If cause is not nil or undefined (typically it is signal.reason), it is set as the 'cause' property.
+func NewAbortError(r *goja.Runtime, cause goja.Value) *goja.Object {
+	e := NewError(r, nil, ErrCodeAbort, "The operation was aborted")
+	e.DefineDataProperty("name", r.ToValue("AbortError"), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	if cause != nil && !goja.IsUndefined(cause) {
+		e.DefineDataProperty("cause", cause, goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	}
+	return e
+}
+
+// Coder is implemented by Go errors that carry a Node.js-style error code (such as "ENOENT" or
+// "ERR_INVALID_ARG_VALUE"), which is used by NewGoError() as the 'code' property.
+type Coder interface {
+	Code() string
+}
+
+// NewGoError converts a Go error into an Error with a 'code' property. The code is determined as follows:
+//   - if the error (or any error it wraps) implements Coder, its Code() is used;
+//   - context.Canceled and context.DeadlineExceeded are converted into an AbortError (see NewAbortError());
+//   - fs.ErrNotExist, fs.ErrExist and fs.ErrPermission become "ENOENT", "EEXIST" and "EACCES" respectively;
+//   - otherwise the code is ErrCodeGoError.
+//
+// The message is err.Error(). Like the errors created by goja.Runtime.NewGoError(), the resulting object has a
+// 'value' property holding the original Go error.
+func NewGoError(r *goja.Runtime, err error) *goja.Object {
+	var e *goja.Object
+	var coder Coder
+	switch {
+	case stderrors.As(err, &coder):
+		e = NewError(r, nil, coder.Code(), "%s", err.Error())
+	case stderrors.Is(err, context.Canceled), stderrors.Is(err, context.DeadlineExceeded):
+		e = NewAbortError(r, nil)
+		e.Set("message", err.Error())
+	case stderrors.Is(err, fs.ErrNotExist):
+		e = NewError(r, nil, "ENOENT", "%s", err.Error())
+	case stderrors.Is(err, fs.ErrExist):
+		e = NewError(r, nil, "EEXIST", "%s", err.Error())
+	case stderrors.Is(err, fs.ErrPermission):
+		e = NewError(r, nil, "EACCES", "%s", err.Error())
+	default:
+		e = NewError(r, nil, ErrCodeGoError, "%s", err.Error())
+	}
+	e.DefineDataProperty("value", r.ToValue(err), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	return e
+}
@@ -0,0 +1,104 @@
+package eventloop
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+)
+
+// AsyncFunc wraps a blocking Go function so that it can be called from scripts. Every call of the returned
+// function returns a Promise and runs fn in a separate goroutine. The promise is resolved with the value returned
+// by fn (converted using goja.Runtime.ToValue() on the loop, so it must not be a goja.Value derived from a
+// different runtime), or rejected with the error converted by errors.NewGoError(), i.e. an Error with a
+// Node.js-style 'code' property. If fn panics, the promise is rejected with the *PanicError converted the same
+// way. The loop is kept alive until the promise is settled.
+//
+// The context passed to fn is cancelled when the loop is terminated (see Terminate() and Shutdown()), after which
+// the promise is never settled, even if the loop is restarted. The number of concurrently running functions can be
+// limited using WithAsyncConcurrency().
+//
+// fn is called with the arguments of the call. Because it does not run on the loop, it must not call any methods
+// of the runtime or of the objects that belong to it (such as goja.Object.Get() or Export() of an object); only
+// the primitive values (strings, numbers, booleans etc.) can be used safely. Objects should be converted into Go
+// values by a JavaScript wrapper or passed in a different way.
+//
+// The returned function can be installed into the runtime using goja.Runtime.Set() or goja.Runtime.ToValue():
+//
+//	loop.Run(func(vm *goja.Runtime) {
+//		vm.Set("readFile", eventloop.AsyncFunc(loop, func(ctx context.Context, args []goja.Value) (any, error) {
+//			data, err := os.ReadFile(args[0].String())
+//			return string(data), err
+//		}))
+//	})
+func AsyncFunc(loop *EventLoop, fn func(ctx context.Context, args []goja.Value) (any, error)) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		args := append([]goja.Value(nil), call.Arguments...)
+		p, settle := loop.newPendingPromise()
+		ctx := loop.asyncContext()
+		go func() {
+			res, err := loop.runAsync(ctx, fn, args)
+			settle(func(resolve, reject func(interface{}) error) {
+				if err != nil {
+					_ = reject(errors.NewGoError(loop.vm, err))
+				} else {
+					_ = resolve(res)
+				}
+			})
+		}()
+		return loop.vm.ToValue(p)
+	}
+}
+
+// WithAsyncConcurrency limits the number of the AsyncFunc functions that run concurrently on behalf of the loop.
+// The calls exceeding the limit wait until one of the running functions returns (or the loop is terminated).
+// By default, the number is not limited.
+func WithAsyncConcurrency(n int) Option {
+	return func(loop *EventLoop) {
+		if n > 0 {
+			loop.asyncSem = make(chan struct{}, n)
+		} else {
+			loop.asyncSem = nil
+		}
+	}
+}
+
+// asyncContext returns the context for the AsyncFunc functions, which is cancelled by Terminate().
+func (loop *EventLoop) asyncContext() context.Context {
+	loop.auxJobsLock.Lock()
+	defer loop.auxJobsLock.Unlock()
+	if loop.terminated {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	if loop.asyncCtx == nil {
+		loop.asyncCtx, loop.asyncCancel = context.WithCancel(context.Background())
+	}
+	return loop.asyncCtx
+}
+
+func (loop *EventLoop) runAsync(ctx context.Context, fn func(context.Context, []goja.Value) (any, error), args []goja.Value) (res any, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = &PanicError{
+				Value: x,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+	if sem := loop.asyncSem; sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if err := ctx.Err(); err != nil {
+			// the slot has been freed by a function that has returned because of the cancellation
+			return nil, err
+		}
+	}
+	return fn(ctx, args)
+}
@@ -0,0 +1,212 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+type codeError string
+
+func (e codeError) Error() string {
+	return "custom: " + string(e)
+}
+
+func (e codeError) Code() string {
+	return string(e)
+}
+
+func TestAsyncFunc(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var log = [];
+	const p = work("a", 10);
+	log.push(p instanceof Promise);
+	Promise.allSettled([p, work("fail", 1), work("missing", 1), work("plain", 1)]).then(results => {
+		const [a, fail, missing, plain] = results;
+		log.push(a.value.arg);
+		log.push(fail.reason instanceof Error, fail.reason.code, fail.reason.message);
+		log.push(missing.reason.code);
+		log.push(plain.reason.code, plain.reason.value.Error());
+	});
+	`
+	loop := NewEventLoop()
+	var log string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("work", AsyncFunc(loop, func(ctx context.Context, args []goja.Value) (any, error) {
+			time.Sleep(time.Duration(args[1].ToInteger()) * time.Millisecond)
+			switch s := args[0].String(); s {
+			case "fail":
+				return nil, codeError("ERR_CUSTOM")
+			case "missing":
+				return nil, fs.ErrNotExist
+			case "plain":
+				return nil, errors.New("plain error")
+			default:
+				return map[string]any{"arg": s}, nil
+			}
+		}))
+		_, err := vm.RunString(SCRIPT)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		log = vm.Get("log").String()
+	})
+	const expected = "true,a,true,ERR_CUSTOM,custom: ERR_CUSTOM,ENOENT,ERR_GO_ERROR,plain error"
+	if log != expected {
+		t.Fatal(log)
+	}
+}
+
+func TestAsyncFuncConcurrency(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(WithAsyncConcurrency(2))
+	var running, maxRunning int32
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("work", AsyncFunc(loop, func(ctx context.Context, args []goja.Value) (any, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil, nil
+		}))
+		_, err := vm.RunString(`
+		for (let i = 0; i < 6; i++) {
+			work();
+		}
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m != 2 {
+		t.Fatal(m)
+	}
+}
+
+func TestAsyncFuncTerminate(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(WithAsyncConcurrency(1))
+	var calls int32
+	started := make(chan struct{}, 2)
+	done := make(chan error, 2)
+	loop.Start()
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		vm.Set("work", AsyncFunc(loop, func(ctx context.Context, args []goja.Value) (any, error) {
+			atomic.AddInt32(&calls, 1)
+			started <- struct{}{}
+			<-ctx.Done()
+			done <- ctx.Err()
+			return nil, ctx.Err()
+		}))
+		_, err := vm.RunString(`
+		work();
+		work();
+		`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	<-started
+	loop.Terminate()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	time.Sleep(10 * time.Millisecond)
+	// the second call has been waiting for the first one to return and is not run after the cancellation
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Fatal(c)
+	}
+}
+
+func TestAsyncFuncPanic(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var res string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("work", AsyncFunc(loop, func(ctx context.Context, args []goja.Value) (any, error) {
+			panic("boom")
+		}))
+		vm.Set("report", func(s string) {
+			res = s
+		})
+		_, err := vm.RunString(`
+		work().then(() => report("resolved"), e => report(e.message));
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "panic in event loop job: boom" {
+		t.Fatal(res)
+	}
+}
+
+func TestAsyncFuncAfterRestart(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	loop.Start()
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		vm.Set("work", AsyncFunc(loop, func(ctx context.Context, args []goja.Value) (any, error) {
+			defer close(returned)
+			<-ctx.Done()
+			close(cancelled)
+			<-release
+			return "late", nil
+		}))
+		vm.Set("stale", false)
+		_, err := vm.RunString(`
+		work().then(() => { stale = true; });
+		`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	loop.Terminate()
+	<-cancelled
+
+	loop.Start()
+	defer loop.Terminate()
+	close(release)
+	<-returned
+	// give the result a chance to be delivered
+	time.Sleep(20 * time.Millisecond)
+	v, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.Get("stale"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != false {
+		t.Fatal("a cancelled call has resolved its promise after the loop has been restarted")
+	}
+}
@@ -0,0 +1,359 @@
+package eventloop
+
+import (
+	"reflect"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/goutil"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+const AsyncHooksModuleName = "async_hooks"
+
+// asyncFrame holds the stores of all AsyncLocalStorage instances that are active in an async execution context.
+// It is immutable, so it can be captured when a callback is scheduled and restored when it runs. A nil frame is
+// an empty one.
+type asyncFrame struct {
+	stores map[*asyncStorage]goja.Value
+}
+
+// asyncStorage is the Go side of an AsyncLocalStorage. It only serves as a key in asyncFrame.
+type asyncStorage struct{}
+
+type asyncResource struct {
+	frame          *asyncFrame
+	typ            string
+	asyncId        int64
+	triggerAsyncId int64
+}
+
+var (
+	symStorage       = goja.NewSymbol("asyncStorage")
+	symAsyncResource = goja.NewSymbol("asyncResource")
+)
+
+func (f *asyncFrame) get(s *asyncStorage) goja.Value {
+	if f != nil {
+		if v, ok := f.stores[s]; ok {
+			return v
+		}
+	}
+	return goja.Undefined()
+}
+
+func (f *asyncFrame) with(s *asyncStorage, store goja.Value) *asyncFrame {
+	n := &asyncFrame{}
+	if f != nil {
+		n.stores = make(map[*asyncStorage]goja.Value, len(f.stores)+1)
+		for k, v := range f.stores {
+			n.stores[k] = v
+		}
+	} else {
+		n.stores = make(map[*asyncStorage]goja.Value, 1)
+	}
+	n.stores[s] = store
+	return n
+}
+
+func (f *asyncFrame) without(s *asyncStorage) *asyncFrame {
+	if f == nil {
+		return nil
+	}
+	if _, ok := f.stores[s]; !ok {
+		return f
+	}
+	if len(f.stores) == 1 {
+		return nil
+	}
+	n := &asyncFrame{stores: make(map[*asyncStorage]goja.Value, len(f.stores)-1)}
+	for k, v := range f.stores {
+		if k != s {
+			n.stores[k] = v
+		}
+	}
+	return n
+}
+
+// frameTracker propagates the async execution context to the promise reactions (see goja.AsyncContextTracker).
+type frameTracker struct {
+	loop *EventLoop
+	prev *asyncFrame
+}
+
+func (t *frameTracker) Grab() interface{} {
+	return t.loop.frame
+}
+
+func (t *frameTracker) Resumed(trackingObject interface{}) {
+	t.prev = t.loop.frame
+	t.loop.frame, _ = trackingObject.(*asyncFrame)
+}
+
+func (t *frameTracker) Exited() {
+	t.loop.frame = t.prev
+	t.prev = nil
+}
+
+// bindFrame returns a function that runs f in the current async execution context. It must be called from the
+// loop.
+func (loop *EventLoop) bindFrame(f func()) func() {
+	return loop.withFrame(loop.frame, f)
+}
+
+// captureFrame returns the current async execution context if called from the loop while it's running, or nil
+// otherwise. Unlike bindFrame() it is safe to call from any goroutine.
+func (loop *EventLoop) captureFrame() *asyncFrame {
+	if atomic.LoadInt32(&loop.asyncHooks) == 0 || !loop.onLoop() {
+		return nil
+	}
+	return loop.frame
+}
+
+func (loop *EventLoop) withFrame(frame *asyncFrame, f func()) func() {
+	if frame == nil {
+		return f
+	}
+	return func() {
+		prev := loop.frame
+		loop.frame = frame
+		defer func() {
+			loop.frame = prev
+		}()
+		f()
+	}
+}
+
+// runInFrame calls a JS function in the specified async execution context. Exceptions are re-thrown.
+func (loop *EventLoop) runInFrame(frame *asyncFrame, fn goja.Callable, this goja.Value, args ...goja.Value) goja.Value {
+	prev := loop.frame
+	loop.frame = frame
+	defer func() {
+		loop.frame = prev
+	}()
+	res, err := fn(this, args...)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// onLoop returns true if called from the goroutine that is currently running the loop's jobs. Unless the loop is
+// running a job this is a single atomic load, otherwise the call stack is checked for the frames that run the jobs.
+// The frames are the same for all loops, so a call made by a job of another loop while this loop is running a job
+// is also reported as being on the loop.
+func (loop *EventLoop) onLoop() bool {
+	return atomic.LoadInt32(&loop.executing) != 0 && inLoopFrames()
+}
+
+func (loop *EventLoop) setExecuting(executing bool) {
+	var v int32
+	if executing {
+		v = 1
+	}
+	atomic.StoreInt32(&loop.executing, v)
+}
+
+// loopFrames are the functions that run the loop's jobs.
+var loopFrames = map[string]struct{}{
+	funcName((*EventLoop).run):       {},
+	funcName((*EventLoop).runFunc):   {},
+	funcName((*EventLoop).terminate): {},
+}
+
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+func inLoopFrames() bool {
+	pcs := make([]uintptr, 32)
+	for {
+		n := runtime.Callers(3, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if _, exists := loopFrames[f.Function]; exists {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+func requireAsyncHooks(runtime *goja.Runtime, module *goja.Object) {
+	loop := getLoop(runtime)
+	if atomic.CompareAndSwapInt32(&loop.asyncHooks, 0, 1) {
+		runtime.SetAsyncContextTracker(&frameTracker{loop: loop})
+	}
+	o := module.Get("exports").(*goja.Object)
+	o.Set("AsyncLocalStorage", loop.createAsyncLocalStorage())
+	o.Set("AsyncResource", loop.createAsyncResource())
+}
+
+func (loop *EventLoop) createAsyncLocalStorage() *goja.Object {
+	r := loop.vm
+	ctor, proto := goutil.NewClass(r, "AsyncLocalStorage", func(call goja.ConstructorCall) {
+		call.This.DefineDataPropertySymbol(symStorage, r.ToValue(&asyncStorage{}), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	})
+
+	toStorage := func(v goja.Value) *asyncStorage {
+		if o, ok := v.(*goja.Object); ok {
+			if v := o.GetSymbol(symStorage); v != nil {
+				if s, ok := v.Export().(*asyncStorage); ok {
+					return s
+				}
+			}
+		}
+		panic(errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type AsyncLocalStorage`))
+	}
+
+	proto.Set("getStore", func(call goja.FunctionCall) goja.Value {
+		return loop.frame.get(toStorage(call.This))
+	})
+	proto.Set("run", func(call goja.FunctionCall) goja.Value {
+		s := toStorage(call.This)
+		fn := loop.assertCallback(call.Argument(1))
+		return loop.runInFrame(loop.frame.with(s, call.Argument(0)), fn, nil, restArgs(call, 2)...)
+	})
+	proto.Set("exit", func(call goja.FunctionCall) goja.Value {
+		s := toStorage(call.This)
+		fn := loop.assertCallback(call.Argument(0))
+		return loop.runInFrame(loop.frame.without(s), fn, nil, restArgs(call, 1)...)
+	})
+	proto.Set("enterWith", func(call goja.FunctionCall) goja.Value {
+		s := toStorage(call.This)
+		loop.frame = loop.frame.with(s, call.Argument(0))
+		return goja.Undefined()
+	})
+	proto.Set("disable", func(call goja.FunctionCall) goja.Value {
+		s := toStorage(call.This)
+		loop.frame = loop.frame.without(s)
+		return goja.Undefined()
+	})
+
+	ctor.Set("bind", func(call goja.FunctionCall) goja.Value {
+		fn := loop.assertCallback(call.Argument(0))
+		frame := loop.frame
+		return r.ToValue(func(call goja.FunctionCall) goja.Value {
+			return loop.runInFrame(frame, fn, call.This, call.Arguments...)
+		})
+	})
+	ctor.Set("snapshot", func(goja.FunctionCall) goja.Value {
+		frame := loop.frame
+		return r.ToValue(func(call goja.FunctionCall) goja.Value {
+			fn := loop.assertCallback(call.Argument(0))
+			return loop.runInFrame(frame, fn, nil, restArgs(call, 1)...)
+		})
+	})
+	return ctor
+}
+
+func (loop *EventLoop) createAsyncResource() *goja.Object {
+	r := loop.vm
+	ctor, proto := goutil.NewClass(r, "AsyncResource", func(call goja.ConstructorCall) {
+		typ, ok := call.Argument(0).Export().(string)
+		if !ok {
+			panic(errors.NewArgumentNotStringTypeError(r, "type"))
+		}
+		res := &asyncResource{
+			frame:          loop.frame,
+			typ:            typ,
+			triggerAsyncId: loop.currentAsyncId,
+		}
+		if opts, ok := call.Argument(1).(*goja.Object); ok {
+			if v := opts.Get("triggerAsyncId"); v != nil && !goja.IsUndefined(v) {
+				res.triggerAsyncId = v.ToInteger()
+			}
+		}
+		loop.lastAsyncId++
+		res.asyncId = loop.lastAsyncId
+		call.This.DefineDataPropertySymbol(symAsyncResource, r.ToValue(res), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	})
+
+	toResource := func(v goja.Value) *asyncResource {
+		if o, ok := v.(*goja.Object); ok {
+			if v := o.GetSymbol(symAsyncResource); v != nil {
+				if res, ok := v.Export().(*asyncResource); ok {
+					return res
+				}
+			}
+		}
+		panic(errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type AsyncResource`))
+	}
+
+	bind := func(res *asyncResource, resObj goja.Value, fn goja.Callable, thisArg goja.Value) goja.Value {
+		bound := r.ToValue(func(call goja.FunctionCall) goja.Value {
+			this := thisArg
+			if this == nil || goja.IsUndefined(this) {
+				this = call.This
+			}
+			return loop.runInScope(res, fn, this, call.Arguments...)
+		}).(*goja.Object)
+		bound.DefineDataProperty("asyncResource", resObj, goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+		return bound
+	}
+
+	proto.Set("runInAsyncScope", func(call goja.FunctionCall) goja.Value {
+		res := toResource(call.This)
+		fn := loop.assertCallback(call.Argument(0))
+		return loop.runInScope(res, fn, call.Argument(1), restArgs(call, 2)...)
+	})
+	proto.Set("bind", func(call goja.FunctionCall) goja.Value {
+		res := toResource(call.This)
+		return bind(res, call.This, loop.assertCallback(call.Argument(0)), call.Argument(1))
+	})
+	proto.Set("emitDestroy", func(call goja.FunctionCall) goja.Value {
+		toResource(call.This)
+		return call.This
+	})
+	proto.Set("asyncId", func(call goja.FunctionCall) goja.Value {
+		return r.ToValue(toResource(call.This).asyncId)
+	})
+	proto.Set("triggerAsyncId", func(call goja.FunctionCall) goja.Value {
+		return r.ToValue(toResource(call.This).triggerAsyncId)
+	})
+
+	ctor.Set("bind", func(call goja.FunctionCall) goja.Value {
+		fn := loop.assertCallback(call.Argument(0))
+		typ := call.Argument(1)
+		if goja.IsUndefined(typ) {
+			typ = r.ToValue("bound-anonymous-fn")
+		}
+		resObj, err := r.New(ctor, typ)
+		if err != nil {
+			panic(err)
+		}
+		return bind(toResource(resObj), resObj, fn, call.Argument(2))
+	})
+	return ctor
+}
+
+// runInScope calls a JS function in the async execution context of the resource.
+func (loop *EventLoop) runInScope(res *asyncResource, fn goja.Callable, this goja.Value, args ...goja.Value) goja.Value {
+	prevId := loop.currentAsyncId
+	loop.currentAsyncId = res.asyncId
+	defer func() {
+		loop.currentAsyncId = prevId
+	}()
+	return loop.runInFrame(res.frame, fn, this, args...)
+}
+
+func restArgs(call goja.FunctionCall, start int) []goja.Value {
+	if len(call.Arguments) > start {
+		return call.Arguments[start:]
+	}
+	return nil
+}
+
+func init() {
+	require.RegisterCoreModule(AsyncHooksModuleName, requireAsyncHooks)
+}
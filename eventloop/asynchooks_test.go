@@ -0,0 +1,181 @@
+package eventloop
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestAsyncLocalStorage(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	const { AsyncLocalStorage } = require("node:async_hooks");
+	const als = new AsyncLocalStorage();
+	var log = [];
+	function record(name) {
+		log.push(name + ":" + als.getStore());
+	}
+
+	als.run("a", () => {
+		record("sync");
+		setTimeout(() => record("timeout"), 1);
+		setImmediate(() => record("immediate"));
+		const i = setInterval(() => {
+			record("interval");
+			clearInterval(i);
+		}, 1);
+		require("process").nextTick(() => record("tick"));
+		queueMicrotask(() => record("microtask"));
+		Promise.resolve().then(() => record("then"));
+		(async () => {
+			await null;
+			record("await");
+		})();
+		goCallback(() => record("runOnLoop"));
+		als.exit(() => record("exit"));
+	});
+	record("outside");
+
+	let resolveLater;
+	const later = new Promise(resolve => { resolveLater = resolve; });
+	als.run("b", () => {
+		later.then(() => record("resolved-elsewhere"));
+	});
+	als.run("c", () => resolveLater());
+
+	als.run("d", () => {
+		als.run("e", () => record("nested"));
+		record("restored");
+	});
+
+	setTimeout(() => {
+		als.enterWith("f");
+		record("enterWith");
+		setTimeout(() => record("after-enterWith"), 1);
+	}, 5);
+	setTimeout(() => record("not-leaked"), 10);
+	`
+	loop := NewEventLoop()
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("goCallback", func(cb goja.Callable) {
+			loop.RunOnLoop(func(*goja.Runtime) {
+				cb(nil)
+			})
+		})
+		if _, err := vm.RunString(SCRIPT); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log []string
+	loop.Run(func(vm *goja.Runtime) {
+		if err := vm.ExportTo(vm.Get("log"), &log); err != nil {
+			t.Fatal(err)
+		}
+	})
+	expected := map[string]string{
+		"sync":               "a",
+		"timeout":            "a",
+		"immediate":          "a",
+		"interval":           "a",
+		"tick":               "a",
+		"microtask":          "a",
+		"then":               "a",
+		"await":              "a",
+		"runOnLoop":          "a",
+		"exit":               "undefined",
+		"outside":            "undefined",
+		"resolved-elsewhere": "b",
+		"nested":             "e",
+		"restored":           "d",
+		"enterWith":          "f",
+		"after-enterWith":    "f",
+		"not-leaked":         "undefined",
+	}
+	if len(log) != len(expected) {
+		t.Fatal(log)
+	}
+	for _, entry := range log {
+		if name, store, _ := strings.Cut(entry, ":"); expected[name] != store {
+			t.Errorf("%s: %s", name, store)
+		}
+	}
+}
+
+func TestAsyncLocalStorageGoTimer(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var store string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("goTimeout", func(cb goja.Callable) {
+			loop.SetTimeout(func(*goja.Runtime) {
+				cb(nil)
+			}, time.Millisecond)
+		})
+		_, err := vm.RunString(`
+		const { AsyncLocalStorage } = require("async_hooks");
+		const als = new AsyncLocalStorage();
+		var store;
+		als.run(42, () => goTimeout(() => { store = als.getStore(); }));
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		store = vm.Get("store").String()
+	})
+	if store != "42" {
+		t.Fatal(store)
+	}
+}
+
+func TestAsyncResource(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var res goja.Value
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		var err error
+		res, err = vm.RunString(`
+		const { AsyncLocalStorage, AsyncResource } = require("async_hooks");
+		const als = new AsyncLocalStorage();
+		const log = [];
+		const resource = als.run("captured", () => new AsyncResource("TEST"));
+		const bound = als.run("bound", () => AsyncResource.bind(function(x) {
+			return als.getStore() + x + (this === globalThis ? "" : "!");
+		}));
+		const snapshot = als.run("snapshot", () => AsyncLocalStorage.snapshot());
+		als.run("current", () => {
+			log.push(resource.runInAsyncScope(function(a, b) {
+				return als.getStore() + this.x + a + b;
+			}, { x: 1 }, 2, 3));
+			log.push(bound(4), bound.call({}, 5));
+			log.push(snapshot(() => als.getStore()));
+			log.push(als.getStore());
+		});
+		log.push(resource.asyncId() > 0, bound.asyncResource instanceof AsyncResource);
+		try {
+			new AsyncResource(1);
+		} catch (e) {
+			log.push(e.code);
+		}
+		log.join();
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "captured123,bound4,bound5!,snapshot,current,true,true,ERR_INVALID_ARG_TYPE" {
+		t.Fatal(s)
+	}
+}
@@ -0,0 +1,83 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/dop251/goja"
+)
+
+// ErrTerminated is returned by Call() if the loop is terminated (see Terminate()) or is shutting down (see
+// Shutdown()).
+var ErrTerminated = errors.New("eventloop: the loop is terminated or is shutting down")
+
+const (
+	callPending int32 = iota
+	callStarted
+	callCancelled
+)
+
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+// Call runs fn on the loop (as RunOnLoop() does) and waits until it returns. The value returned by fn is exported
+// (see goja.Value.Export()) on the loop, so the result can be used safely by the calling goroutine. If fn returns
+// an error (such as the *goja.Exception returned by goja.Runtime.RunString()), it is returned as is. If fn panics,
+// a *PanicError is returned and the panic is propagated to the loop.
+//
+// If ctx is done before fn has started, fn is not called and ctx.Err() is returned. If it is done while fn is
+// running, Call returns ctx.Err() without waiting, and the result is discarded.
+//
+// Call returns ErrTerminated if the loop is terminated or is shutting down. The functions submitted before
+// the loop has been terminated run during Terminate(). Note, Call does not return while the loop is stopped
+// (see Stop()) unless ctx is done.
+//
+// Calling it from the loop (i.e. from a function passed to Run() or RunOnLoop(), or from a callback) returns
+// ErrCalledFromLoop.
+func (loop *EventLoop) Call(ctx context.Context, fn func(*goja.Runtime) (goja.Value, error)) (interface{}, error) {
+	if loop.onLoop() {
+		return nil, loop.misuse(ErrCalledFromLoop)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var state int32
+	res := make(chan callResult, 1)
+	ok := loop.submit(loop.withFrame(loop.captureFrame(), func() {
+		if !atomic.CompareAndSwapInt32(&state, callPending, callStarted) {
+			return
+		}
+		returned := false
+		defer func() {
+			if !returned {
+				x := recover()
+				res <- callResult{err: &PanicError{
+					Value: x,
+					Stack: debug.Stack(),
+				}}
+				panic(x)
+			}
+		}()
+		v, err := fn(loop.vm)
+		returned = true
+		var exported interface{}
+		if err == nil && v != nil {
+			exported = v.Export()
+		}
+		res <- callResult{value: exported, err: err}
+	}))
+	if !ok {
+		return nil, ErrTerminated
+	}
+	select {
+	case r := <-res:
+		return r.value, r.err
+	case <-ctx.Done():
+		atomic.CompareAndSwapInt32(&state, callPending, callCancelled)
+		return nil, ctx.Err()
+	}
+}
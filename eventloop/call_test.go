@@ -0,0 +1,138 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestCall(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	loop.Start()
+	defer loop.Terminate()
+
+	v, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.RunString(`({a: 1, b: [2, "x"]})`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || m["a"] != int64(1) {
+		t.Fatalf("%#v", v)
+	}
+	if arr, ok := m["b"].([]interface{}); !ok || len(arr) != 2 || arr[1] != "x" {
+		t.Fatalf("%#v", m["b"])
+	}
+
+	_, err = loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.RunString(`throw new Error("boom")`)
+	})
+	var ex *goja.Exception
+	if !errors.As(err, &ex) || ex.Value().ToObject(nil).Get("message").String() != "boom" {
+		t.Fatal(err)
+	}
+
+	v, err = loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return nil, nil
+	})
+	if v != nil || err != nil {
+		t.Fatal(v, err)
+	}
+}
+
+func TestCallFromLoop(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var err error
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+			return goja.Undefined(), nil
+		})
+	})
+	if !errors.Is(err, ErrCalledFromLoop) {
+		t.Fatal(err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	// the loop is not running, so the function cannot start
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	called := false
+	_, err := loop.Call(ctx, func(vm *goja.Runtime) (goja.Value, error) {
+		called = true
+		return goja.Undefined(), nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	loop.Run(func(*goja.Runtime) {})
+	if called {
+		t.Fatal("the function has been called after the context was done")
+	}
+
+	_, err = loop.Call(ctx, func(vm *goja.Runtime) (goja.Value, error) {
+		return goja.Undefined(), nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestCallTerminate(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	res := make(chan error, 1)
+	go func() {
+		v, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+			return vm.ToValue(42), nil
+		})
+		if err == nil && v != int64(42) {
+			t.Errorf("unexpected value %v", v)
+		}
+		res <- err
+	}()
+	// wait for the function to be queued
+	for {
+		loop.auxJobsLock.Lock()
+		n := loop.auxJobsLen()
+		loop.auxJobsLock.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	loop.Terminate()
+	// the functions queued before the loop was terminated run during Terminate()
+	if err := <-res; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return goja.Undefined(), nil
+	})
+	if err != ErrTerminated {
+		t.Fatal(err)
+	}
+}
+
+func TestCallPanic(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(RecoverPanics(true))
+	loop.Start()
+	defer loop.Terminate()
+	_, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		panic("oops")
+	})
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value != "oops" {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,250 @@
+package eventloop
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for an EventLoop (see WithClock()).
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a ClockTimer that sends the current time on its channel after at least duration d.
+	NewTimer(d time.Duration) ClockTimer
+}
+
+// ClockTimer is a timer created by a Clock. Its methods are only called from the loop.
+type ClockTimer interface {
+	// C returns the channel on which the time is delivered when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing and discards a pending notification, if any.
+	// Returns false if the timer has already fired or has been stopped.
+	Stop() bool
+	// Reset stops the timer (as per Stop()) and re-arms it to fire after duration d.
+	Reset(d time.Duration)
+}
+
+type realClock struct{}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) ClockTimer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	if t.t.Stop() {
+		return true
+	}
+	// Depending on the Go version and settings the channel may or may not contain a value at this
+	// point. If it does, and it's not drained, the only consequence is a spurious wakeup.
+	select {
+	case <-t.t.C:
+	default:
+	}
+	return false
+}
+
+func (t realTimer) Reset(d time.Duration) {
+	t.Stop()
+	t.t.Reset(d)
+}
+
+// FakeClock is a Clock which only moves forward when told to, which allows testing scripts that use timers
+// deterministically and without actually waiting.
+//
+// Time is moved by calling Advance() or RunUntilIdle() from outside the loop, while the loop is running (e.g. by
+// Start() or by Run() in a different goroutine). Both methods fire the due timers one by one in the order of their
+// deadlines, setting the current time to the deadline of each, and wait until the loop has processed each
+// timer before proceeding. This means that a timer scheduled from a callback is also fired if it is due.
+//
+// A FakeClock may be shared between multiple loops.
+type FakeClock struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  time.Time
+
+	timers  map[*fakeTimer]struct{}
+	unacked int
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	c     chan time.Time
+	when  time.Time
+
+	active, fired bool
+}
+
+// NewFakeClock creates a new FakeClock set to the specified time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) ClockTimer {
+	t := &fakeTimer{
+		clock: c,
+		c:     make(chan time.Time, 1),
+	}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d, firing all the timers that become due (see FakeClock).
+// It must not be called from the loop.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	target := c.now.Add(d)
+	for {
+		t := c.next()
+		if t == nil || t.when.After(target) {
+			break
+		}
+		c.fire(t)
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+}
+
+// RunUntilIdle keeps moving the time forward to the next deadline and firing the timers until there are no more
+// active timers. Note, it never returns if there is an active interval. It must not be called from the loop.
+func (c *FakeClock) RunUntilIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		t := c.next()
+		if t == nil {
+			break
+		}
+		c.fire(t)
+	}
+}
+
+// BlockUntil waits until there are at least n active timers. Each running loop that has pending timers holds
+// one active timer. This can be used to make sure a loop has processed the scheduling of a timer before calling
+// Advance().
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.activeCount() < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *FakeClock) activeCount() int {
+	n := 0
+	for t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+// next waits until all the fired timers have been processed and returns the active timer with the earliest
+// deadline, or nil if there are none. Must be called with the lock held.
+func (c *FakeClock) next() *fakeTimer {
+	for c.unacked > 0 {
+		c.cond.Wait()
+	}
+	var next *fakeTimer
+	for t := range c.timers {
+		if t.active && (next == nil || t.when.Before(next.when)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// fire moves the time to the timer's deadline and fires it, then waits until it's processed.
+// Must be called with the lock held.
+func (c *FakeClock) fire(t *fakeTimer) {
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	t.doFire()
+	for t.fired {
+		c.cond.Wait()
+	}
+}
+
+// Must be called with the lock held.
+func (t *fakeTimer) doFire() {
+	t.active = false
+	t.fired = true
+	t.clock.unacked++
+	select {
+	case t.c <- t.clock.now:
+	default:
+	}
+}
+
+// ack is called when the loop stops or resets a fired timer, which means it has processed the expiry.
+// Must be called with the lock held.
+func (t *fakeTimer) ack() {
+	if t.fired {
+		t.fired = false
+		t.clock.unacked--
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.ack()
+	delete(c.timers, t)
+	select {
+	case <-t.c:
+	default:
+	}
+	c.cond.Broadcast()
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.ack()
+	select {
+	case <-t.c:
+	default:
+	}
+	t.when = c.now.Add(d)
+	t.active = true
+	c.timers[t] = struct{}{}
+	if d <= 0 {
+		t.doFire()
+	}
+	c.cond.Broadcast()
+}
@@ -0,0 +1,151 @@
+package eventloop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var log = [];
+	setTimeout(function() {
+		log.push("a:" + Date.now());
+		setTimeout(function() {
+			log.push("b:" + Date.now());
+		}, 5000);
+	}, 30000);
+	setTimeout(function() {
+		log.push("c:" + Date.now());
+	}, 60000);
+	`
+
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewFakeClock(time.UnixMilli(0))
+	loop := NewEventLoop(WithClock(clock))
+	loop.Start()
+	defer loop.Stop()
+
+	getLog := func() string {
+		ch := make(chan string)
+		loop.RunOnLoop(func(vm *goja.Runtime) {
+			res, err := vm.RunString("log.join()")
+			if err != nil {
+				panic(err)
+			}
+			ch <- res.String()
+		})
+		return <-ch
+	}
+
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		_, err = vm.RunProgram(prg)
+	})
+	clock.BlockUntil(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(29 * time.Second)
+	if log := getLog(); log != "" {
+		t.Fatal(log)
+	}
+
+	clock.Advance(11 * time.Second)
+	if log := getLog(); log != "a:30000,b:35000" {
+		t.Fatal(log)
+	}
+	if now := clock.Now(); !now.Equal(time.UnixMilli(40000)) {
+		t.Fatal(now)
+	}
+
+	clock.RunUntilIdle()
+	if log := getLog(); log != "a:30000,b:35000,c:60000" {
+		t.Fatal(log)
+	}
+}
+
+func TestFakeClockRun(t *testing.T) {
+	t.Parallel()
+	clock := NewFakeClock(time.UnixMilli(0))
+	loop := NewEventLoop(WithClock(clock))
+	done := make(chan struct{})
+	var ticks int64
+	go func() {
+		defer close(done)
+		loop.Run(func(vm *goja.Runtime) {
+			_, err := vm.RunString(`
+			var ticks = 0;
+			var i = setInterval(function() {
+				if (++ticks === 10) {
+					clearInterval(i);
+				}
+			}, 1000);
+			`)
+			if err != nil {
+				panic(err)
+			}
+		})
+		loop.Run(func(vm *goja.Runtime) {
+			ticks = vm.Get("ticks").ToInteger()
+		})
+	}()
+	clock.BlockUntil(1)
+	clock.RunUntilIdle()
+	<-done
+	if ticks != 10 {
+		t.Fatal(ticks)
+	}
+	if now := clock.Now(); !now.Equal(time.UnixMilli(10000)) {
+		t.Fatal(now)
+	}
+}
+
+func TestFakeClockRecursiveTimeout(t *testing.T) {
+	t.Parallel()
+	clock := NewFakeClock(time.UnixMilli(0))
+	loop := NewEventLoop(WithClock(clock))
+	loop.Start()
+	defer loop.Terminate()
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		var calls = 0;
+		function f() {
+			calls++;
+			setTimeout(f, 0);
+		}
+		setTimeout(f, 0);
+		`)
+		if err != nil {
+			panic(err)
+		}
+	})
+	clock.BlockUntil(1)
+	advanced := make(chan struct{})
+	go func() {
+		clock.Advance(10 * time.Millisecond)
+		close(advanced)
+	}()
+	select {
+	case <-advanced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Advance() has not returned")
+	}
+	v, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.Get("calls"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the delay is clamped to 1ms, so the timeout runs once per millisecond
+	if calls := v.(int64); calls != 10 {
+		t.Fatal(calls)
+	}
+}
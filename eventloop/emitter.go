@@ -0,0 +1,31 @@
+package eventloop
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/process"
+)
+
+var symEmitter = goja.NewSymbol("emitter")
+
+// initEmitter attaches the emitter to the object, so that the methods defined by defineEmitterMethods() can find it.
+func (loop *EventLoop) initEmitter(o *goja.Object, e *process.Emitter) {
+	o.DefineDataPropertySymbol(symEmitter, loop.vm.ToValue(e), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+func (loop *EventLoop) toEmitter(v goja.Value) *process.Emitter {
+	if o, ok := v.(*goja.Object); ok {
+		if s := o.GetSymbol(symEmitter); s != nil {
+			if e, ok := s.Export().(*process.Emitter); ok {
+				return e
+			}
+		}
+	}
+	panic(errors.NewTypeError(loop.vm, errors.ErrCodeInvalidThis, `Value of "this" must be of type EventEmitter`))
+}
+
+// defineEmitterMethods adds the EventEmitter methods implemented by process.Emitter to the prototype of the
+// objects created by the loop, such as Worker and MessagePort.
+func (loop *EventLoop) defineEmitterMethods(proto *goja.Object) {
+	process.DefineEmitterMethods(loop.vm, proto, loop.toEmitter)
+}
@@ -1,32 +1,53 @@
 package eventloop
 
 import (
+	"container/heap"
+	"context"
+	"fmt"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/dop251/goja"
 	"github.com/dop251/goja_nodejs/console"
+	"github.com/dop251/goja_nodejs/process"
 	"github.com/dop251/goja_nodejs/require"
+	"github.com/dop251/goja_nodejs/structuredclone"
 )
 
 type job struct {
-	cancel func() bool
-	fn     func()
-	idx    int
+	fn func()
+	id int64
 
 	cancelled bool
+	unref     bool
+	// set once the promise created by newPendingPromise() is settled or the loop is terminated, guarded by
+	// auxJobsLock
+	settled bool
+	// not reported by PendingJobs()
+	internal bool
+	// the JS call stack at the time the job was scheduled from JS (see PendingJob)
+	stack []goja.StackFrame
 }
 
-type Timer struct {
+// timer is a job scheduled to run at a certain time. Active timers are kept in the loop's timer heap.
+type timer struct {
 	job
-	timer *time.Timer
+	when      time.Time
+	seq       uint64
+	delay     time.Duration
+	repeating bool
+	fired     bool
+	idx       int
+}
+
+type Timer struct {
+	timer
 }
 
 type Interval struct {
-	job
-	ticker   *time.Ticker
-	stopChan chan struct{}
+	timer
 }
 
 type Immediate struct {
@@ -35,35 +56,133 @@ type Immediate struct {
 
 type EventLoop struct {
 	vm       *goja.Runtime
-	jobChan  chan func()
-	jobs     []*job
 	jobCount int32
 	canRun   int32
 
+	timers     timerHeap
+	timerSeq   uint64
+	clock      Clock
+	wakeTimer  ClockTimer
+	timerArmed bool
+	timerFired bool
+	timerWhen  time.Time
+
+	immediates []*Immediate
+
+	// callbacks queued by process.nextTick()
+	ticks []func()
+
+	jobRunner, enqueueMicrotask goja.Callable
+	runCurrentJobFn             goja.Value
+	currentJob                  func()
+
+	process            *process.Process
+	rejections         []*goja.Promise
+	reportedRejections map[*goja.Promise]struct{}
+	rejectionMode      UnhandledRejectionMode
+	rejectionHandler   UnhandledRejectionHandler
+
+	errorHandler  func(error)
+	recoverPanics bool
+
+	jobTimeout       time.Duration
+	jobTimeoutPolicy JobTimeoutPolicy
+	watchdog         *watchdog
+	stats            *loopStats
+	observer         JobObserver
+	clearOnStop      bool
+	// see CaptureJobStacks()
+	captureStacks bool
+	// the error that stopped the loop, returned by run()
+	err, lastErr error
+
+	// jobs which keep the loop alive but are neither timers nor immediates (e.g. promises returned by NewPromise())
+	pending map[*job]struct{}
+
 	auxJobsLock sync.Mutex
 	wakeupChan  chan struct{}
+	// set by Shutdown(), guarded by auxJobsLock
+	draining bool
+	// the context passed to the AsyncFunc bodies, cancelled by Terminate(), guarded by auxJobsLock
+	asyncCtx    context.Context
+	asyncCancel context.CancelFunc
+	// limits the number of concurrently running AsyncFunc bodies (see WithAsyncConcurrency())
+	asyncSem chan struct{}
+
+	// the current async execution context (see AsyncLocalStorage in asynchooks.go)
+	frame *asyncFrame
+	// set once the async_hooks module has been loaded
+	asyncHooks int32
+	// the AsyncResource whose scope is being run and the last assigned AsyncResource id
+	currentAsyncId, lastAsyncId int64
+	// set while the loop goroutine is running jobs, i.e. not waiting for them (see onLoop())
+	executing int32
+
+	// the workers started by the loop that have not exited yet
+	workers map[*worker]struct{}
+	// set if the loop runs a worker (see worker_threads in worker.go)
+	worker *worker
+
+	messagePortProto *goja.Object
+	// MessageChannel, MessagePort and BroadcastChannel, also exported by the worker_threads module
+	messagingCtors map[string]goja.Value
+	// the open BroadcastChannels created in the loop
+	broadcastChannels map[*broadcastChannel]struct{}
+	// the performance global, also exported by the perf_hooks module
+	perf *performance
+
+	// the loop is started in the background and has not been asked to shut down yet
+	keepAlive    bool
+	shuttingDown bool
+	exitEmitted  bool
 
-	auxJobsSpare, auxJobs []func()
+	// the functions submitted from Go for each priority, guarded by auxJobsLock
+	auxJobs      [numPriorities]auxQueue
+	auxJobsSpare []func()
+	// the capacity of each of the queues (see WithQueueCapacity()) and the condition signalled when there is space
+	auxCapacity int
+	auxSpace    *sync.Cond
 
 	stopLock   sync.Mutex
 	stopCond   *sync.Cond
 	running    bool
 	terminated bool
+	// set while Terminate() is running
+	terminating bool
+	// the stack trace of the call that has started the loop, only captured in the debug mode (see MisuseError)
+	startStack []byte
+	// the PendingJobs() calls waiting for the loop, guarded by stopLock
+	snapshotRequests []chan []PendingJob
 
-	enableConsole bool
-	registry      *require.Registry
+	timeoutProto *goja.Object
+	// Symbol.asyncIterator, polyfilled if necessary (see ChanToAsyncIterable())
+	asyncIterator *goja.Symbol
+	// the functions installed as globals, also exported by the timers module
+	timerFuncs  map[string]goja.Value
+	timersById  map[int64]*timer
+	lastTimerId int64
+
+	enableConsole    bool
+	enableWebGlobals bool
+	registry         *require.Registry
+	// the time the loop has been created (or reset by a Pool), the time origin of the performance API
+	created time.Time
 }
 
 func NewEventLoop(opts ...Option) *EventLoop {
 	vm := goja.New()
 
 	loop := &EventLoop{
-		vm:            vm,
-		jobChan:       make(chan func()),
-		wakeupChan:    make(chan struct{}, 1),
-		enableConsole: true,
+		vm:               vm,
+		wakeupChan:       make(chan struct{}, 1),
+		enableConsole:    true,
+		enableWebGlobals: true,
+		stats:            &loopStats{},
+		rejectionMode:    UnhandledRejectionsNone,
 	}
 	loop.stopCond = sync.NewCond(&loop.stopLock)
+	loop.auxSpace = sync.NewCond(&loop.auxJobsLock)
+	loop.timeoutProto = loop.createTimeoutProto()
 
 	for _, opt := range opts {
 		opt(loop)
@@ -71,16 +190,38 @@ func NewEventLoop(opts ...Option) *EventLoop {
 	if loop.registry == nil {
 		loop.registry = new(require.Registry)
 	}
+	if loop.clock == nil {
+		loop.clock = realClock{}
+	} else {
+		vm.SetTimeSource(loop.clock.Now)
+	}
+	loop.created = loop.clock.Now()
 	loop.registry.Enable(vm)
 	if loop.enableConsole {
 		console.Enable(vm)
 	}
-	vm.Set("setTimeout", loop.setTimeout)
-	vm.Set("setInterval", loop.setInterval)
-	vm.Set("setImmediate", loop.setImmediate)
-	vm.Set("clearTimeout", loop.clearTimeout)
-	vm.Set("clearInterval", loop.clearInterval)
-	vm.Set("clearImmediate", loop.clearImmediate)
+	loop.initHelpers()
+	loop.asyncIterator = loop.defineAsyncIteratorSymbol()
+	loop.enableProcess()
+	vm.SetPromiseRejectionTracker(loop.trackRejection)
+	loop.bindToRuntime()
+	if loop.enableWebGlobals {
+		loop.enableMessaging()
+		structuredclone.Enable(vm)
+		loop.enablePerformance()
+	}
+	loop.timerFuncs = map[string]goja.Value{
+		"setTimeout":     vm.ToValue(loop.setTimeout),
+		"setInterval":    vm.ToValue(loop.setInterval),
+		"setImmediate":   vm.ToValue(loop.setImmediate),
+		"clearTimeout":   vm.ToValue(loop.jsClearTimer),
+		"clearInterval":  vm.ToValue(loop.jsClearTimer),
+		"clearImmediate": vm.ToValue(loop.clearImmediate),
+	}
+	for name, fn := range loop.timerFuncs {
+		vm.Set(name, fn)
+	}
+	vm.Set("queueMicrotask", loop.queueMicrotask)
 
 	return loop
 }
@@ -97,12 +238,48 @@ func EnableConsole(enableConsole bool) Option {
 	}
 }
 
+// EnableWebGlobals controls whether the globals implementing the web APIs supported by the loop are added to
+// the runtime: structuredClone(), performance and the Performance* classes, MessageChannel, MessagePort and
+// BroadcastChannel. They are added by default, but apart from structuredClone() they are only created when they
+// are accessed for the first time. The perf_hooks and worker_threads modules are available regardless.
+func EnableWebGlobals(enable bool) Option {
+	return func(loop *EventLoop) {
+		loop.enableWebGlobals = enable
+	}
+}
+
+// defineLazyGlobal defines a global which is created by get() when it's accessed for the first time, after which
+// it becomes a regular property. Assigning to it replaces it without calling get().
+func (loop *EventLoop) defineLazyGlobal(name string, get func() goja.Value) {
+	r := loop.vm
+	global := r.GlobalObject()
+	getter := r.ToValue(func(goja.FunctionCall) goja.Value {
+		v := get()
+		global.DefineDataProperty(name, v, goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+		return v
+	})
+	setter := r.ToValue(func(call goja.FunctionCall) goja.Value {
+		global.DefineDataProperty(name, call.Argument(0), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+		return goja.Undefined()
+	})
+	global.DefineAccessorProperty(name, getter, setter, goja.FLAG_TRUE, goja.FLAG_TRUE)
+}
+
 func WithRegistry(registry *require.Registry) Option {
 	return func(loop *EventLoop) {
 		loop.registry = registry
 	}
 }
 
+// WithClock sets the Clock used by the loop to schedule timers. It is also used as the time source for the
+// runtime (i.e. for Date.now() and new Date()). By default, the system clock is used.
+// See FakeClock for a deterministic implementation suitable for tests.
+func WithClock(clock Clock) Option {
+	return func(loop *EventLoop) {
+		loop.clock = clock
+	}
+}
+
 func (loop *EventLoop) schedule(call goja.FunctionCall, repeating bool) goja.Value {
 	if fn, ok := goja.AssertFunction(call.Argument(0)); ok {
 		delay := call.Argument(1).ToInteger()
@@ -110,23 +287,23 @@ func (loop *EventLoop) schedule(call goja.FunctionCall, repeating bool) goja.Val
 		if len(call.Arguments) > 2 {
 			args = append(args, call.Arguments[2:]...)
 		}
-		f := func() { fn(nil, args...) }
-		loop.jobCount++
-		var job *job
-		var ret goja.Value
+		f := loop.bindFrame(loop.jsJob(fn, args))
+		var t *timer
+		var ret *goja.Object
 		if repeating {
-			interval := loop.newInterval(f)
-			interval.start(loop, time.Duration(delay)*time.Millisecond)
-			job = &interval.job
-			ret = loop.vm.ToValue(interval)
+			interval := loop.newInterval(f, time.Duration(delay)*time.Millisecond)
+			t = &interval.timer
+			ret = loop.vm.ToValue(interval).(*goja.Object)
 		} else {
-			timeout := loop.newTimeout(f)
-			timeout.start(loop, time.Duration(delay)*time.Millisecond)
-			job = &timeout.job
-			ret = loop.vm.ToValue(timeout)
+			timeout := loop.newTimeout(f, time.Duration(delay)*time.Millisecond)
+			t = &timeout.timer
+			ret = loop.vm.ToValue(timeout).(*goja.Object)
 		}
-		job.idx = len(loop.jobs)
-		loop.jobs = append(loop.jobs, job)
+		ret.SetPrototype(loop.timeoutProto)
+		t.stack = loop.captureStack()
+		loop.addTimerId(t)
+		loop.jobCount++
+		loop.addTimer(t)
 		return ret
 	}
 	return nil
@@ -146,9 +323,11 @@ func (loop *EventLoop) setImmediate(call goja.FunctionCall) goja.Value {
 		if len(call.Arguments) > 1 {
 			args = append(args, call.Arguments[1:]...)
 		}
-		f := func() { fn(nil, args...) }
+		f := loop.jsJob(fn, args)
 		loop.jobCount++
-		return loop.vm.ToValue(loop.addImmediate(f))
+		i := loop.addImmediate(f)
+		i.stack = loop.captureStack()
+		return loop.vm.ToValue(i)
 	}
 	return nil
 }
@@ -159,14 +338,12 @@ func (loop *EventLoop) setImmediate(call goja.FunctionCall) goja.Value {
 // The instance of goja.Runtime that is passed to the function and any Values derived
 // from it must not be used outside the function. SetTimeout is
 // safe to call inside or outside the loop.
-// If the loop is terminated (see Terminate()) returns nil.
+// If the loop is terminated (see Terminate()) or is shutting down (see Shutdown()) returns nil.
 func (loop *EventLoop) SetTimeout(fn func(*goja.Runtime), timeout time.Duration) *Timer {
-	t := loop.newTimeout(func() { fn(loop.vm) })
-	if loop.addAuxJob(func() {
-		t.start(loop, timeout)
+	t := loop.newTimeout(loop.withFrame(loop.captureFrame(), func() { fn(loop.vm) }), timeout)
+	if loop.submit(func() {
 		loop.jobCount++
-		t.idx = len(loop.jobs)
-		loop.jobs = append(loop.jobs, &t.job)
+		loop.addTimer(&t.timer)
 	}) {
 		return t
 	}
@@ -188,14 +365,12 @@ func (loop *EventLoop) ClearTimeout(t *Timer) {
 // function and any Values derived from it must not be used outside
 // the function. SetInterval is safe to call inside or outside the
 // loop.
-// If the loop is terminated (see Terminate()) returns nil.
+// If the loop is terminated (see Terminate()) or is shutting down (see Shutdown()) returns nil.
 func (loop *EventLoop) SetInterval(fn func(*goja.Runtime), timeout time.Duration) *Interval {
-	i := loop.newInterval(func() { fn(loop.vm) })
-	if loop.addAuxJob(func() {
-		i.start(loop, timeout)
+	i := loop.newInterval(loop.withFrame(loop.captureFrame(), func() { fn(loop.vm) }), timeout)
+	if loop.submit(func() {
 		loop.jobCount++
-		i.idx = len(loop.jobs)
-		loop.jobs = append(loop.jobs, &i.job)
+		loop.addTimer(&i.timer)
 	}) {
 		return i
 	}
@@ -211,16 +386,35 @@ func (loop *EventLoop) ClearInterval(i *Interval) {
 }
 
 func (loop *EventLoop) setRunning() {
+	if err := loop.trySetRunning(); err != nil {
+		panic(err)
+	}
+}
+
+func (loop *EventLoop) trySetRunning() error {
+	if loop.onLoop() {
+		return loop.misuse(ErrCalledFromLoop)
+	}
 	loop.stopLock.Lock()
 	defer loop.stopLock.Unlock()
-	if loop.running {
-		panic("Loop is already started")
+	if loop.running || loop.terminating {
+		return loop.misuse(ErrAlreadyRunning)
+	}
+	if debugMisuse {
+		loop.startStack = debug.Stack()
 	}
 	loop.running = true
+	loop.err = nil
+	loop.lastErr = nil
+	loop.shuttingDown = false
+	loop.exitEmitted = false
 	atomic.StoreInt32(&loop.canRun, 1)
 	loop.auxJobsLock.Lock()
 	loop.terminated = false
+	loop.draining = false
 	loop.auxJobsLock.Unlock()
+	loop.registerBroadcastChannels()
+	return nil
 }
 
 // Run calls the specified function, starts the event loop and waits until there are no more delayed jobs to run
@@ -228,27 +422,64 @@ func (loop *EventLoop) setRunning() {
 // The instance of goja.Runtime that is passed to the function and any Values derived from it must not be used
 // outside the function.
 // Do NOT use this function while the loop is already running. Use RunOnLoop() instead.
-// If the loop is already started it will panic.
+// If the loop is already started it will panic with ErrAlreadyRunning (or ErrCalledFromLoop if called from the loop,
+// see also RunChecked()).
+// If the loop was stopped because of a failure, such as a *PanicError (see RecoverPanics()) or
+// an *UnhandledRejectionError (see WithUnhandledRejectionMode()), the error is available via Err() after Run()
+// has returned (RunChecked() returns it instead).
 func (loop *EventLoop) Run(fn func(*goja.Runtime)) {
 	loop.setRunning()
+	_ = loop.runFunc(fn, nil)
+}
+
+// RunContext is like Run(), but the loop is bound to the specified context. If the context is cancelled before
+// the loop has run out of jobs, the running script is interrupted (see goja.Runtime.Interrupt()), the loop is
+// stopped, all active timeouts and intervals are cleared and a *ContextError wrapping ctx.Err() is returned.
+// An error is also returned if the loop was stopped because of a failure (see Run()).
+func (loop *EventLoop) RunContext(ctx context.Context, fn func(*goja.Runtime)) error {
+	loop.setRunning()
+	return loop.runFunc(fn, loop.watchContext(ctx))
+}
+
+func (loop *EventLoop) runFunc(fn func(*goja.Runtime), w *contextWatcher) error {
+	loop.setExecuting(true)
 	fn(loop.vm)
-	loop.run(false)
+	loop.afterJob()
+	loop.frame = nil
+	return loop.run(false, w)
 }
 
 // Start the event loop in the background. The loop continues to run until Stop() is called.
-// If the loop is already started it will panic.
+// If the loop is already started it will panic (see Run() and StartChecked()).
 func (loop *EventLoop) Start() {
 	loop.setRunning()
-	go loop.run(true)
+	go loop.run(true, nil)
+}
+
+// StartContext is like Start(), but the loop is also stopped when the specified context is cancelled. In this
+// case the running script is interrupted and all active timeouts and intervals are cleared (see RunContext()).
+func (loop *EventLoop) StartContext(ctx context.Context) {
+	loop.setRunning()
+	w := loop.watchContext(ctx)
+	go loop.run(true, w)
 }
 
 // StartInForeground starts the event loop in the current goroutine. The loop continues to run until Stop() is called.
-// If the loop is already started it will panic.
+// If the loop is already started it will panic (see Run()).
 // Use this instead of Start if you want to recover from panics that may occur while calling native Go functions from
 // within setInterval and setTimeout callbacks.
 func (loop *EventLoop) StartInForeground() {
 	loop.setRunning()
-	loop.run(true)
+	loop.run(true, nil)
+}
+
+// StartInForegroundContext is like StartInForeground(), but the loop is also stopped when the specified context
+// is cancelled, in which case a *ContextError is returned (see RunContext()). If the loop was stopped by Stop(),
+// the returned error is nil. If the loop was stopped because of a failure (see Run()), the failure is returned.
+func (loop *EventLoop) StartInForegroundContext(ctx context.Context) error {
+	loop.setRunning()
+	w := loop.watchContext(ctx)
+	return loop.run(true, w)
 }
 
 // Stop the loop that was started with Start(). After this function returns there will be no more jobs executed
@@ -257,17 +488,15 @@ func (loop *EventLoop) StartInForeground() {
 // It is not allowed to run Start() (or Run()) and Stop() or Terminate() concurrently.
 // Calling Stop() on a non-running loop has no effect.
 // It is not allowed to call Stop() from the loop, because it is synchronous and cannot complete until the loop
-// is not running any jobs. Use StopNoWait() instead.
+// is not running any jobs, it panics with ErrCalledFromLoop in this case (see StopChecked()). Use StopNoWait()
+// instead.
 // return number of jobs remaining
 func (loop *EventLoop) Stop() int {
-	loop.stopLock.Lock()
-	for loop.running {
-		atomic.StoreInt32(&loop.canRun, 0)
-		loop.wakeup()
-		loop.stopCond.Wait()
+	n, err := loop.StopChecked()
+	if err != nil {
+		panic(err)
 	}
-	loop.stopLock.Unlock()
-	return int(loop.jobCount)
+	return n
 }
 
 // StopNoWait tells the loop to stop and returns immediately. Can be used inside the loop. Calling it on a
@@ -281,82 +510,238 @@ func (loop *EventLoop) StopNoWait() {
 	loop.stopLock.Unlock()
 }
 
-// Terminate stops the loop and clears all active timeouts and intervals. After it returns there are no
-// active timers or goroutines associated with the loop. Any attempt to submit a task (by using RunOnLoop(),
-// SetTimeout() or SetInterval()) will not succeed.
+// Terminate stops the loop and clears all active timeouts, intervals and immediates. After it returns there are no
+// active timers or goroutines associated with the loop (the contexts of the running AsyncFunc bodies are
+// cancelled, but the bodies may still be running; the workers started by the loop are terminated, but may still
+// be stopping). Any attempt to submit a task (by using RunOnLoop(), SetTimeout() or SetInterval()) will not succeed. Promises created by NewPromise() that have not been settled
+// no longer keep the loop alive.
 // After being terminated the loop can be restarted again by using Start() or Run().
-// This method must not be called concurrently with Stop*(), Start(), or Run().
+// This method must not be called concurrently with Stop*(), Start(), or Run(). Starting the loop while it's being
+// terminated fails with ErrAlreadyRunning. Calling it from the loop (including the functions it runs itself)
+// panics with ErrCalledFromLoop (see TerminateChecked()).
 func (loop *EventLoop) Terminate() {
-	loop.Stop()
+	if err := loop.TerminateChecked(); err != nil {
+		panic(err)
+	}
+}
 
+// terminate runs on the goroutine that has called Terminate() once the loop has stopped.
+func (loop *EventLoop) terminate() {
 	loop.auxJobsLock.Lock()
 	loop.terminated = true
+	for job := range loop.pending {
+		// the promises that have not been settled by now are never settled
+		job.settled = true
+	}
+	if loop.asyncCancel != nil {
+		loop.asyncCancel()
+		loop.asyncCtx, loop.asyncCancel = nil, nil
+	}
+	loop.auxSpace.Broadcast()
 	loop.auxJobsLock.Unlock()
 
-	loop.runAux()
+	loop.runAux(false)
+	loop.clearJobs()
+	loop.stopWorkers()
+	loop.closeBroadcastChannels()
+	for job := range loop.pending {
+		loop.finishJob(job)
+	}
+	loop.pending = nil
+}
 
-	for i := 0; i < len(loop.jobs); i++ {
-		job := loop.jobs[i]
-		if !job.cancelled {
-			job.cancelled = true
-			if job.cancel() {
-				loop.removeJob(job)
-				i--
-			}
+// clearJobs cancels all active timeouts, intervals and immediates.
+func (loop *EventLoop) clearJobs() {
+	for _, t := range loop.timers {
+		t.idx = -1
+		if !t.cancelled {
+			loop.finishJob(&t.job)
 		}
 	}
+	for i := range loop.timers {
+		loop.timers[i] = nil
+	}
+	loop.timers = loop.timers[:0]
+	atomic.StoreInt32(&loop.stats.timers, 0)
+	atomic.StoreInt32(&loop.stats.intervals, 0)
+	loop.stopTimer()
 
-	for len(loop.jobs) > 0 {
-		(<-loop.jobChan)()
+	for i, imm := range loop.immediates {
+		if !imm.cancelled {
+			loop.finishJob(&imm.job)
+		}
+		loop.immediates[i] = nil
 	}
+	loop.immediates = loop.immediates[:0]
+	atomic.StoreInt32(&loop.stats.immediates, 0)
+	loop.ticks = nil
 }
 
 // RunOnLoop schedules to run the specified function in the context of the loop as soon as possible.
 // The order of the runs is preserved (i.e. the functions will be called in the same order as calls to RunOnLoop())
 // The instance of goja.Runtime that is passed to the function and any Values derived from it must not be used
 // outside the function. It is safe to call inside or outside the loop.
-// Returns true on success or false if the loop is terminated (see Terminate()).
+// The function is queued with PriorityNormal. If the queue capacity is limited (see WithQueueCapacity()), it may
+// block until there is space in the queue (see RunOnLoopWithPriority()).
+// Returns true on success or false if the loop is terminated (see Terminate()) or is shutting down (see Shutdown()).
 func (loop *EventLoop) RunOnLoop(fn func(*goja.Runtime)) bool {
-	return loop.addAuxJob(func() { fn(loop.vm) })
+	return loop.RunOnLoopWithPriority(fn, PriorityNormal)
+}
+
+// NewPromise creates a new Promise in the loop's runtime and returns it together with the functions to resolve
+// or reject it. Unlike the ones returned by goja.Runtime.NewPromise(), these functions are safe to call from any
+// goroutine: the settlement is scheduled to run on the loop (the value is converted using goja.Runtime.ToValue()
+// at that point, so it must not be a goja.Value derived from a different runtime).
+// The loop is kept alive until the promise is settled, i.e. Run() will not return before that.
+// Only the first call to either of the functions has effect, they return false if the promise has already been
+// settled or the loop has been terminated since the promise was created (see Terminate()), in which case the
+// promise is never settled, even if the loop is restarted.
+// NewPromise must be called from the loop (i.e. from a function passed to Run() or RunOnLoop(), or from a callback).
+func (loop *EventLoop) NewPromise() (promise *goja.Promise, resolve, reject func(interface{}) bool) {
+	p, settle := loop.newPendingPromise()
+	resolve = func(v interface{}) bool {
+		return settle(func(res, _ func(interface{}) error) {
+			_ = res(v)
+		})
+	}
+	reject = func(v interface{}) bool {
+		return settle(func(_, rej func(interface{}) error) {
+			_ = rej(v)
+		})
+	}
+	return p, resolve, reject
 }
 
-func (loop *EventLoop) runAux() {
+// newPendingPromise creates a promise that keeps the loop alive until it is settled. The returned function can be
+// called from any goroutine, it schedules fn to run on the loop with the resolving functions of the promise.
+// Only the first call has effect, it returns false if the promise has already been settled or the loop has been
+// terminated since the promise was created (even if it has been restarted after that).
+func (loop *EventLoop) newPendingPromise() (*goja.Promise, func(fn func(resolve, reject func(interface{}) error)) bool) {
+	p, res, rej := loop.vm.NewPromise()
+	j := &job{}
+	loop.jobCount++
+	if loop.pending == nil {
+		loop.pending = make(map[*job]struct{})
+	}
+	loop.pending[j] = struct{}{}
+	return p, func(fn func(resolve, reject func(interface{}) error)) bool {
+		loop.auxJobsLock.Lock()
+		if j.settled || loop.terminated {
+			loop.auxJobsLock.Unlock()
+			return false
+		}
+		j.settled = true
+		// terminate() runs the queued functions before it clears the pending jobs, so j cannot be cancelled
+		// before this runs
+		loop.auxJobs[PriorityNormal].push(func() {
+			loop.finishJob(j)
+			delete(loop.pending, j)
+			fn(res, rej)
+		})
+		loop.auxJobsLock.Unlock()
+		loop.wakeup()
+		return true
+	}
+}
+
+// runAux runs the functions submitted to the loop, either all of them or, if batch is true, the next batch (see
+// RunOnLoopWithPriority()). In the latter case, if there are more functions left, the loop is woken up again once
+// it has run the due timers and immediates.
+func (loop *EventLoop) runAux(batch bool) {
 	loop.auxJobsLock.Lock()
-	jobs := loop.auxJobs
-	loop.auxJobs = loop.auxJobsSpare
+	jobs, more := loop.takeAuxJobs(loop.auxJobsSpare, batch)
+	loop.auxJobsSpare = nil
+	if loop.auxCapacity > 0 && len(jobs) > 0 {
+		loop.auxSpace.Broadcast()
+	}
 	loop.auxJobsLock.Unlock()
+	if more {
+		loop.wakeup()
+	}
 	for i, job := range jobs {
-		job()
+		loop.exec(JobInfo{Kind: JobKindTask}, job)
 		jobs[i] = nil
 	}
 	loop.auxJobsSpare = jobs[:0]
 }
 
-func (loop *EventLoop) run(inBackground bool) {
-	loop.runAux()
+func (loop *EventLoop) run(inBackground bool, w *contextWatcher) (err error) {
+	loop.setExecuting(true)
 	if inBackground {
 		loop.jobCount++
+		loop.keepAlive = true
 	}
-LOOP:
-	for loop.jobCount > 0 {
-		select {
-		case job := <-loop.jobChan:
-			job()
-		case <-loop.wakeupChan:
-			loop.runAux()
-			if atomic.LoadInt32(&loop.canRun) == 0 {
-				break LOOP
+	loop.runAux(false)
+	for {
+		for loop.jobCount > 0 && loop.canRunJobs() {
+			loop.runTimers()
+			loop.runImmediates()
+			if loop.jobCount <= 0 || !loop.canRunJobs() {
+				break
+			}
+			if len(loop.immediates) > 0 {
+				// do not block, but still pick up any pending aux jobs
+				select {
+				case <-loop.wakeupChan:
+					loop.runAux(true)
+				default:
+				}
+				continue
+			}
+			loop.armTimer()
+			loop.setExecuting(false)
+			select {
+			case <-loop.timerChan():
+				loop.setExecuting(true)
+				loop.timerArmed = false
+				loop.timerFired = true
+			case <-loop.wakeupChan:
+				loop.setExecuting(true)
+				loop.runAux(true)
+			}
+		}
+		if !loop.shuttingDown || loop.exitEmitted || !loop.canRunJobs() {
+			break
+		}
+		// the loop has been drained, the 'beforeExit' listeners may schedule more work
+		if loop.process.ListenerCount("beforeExit") > 0 {
+			loop.exec(JobInfo{Kind: JobKindTask}, loop.emitBeforeExit)
+			if loop.jobCount > 0 {
+				continue
 			}
 		}
+		loop.emitExit(0)
+		break
 	}
-	if inBackground {
+	loop.stopTimer()
+	if loop.canRunJobs() {
+		// the loop has run out of jobs rather than being stopped
+		loop.unregisterBroadcastChannels()
+	}
+	if loop.keepAlive {
+		loop.keepAlive = false
 		loop.jobCount--
 	}
+	if loop.clearOnStop {
+		loop.clearOnStop = false
+		loop.clearJobs()
+	}
+	if w != nil {
+		err = w.stop()
+	}
+	if loop.err != nil {
+		err = loop.err
+		loop.err = nil
+	}
 
+	loop.setExecuting(false)
 	loop.stopLock.Lock()
+	loop.takeSnapshotsLocked()
 	loop.running = false
+	loop.lastErr = err
 	loop.stopLock.Unlock()
 	loop.stopCond.Broadcast()
+	return
 }
 
 func (loop *EventLoop) wakeup() {
@@ -367,148 +752,299 @@ func (loop *EventLoop) wakeup() {
 }
 
 func (loop *EventLoop) addAuxJob(fn func()) bool {
+	return loop.queueAuxJob(fn, PriorityNormal, queueInternal)
+}
+
+// submit queues a job submitted from Go (e.g. by SetTimeout()). Unlike addAuxJob() it fails while the loop is
+// shutting down.
+func (loop *EventLoop) submit(fn func()) bool {
+	return loop.queueAuxJob(fn, PriorityNormal, queueExternal)
+}
+
+// queueAuxJob adds the function to the queue for the priority (see queueMode).
+func (loop *EventLoop) queueAuxJob(fn func(), prio Priority, mode queueMode) bool {
+	if prio < PriorityLow {
+		prio = PriorityLow
+	} else if prio > PriorityHigh {
+		prio = PriorityHigh
+	}
 	loop.auxJobsLock.Lock()
-	if loop.terminated {
-		loop.auxJobsLock.Unlock()
-		return false
+	for {
+		if loop.terminated || mode != queueInternal && loop.draining {
+			loop.auxJobsLock.Unlock()
+			return false
+		}
+		if mode < queueWait || loop.auxCapacity <= 0 || loop.auxJobs[prio].len() < loop.auxCapacity {
+			break
+		}
+		if mode == queueTry {
+			loop.auxJobsLock.Unlock()
+			return false
+		}
+		if loop.onLoop() {
+			break
+		}
+		loop.auxSpace.Wait()
 	}
-	loop.auxJobs = append(loop.auxJobs, fn)
+	loop.auxJobs[prio].push(fn)
 	loop.auxJobsLock.Unlock()
 	loop.wakeup()
 	return true
 }
 
-func (loop *EventLoop) newTimeout(f func()) *Timer {
-	t := &Timer{
-		job: job{fn: f},
-	}
-	t.cancel = t.doCancel
+func (loop *EventLoop) canRunJobs() bool {
+	return atomic.LoadInt32(&loop.canRun) != 0
+}
 
+func (loop *EventLoop) newTimeout(f func(), delay time.Duration) *Timer {
+	// https://nodejs.org/api/timers.html#settimeoutcallback-delay-args
+	// Besides, this guarantees that a timeout scheduled by a timer callback does not run in the same pass, even
+	// if the clock has not moved (see FakeClock).
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	t := &Timer{}
+	t.init(f, delay, false)
 	return t
 }
 
-func (t *Timer) start(loop *EventLoop, timeout time.Duration) {
-	t.timer = time.AfterFunc(timeout, func() {
-		loop.jobChan <- func() {
-			loop.doTimeout(t)
-		}
-	})
+func (loop *EventLoop) newInterval(f func(), delay time.Duration) *Interval {
+	// https://nodejs.org/api/timers.html#timers_setinterval_callback_delay_args
+	if delay <= 0 {
+		delay = time.Millisecond
+	}
+	i := &Interval{}
+	i.init(f, delay, true)
+	return i
+}
+
+func (t *timer) init(f func(), delay time.Duration, repeating bool) {
+	t.fn = f
+	t.delay = delay
+	t.repeating = repeating
+	t.idx = -1
+}
+
+// addTimer puts the timer into the heap so that it runs after its delay. Timers with the same deadline run
+// in the order they were added.
+func (loop *EventLoop) addTimer(t *timer) {
+	loop.addTimerAt(t, loop.clock.Now().Add(t.delay))
 }
 
-func (loop *EventLoop) newInterval(f func()) *Interval {
-	i := &Interval{
-		job:      job{fn: f},
-		stopChan: make(chan struct{}),
+func (loop *EventLoop) addTimerAt(t *timer, when time.Time) {
+	t.when = when
+	loop.timerSeq++
+	t.seq = loop.timerSeq
+	heap.Push(&loop.timers, t)
+	loop.countTimer(t, 1)
+}
+
+func (loop *EventLoop) removeTimer(t *timer) {
+	if t.idx >= 0 {
+		heap.Remove(&loop.timers, t.idx)
+		loop.countTimer(t, -1)
 	}
-	i.cancel = i.doCancel
+}
 
-	return i
+// runTimers runs all the timers that are due at the time of the call. Intervals are re-scheduled relative to
+// that time, so that they run at most once per call.
+func (loop *EventLoop) runTimers() {
+	if len(loop.timers) == 0 {
+		return
+	}
+	now := loop.clock.Now()
+	for len(loop.timers) > 0 && loop.canRunJobs() {
+		t := loop.timers[0]
+		if t.when.After(now) {
+			break
+		}
+		heap.Pop(&loop.timers)
+		loop.countTimer(t, -1)
+		info := JobInfo{
+			Kind:    JobKindTimeout,
+			Latency: now.Sub(t.when),
+		}
+		if t.repeating {
+			info.Kind = JobKindInterval
+			loop.addTimerAt(t, now.Add(t.delay))
+		} else {
+			t.fired = true
+			loop.finishJob(&t.job)
+		}
+		loop.exec(info, t.fn)
+	}
 }
 
-func (i *Interval) start(loop *EventLoop, timeout time.Duration) {
-	// https://nodejs.org/api/timers.html#timers_setinterval_callback_delay_args
-	if timeout <= 0 {
-		timeout = time.Millisecond
+func (loop *EventLoop) runImmediates() {
+	queue := loop.immediates
+	loop.immediates = nil
+	for i, imm := range queue {
+		if !loop.canRunJobs() {
+			loop.immediates = append(queue[i:], loop.immediates...)
+			return
+		}
+		queue[i] = nil
+		loop.doImmediate(imm)
 	}
-	i.ticker = time.NewTicker(timeout)
-	go i.run(loop)
 }
 
-func (loop *EventLoop) addImmediate(f func()) *Immediate {
-	i := &Immediate{
-		job: job{fn: f},
+func (loop *EventLoop) timerChan() <-chan time.Time {
+	if loop.timerArmed {
+		return loop.wakeTimer.C()
 	}
-	loop.addAuxJob(func() {
-		loop.doImmediate(i)
-	})
-	return i
+	return nil
 }
 
-func (loop *EventLoop) doTimeout(t *Timer) {
-	loop.removeJob(&t.job)
-	if !t.cancelled {
-		t.cancelled = true
-		loop.jobCount--
-		t.fn()
+// armTimer makes sure the loop is woken up when the earliest timer is due.
+// Once the timer has fired, either armTimer() or stopTimer() must be called before the loop blocks or exits
+// (FakeClock relies on that).
+func (loop *EventLoop) armTimer() {
+	if len(loop.timers) == 0 {
+		loop.stopTimer()
+		return
+	}
+	when := loop.timers[0].when
+	if loop.timerArmed && when.Equal(loop.timerWhen) {
+		return
 	}
+	d := when.Sub(loop.clock.Now())
+	if loop.wakeTimer == nil {
+		loop.wakeTimer = loop.clock.NewTimer(d)
+	} else {
+		loop.wakeTimer.Reset(d)
+	}
+	loop.timerArmed = true
+	loop.timerFired = false
+	loop.timerWhen = when
 }
 
-func (loop *EventLoop) doInterval(i *Interval) {
-	if !i.cancelled {
-		i.fn()
+func (loop *EventLoop) stopTimer() {
+	if loop.timerArmed || loop.timerFired {
+		loop.wakeTimer.Stop()
+		loop.timerArmed = false
+		loop.timerFired = false
 	}
 }
 
+func (loop *EventLoop) addImmediate(f func()) *Immediate {
+	i := &Immediate{
+		job: job{fn: loop.bindFrame(f)},
+	}
+	loop.immediates = append(loop.immediates, i)
+	atomic.AddInt32(&loop.stats.immediates, 1)
+	return i
+}
+
 func (loop *EventLoop) doImmediate(i *Immediate) {
 	if !i.cancelled {
-		i.cancelled = true
-		loop.jobCount--
-		i.fn()
+		loop.finishJob(&i.job)
+		atomic.AddInt32(&loop.stats.immediates, -1)
+		loop.exec(JobInfo{Kind: JobKindImmediate}, i.fn)
 	}
 }
 
 func (loop *EventLoop) clearTimeout(t *Timer) {
-	if t != nil && !t.cancelled {
-		t.cancelled = true
-		loop.jobCount--
-		if t.doCancel() {
-			loop.removeJob(&t.job)
-		}
+	if t != nil {
+		loop.clearTimer(&t.timer)
 	}
 }
 
 func (loop *EventLoop) clearInterval(i *Interval) {
-	if i != nil && !i.cancelled {
-		i.cancelled = true
-		loop.jobCount--
-		i.doCancel()
+	if i != nil {
+		loop.clearTimer(&i.timer)
 	}
 }
 
-func (loop *EventLoop) removeJob(job *job) {
-	idx := job.idx
-	if idx < 0 {
-		return
+func (loop *EventLoop) clearTimer(t *timer) {
+	if !t.cancelled {
+		loop.finishJob(&t.job)
+		loop.removeTimer(t)
+	}
+}
+
+// finishJob marks the job as cancelled and releases its reference to the loop. It must be called once the job
+// is cleared or, for non-repeating jobs, when it is about to run.
+func (loop *EventLoop) finishJob(job *job) {
+	job.cancelled = true
+	if !job.unref {
+		loop.jobCount--
 	}
-	if idx < len(loop.jobs)-1 {
-		loop.jobs[idx] = loop.jobs[len(loop.jobs)-1]
-		loop.jobs[idx].idx = idx
+	if job.id != 0 {
+		delete(loop.timersById, job.id)
 	}
-	loop.jobs[len(loop.jobs)-1] = nil
-	loop.jobs = loop.jobs[:len(loop.jobs)-1]
-	job.idx = -1
 }
 
 func (loop *EventLoop) clearImmediate(i *Immediate) {
 	if i != nil && !i.cancelled {
-		i.cancelled = true
-		loop.jobCount--
+		loop.finishJob(&i.job)
+		atomic.AddInt32(&loop.stats.immediates, -1)
 	}
 }
 
-func (i *Interval) doCancel() bool {
-	close(i.stopChan)
-	return false
+// ContextError is returned by RunContext() and StartInForegroundContext() if the loop was stopped because
+// its context had been cancelled.
+type ContextError struct {
+	// Err is the error returned by ctx.Err().
+	Err error
+	// JobsLeft is the number of jobs the loop had when it was stopped.
+	JobsLeft int
 }
 
-func (t *Timer) doCancel() bool {
-	return t.timer.Stop()
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("event loop stopped: %v (%d jobs left)", e.Err, e.JobsLeft)
 }
 
-func (i *Interval) run(loop *EventLoop) {
-L:
-	for {
-		select {
-		case <-i.stopChan:
-			i.ticker.Stop()
-			break L
-		case <-i.ticker.C:
-			loop.jobChan <- func() {
-				loop.doInterval(i)
-			}
-		}
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+type contextWatcher struct {
+	loop   *EventLoop
+	ctx    context.Context
+	done   chan struct{}
+	exited chan struct{}
+	fired  bool
+}
+
+// watchContext starts a goroutine that interrupts the runtime and stops the loop when ctx is cancelled.
+// It must be called after setRunning().
+func (loop *EventLoop) watchContext(ctx context.Context) *contextWatcher {
+	w := &contextWatcher{
+		loop:   loop,
+		ctx:    ctx,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *contextWatcher) run() {
+	defer close(w.exited)
+	select {
+	case <-w.ctx.Done():
+		w.fired = true
+		w.loop.vm.Interrupt(w.ctx.Err())
+		w.loop.StopNoWait()
+	case <-w.done:
+	}
+}
+
+// stop must be called from the loop after it has finished running jobs. It waits until the watcher goroutine exits
+// (so that there can be no late calls to Interrupt()) and, if the context has been cancelled by that time (even if
+// the loop was stopped for a different reason), clears the interrupt flag and all the active timers.
+func (w *contextWatcher) stop() error {
+	close(w.done)
+	<-w.exited
+	if !w.fired && w.ctx.Err() == nil {
+		return nil
 	}
-	loop.jobChan <- func() {
-		loop.removeJob(&i.job)
+	loop := w.loop
+	loop.vm.ClearInterrupt()
+	err := &ContextError{
+		Err:      w.ctx.Err(),
+		JobsLeft: int(loop.jobCount),
 	}
+	loop.clearJobs()
+	return err
 }
@@ -1,7 +1,10 @@
 package eventloop
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -169,6 +172,215 @@ func TestInterval(t *testing.T) {
 	}
 }
 
+func TestTimeoutUnref(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var ticks = 0;
+	var heartbeat = setInterval(function() {
+		ticks++;
+	}, 10);
+	if (heartbeat.unref() !== heartbeat) {
+		throw new Error("unref() must return this");
+	}
+	if (heartbeat.hasRef()) {
+		throw new Error("hasRef() after unref()");
+	}
+	var t = setTimeout(function() {}, 5000);
+	t.unref();
+	t.ref();
+	if (!t.hasRef()) {
+		throw new Error("hasRef() after ref()");
+	}
+	t.unref();
+	setTimeout(function() {}, 100);
+	`
+
+	loop := NewEventLoop()
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunProgram(prg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Run() was kept alive by unref'ed timers (%v)", d)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		if ticks := vm.Get("ticks").ToInteger(); ticks == 0 {
+			err = errors.New("the unref'ed interval did not run")
+		}
+		_, _ = vm.RunString("clearInterval(heartbeat); clearTimeout(t);")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := loop.jobCount; c != 0 {
+		t.Fatalf("jobCount: %d", c)
+	}
+}
+
+func TestTimeoutRefresh(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var calls = 0;
+	var t = setTimeout(function() {
+		if (++calls === 1) {
+			t.refresh();
+		}
+	}, 100);
+	setTimeout(function() {
+		t.refresh();
+	}, 50);
+	`
+
+	loop := NewEventLoop()
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunProgram(prg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Fatalf("refresh() did not restart the timer (%v)", d)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		if calls := vm.Get("calls").ToInteger(); calls != 2 {
+			err = fmt.Errorf("calls: %d", calls)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClearTimeoutById(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var called = false;
+	var id = +setTimeout(function() {
+		called = true;
+	}, 100);
+	if (typeof id !== "number") {
+		throw new Error("unexpected id: " + id);
+	}
+	var i = setInterval(function() {
+		called = true;
+	}, 100);
+	clearTimeout(id);
+	clearTimeout(i);
+	clearInterval("" + 12345);
+	clearTimeout({});
+	`
+
+	loop := NewEventLoop()
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunProgram(prg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		if vm.Get("called").ToBoolean() {
+			err = errors.New("cleared timer has fired")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimerOrder(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var log = [];
+	for (var i = 0; i < 10; i++) {
+		setTimeout(log.push.bind(log, i), 50);
+	}
+	setTimeout(log.push.bind(log, "first"), 10);
+	setImmediate(log.push.bind(log, "immediate"));
+	`
+
+	loop := NewEventLoop()
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunProgram(prg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunString(`
+		if (log.join() !== "immediate,first,0,1,2,3,4,5,6,7,8,9") {
+			throw new Error("Invalid log: " + log);
+		}
+		`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntervalsNoGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	const SCRIPT = `
+	var count = 0;
+	var maxGoroutines = 0;
+	var intervals = [];
+	for (var i = 0; i < 1000; i++) {
+		intervals.push(setInterval(function() {
+			maxGoroutines = Math.max(maxGoroutines, numGoroutine());
+			if (++count === 3000) {
+				intervals.forEach(clearInterval);
+			}
+		}, 1));
+	}
+	`
+
+	loop := NewEventLoop()
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := runtime.NumGoroutine()
+	loop.Run(func(vm *goja.Runtime) {
+		vm.Set("numGoroutine", runtime.NumGoroutine)
+		_, err = vm.RunProgram(prg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		if n := int(vm.Get("maxGoroutines").ToInteger()); n > base+10 {
+			err = fmt.Errorf("too many goroutines: %d (was %d)", n, base)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := loop.jobCount; c != 0 {
+		t.Fatalf("jobCount: %d", c)
+	}
+}
+
 func TestImmediate(t *testing.T) {
 	t.Parallel()
 	const SCRIPT = `
@@ -549,6 +761,285 @@ func TestPromiseNative(t *testing.T) {
 	}
 }
 
+func TestNewPromise(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	let result;
+	p.then(value => {
+		result = value;
+	});
+	`
+
+	loop := NewEventLoop()
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		p, resolve, reject := loop.NewPromise()
+		vm.Set("p", p)
+		_, err = vm.RunProgram(prg)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if !resolve("passed") {
+				panic("resolve() has failed")
+			}
+			if reject("failed") {
+				panic("reject() has succeeded after resolve()")
+			}
+		}()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		result := vm.Get("result")
+		if !result.SameAs(vm.ToValue("passed")) {
+			err = fmt.Errorf("unexpected result: %v", result)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewPromiseReject(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var p *goja.Promise
+	loop.Run(func(vm *goja.Runtime) {
+		var reject func(interface{}) bool
+		p, _, reject = loop.NewPromise()
+		go reject(errors.New("failed"))
+	})
+	if p.State() != goja.PromiseStateRejected {
+		t.Fatal(p.State())
+	}
+}
+
+func TestNewPromiseAfterTerminate(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var resolve func(interface{}) bool
+	loop.Start()
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		var p *goja.Promise
+		p, resolve, _ = loop.NewPromise()
+		vm.Set("p", p)
+		vm.Set("stale", false)
+		if _, err := vm.RunString(`p.then(() => { stale = true; })`); err != nil {
+			panic(err)
+		}
+	})
+	loop.Terminate()
+
+	loop.Start()
+	defer loop.Terminate()
+	if resolve("late") {
+		t.Fatal("resolve() has succeeded after Terminate()")
+	}
+	v, err := loop.Call(context.Background(), func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.Get("stale"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != false {
+		t.Fatal("the continuation of a promise created before Terminate() has run")
+	}
+}
+
+func TestNextTick(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	const process = require("process");
+	var log = [];
+	setTimeout(function() {
+		Promise.resolve().then(function() {
+			log.push("promise");
+			process.nextTick(function() {
+				log.push("tick from promise");
+			});
+		});
+		queueMicrotask(function() {
+			log.push("microtask");
+		});
+		process.nextTick(function(a, b) {
+			log.push("tick " + a + b);
+			process.nextTick(function() {
+				log.push("nested tick");
+			});
+		}, 1, 2);
+		log.push("sync");
+		setImmediate(function() {
+			log.push("immediate");
+		});
+	});
+	`
+
+	loop := NewEventLoop()
+	var err error
+	var log string
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunString(SCRIPT)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		log = vm.Get("log").String()
+	})
+	if log != "sync,tick 12,nested tick,promise,microtask,tick from promise,immediate" {
+		t.Fatal(log)
+	}
+}
+
+func TestNextTickFromNative(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var err error
+	var res goja.Value
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunString(`
+		var log = [];
+		require("process").nextTick(function() {
+			log.push("tick");
+		});
+		`)
+		log := vm.Get("log").String()
+		if log != "" {
+			err = fmt.Errorf("tick has run too early: %q", log)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		res = vm.Get("log")
+	})
+	if res.String() != "tick" {
+		t.Fatal(res)
+	}
+}
+
+func TestQueueMicrotaskInvalidArg(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var err error
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunString(`
+		try {
+			queueMicrotask(1);
+			throw new Error("should have thrown");
+		} catch (e) {
+			if (e.code !== "ERR_INVALID_ARG_TYPE") {
+				throw e;
+			}
+		}
+		`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var called int32
+	err := loop.RunContext(ctx, func(vm *goja.Runtime) {
+		vm.Set("called", func() {
+			atomic.StoreInt32(&called, 1)
+		})
+		_, err := vm.RunString(`
+		setTimeout(called, 5000);
+		setInterval(function() {}, 10);
+		`)
+		if err != nil {
+			panic(err)
+		}
+	})
+	var ctxErr *ContextError
+	if !errors.As(err, &ctxErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if ctxErr.JobsLeft != 2 {
+		t.Fatal(ctxErr.JobsLeft)
+	}
+	if c := loop.jobCount; c != 0 {
+		t.Fatalf("jobCount: %d", c)
+	}
+
+	// The loop should be usable after that.
+	loop.Run(func(vm *goja.Runtime) {
+		vm.RunString(`setTimeout(called, 10);`)
+	})
+	if atomic.LoadInt32(&called) != 1 {
+		t.Fatal("not called")
+	}
+}
+
+func TestRunContextInterrupt(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	ctx, cancel := context.WithCancel(context.Background())
+	var scriptErr error
+	err := loop.RunContext(ctx, func(vm *goja.Runtime) {
+		vm.Set("cancel", cancel)
+		_, scriptErr = vm.RunString(`
+		setTimeout(function() {
+			cancel();
+			for (;;) {}
+		}, 10);
+		`)
+	})
+	if scriptErr != nil {
+		t.Fatal(scriptErr)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		_, scriptErr = vm.RunString("1")
+	})
+	if scriptErr != nil {
+		t.Fatal("interrupt flag was not cleared", scriptErr)
+	}
+}
+
+func TestRunContextNoCancel(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	err := loop.RunContext(context.Background(), func(vm *goja.Runtime) {
+		vm.RunString(`setTimeout(function() {}, 10);`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	ctx, cancel := context.WithCancel(context.Background())
+	loop.StartContext(ctx)
+	ch := make(chan struct{})
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		vm.RunString(`setTimeout(function() {}, 5000);`)
+		close(ch)
+	})
+	<-ch
+	cancel()
+	if remainingJobs := loop.Stop(); remainingJobs != 0 {
+		t.Fatal(remainingJobs)
+	}
+}
+
 func TestEventLoop_StopNoWait(t *testing.T) {
 	t.Parallel()
 	loop := NewEventLoop()
@@ -617,7 +1108,12 @@ func TestEventLoop_Terminate(t *testing.T) {
 	interval := loop.SetInterval(func(vm *goja.Runtime) {}, 10*time.Millisecond)
 	time.Sleep(500 * time.Millisecond)
 	loop.ClearInterval(interval)
+	loop.SetInterval(func(vm *goja.Runtime) {}, 10*time.Millisecond)
+	loop.SetTimeout(func(vm *goja.Runtime) {}, time.Second)
 	loop.Terminate()
+	if c := loop.jobCount; c != 0 {
+		t.Fatalf("jobCount: %d", c)
+	}
 
 	if loop.SetTimeout(func(*goja.Runtime) {}, time.Millisecond) != nil {
 		t.Fatal("was able to SetTimeout()")
@@ -639,3 +1135,52 @@ func TestEventLoop_Terminate(t *testing.T) {
 	<-ch
 	loop.Terminate()
 }
+
+func TestLazyWebGlobals(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	if loop.perf != nil || loop.messagingCtors != nil {
+		t.Fatal("the web globals have been created eagerly")
+	}
+	var err error
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunString(`
+		if (typeof performance.now() !== "number" || performance !== require("perf_hooks").performance) {
+			throw new Error("performance");
+		}
+		if (new MessageChannel().port1.constructor !== MessagePort) {
+			throw new Error("MessagePort");
+		}
+		PerformanceMark = 1;
+		if (PerformanceMark !== 1) {
+			throw new Error("could not replace a global");
+		}
+		`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnableWebGlobals(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(EnableWebGlobals(false))
+	var err error
+	loop.Run(func(vm *goja.Runtime) {
+		_, err = vm.RunString(`
+		for (const name of ["structuredClone", "performance", "PerformanceObserver", "MessageChannel", "BroadcastChannel"]) {
+			if (name in globalThis) {
+				throw new Error(name + " is defined");
+			}
+		}
+		const { MessageChannel } = require("worker_threads");
+		const { performance } = require("perf_hooks");
+		if (typeof MessageChannel !== "function" || typeof performance.now() !== "number") {
+			throw new Error("the modules are not available");
+		}
+		`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package eventloop
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// histogramPrecision is the number of significant bits kept for each recorded value, i.e. the values are
+// recorded with a relative error of less than 1%.
+const histogramPrecision = 7
+
+// histogramHighest is the highest value that can be recorded, the larger ones are only counted as exceeding.
+const histogramHighest = int64(time.Hour)
+
+// histogram records durations (in nanoseconds) in logarithmic buckets, similar to the HdrHistogram used by
+// nodejs, so that its size does not depend on the number of samples.
+type histogram struct {
+	// the number of values in each bucket, keyed by the lowest value of the bucket
+	buckets  map[int64]int64
+	count    int64
+	exceeds  int64
+	min, max int64
+	sum      float64
+	sumSq    float64
+}
+
+func newHistogram() *histogram {
+	h := &histogram{}
+	h.reset()
+	return h
+}
+
+func (h *histogram) reset() {
+	*h = histogram{
+		buckets: make(map[int64]int64),
+		min:     math.MaxInt64,
+	}
+}
+
+func bucketShift(v int64) int {
+	if n := bits.Len64(uint64(v)); n > histogramPrecision {
+		return n - histogramPrecision
+	}
+	return 0
+}
+
+func (h *histogram) record(v int64) {
+	if v < 0 || v > histogramHighest {
+		h.exceeds++
+		return
+	}
+	shift := bucketShift(v)
+	h.buckets[v>>shift<<shift]++
+	h.count++
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	f := float64(v)
+	h.sum += f
+	h.sumSq += f * f
+}
+
+func (h *histogram) mean() float64 {
+	if h.count == 0 {
+		return math.NaN()
+	}
+	return h.sum / float64(h.count)
+}
+
+func (h *histogram) stddev() float64 {
+	if h.count == 0 {
+		return math.NaN()
+	}
+	mean := h.mean()
+	return math.Sqrt(math.Max(h.sumSq/float64(h.count)-mean*mean, 0))
+}
+
+// valueAt returns the value below or at which the specified percentage of the recorded values are (within the
+// precision of the histogram).
+func (h *histogram) valueAt(percentile float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(percentile / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	keys := make([]int64, 0, len(h.buckets))
+	for k := range h.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	var n int64
+	for _, k := range keys {
+		n += h.buckets[k]
+		if n >= rank {
+			v := k + 1<<bucketShift(k) - 1
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// percentiles calls fn for the percentiles reported by nodejs: 0, then halving the distance to 100 until the
+// maximum value is reached, and 100.
+func (h *histogram) percentiles(fn func(percentile float64, v int64)) {
+	if h.count > 0 {
+		fn(0, h.valueAt(0))
+		for half := 50.0; ; half /= 2 {
+			v := h.valueAt(100 - half)
+			fn(100-half, v)
+			if v >= h.max {
+				break
+			}
+		}
+	}
+	fn(100, h.valueAt(100))
+}
@@ -0,0 +1,282 @@
+package eventloop
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/buffer"
+	"github.com/dop251/goja_nodejs/errors"
+)
+
+// readerChunkSize is the maximum size of the chunks produced by ReaderToAsyncIterable().
+const readerChunkSize = 64 * 1024
+
+// maxConsecutiveEmptyReads is the number of Read() calls returning neither data nor an error after which
+// ReaderToAsyncIterable() gives up with io.ErrNoProgress (the same as in bufio).
+const maxConsecutiveEmptyReads = 100
+
+// iterSource produces the values of an async iterable. fetch is called from a separate goroutine each time the
+// script requests a value, never concurrently. close may be called from any goroutine and more than once, it
+// must make a running fetch return.
+type iterSource interface {
+	fetch(ctx context.Context) (value interface{}, done bool, err error)
+	close()
+}
+
+// asyncIterable is the state of an iterable created by ChanToAsyncIterable() or ReaderToAsyncIterable().
+// It is only accessed from the loop.
+type asyncIterable struct {
+	loop *EventLoop
+	src  iterSource
+	// the settle functions of the next() calls which are waiting for a value
+	requests []func(fn func(resolve, reject func(interface{}) error)) bool
+	fetching bool
+	done     bool
+}
+
+// ChanToAsyncIterable creates an async iterable (and iterator) object which yields the values received from the
+// channel. The values are converted using goja.Runtime.ToValue(), except for []byte which become Buffers (the
+// slice is not copied, so it must not be modified after it has been sent). The iteration finishes when the channel
+// is closed.
+//
+// A value is only received when the script asks for it by calling next(), so the sender is blocked until then.
+// Each call of next() keeps the loop alive until it's resolved. Calling return() (e.g. by breaking out of
+// a for-await loop) stops receiving. When the loop is terminated the pending next() calls are abandoned and
+// the channel is no longer read from. A sender that may outlive the consumer should not block on the channel
+// indefinitely.
+//
+// goja does not support for-await loops natively, so unless Symbol.asyncIterator is already defined in the runtime
+// NewEventLoop() polyfills it as Symbol.for("Symbol.asyncIterator"), which is what the code produced by TypeScript
+// or Babel expects. Alternatively the scripts can call next() directly.
+//
+// ChanToAsyncIterable must be called from the loop.
+func ChanToAsyncIterable[T any](loop *EventLoop, ch <-chan T) *goja.Object {
+	return loop.newAsyncIterable(&chanSource[T]{
+		ch:     ch,
+		closed: make(chan struct{}),
+	})
+}
+
+// ReaderToAsyncIterable creates an async iterable (and iterator) object which yields the data read from the
+// reader as Buffers of up to 64KiB. The iteration finishes when the reader returns io.EOF. Other errors reject
+// the pending next() call with an Error created by errors.NewGoError() and finish the iteration. This includes
+// io.ErrNoProgress, which is reported if Read() keeps returning neither data nor an error.
+//
+// The reader is only read from when the script asks for the next chunk, so the data is never buffered ahead.
+// If the reader is an io.Closer, it is closed when the iteration finishes for any reason: at the end of the data,
+// on an error, when return() is called (e.g. by breaking out of a for-await loop) or when the loop is terminated,
+// which also unblocks a pending Read().
+//
+// See ChanToAsyncIterable() for the details about the async iteration and the keep-alive semantics.
+// ReaderToAsyncIterable must be called from the loop.
+func ReaderToAsyncIterable(loop *EventLoop, r io.Reader) *goja.Object {
+	s := &readerSource{
+		r:      r,
+		closed: make(chan struct{}),
+	}
+	s.closer, _ = r.(io.Closer)
+	return loop.newAsyncIterable(s)
+}
+
+// setAsyncIterator makes the object async iterable by adding the [Symbol.asyncIterator]() method that returns
+// the object itself.
+func (loop *EventLoop) setAsyncIterator(o *goja.Object) {
+	o.SetSymbol(loop.asyncIterator, func(call goja.FunctionCall) goja.Value {
+		return call.This
+	})
+}
+
+// defineAsyncIteratorSymbol returns Symbol.asyncIterator, defining it if necessary (see ChanToAsyncIterable()).
+// It's called by NewEventLoop().
+func (loop *EventLoop) defineAsyncIteratorSymbol() *goja.Symbol {
+	r := loop.vm
+	symbol := r.Get("Symbol").ToObject(r)
+	if sym, ok := symbol.Get("asyncIterator").(*goja.Symbol); ok {
+		return sym
+	}
+	symFor, _ := goja.AssertFunction(symbol.Get("for"))
+	v, err := symFor(symbol, r.ToValue("Symbol.asyncIterator"))
+	if err != nil {
+		panic(err)
+	}
+	symbol.DefineDataProperty("asyncIterator", v, goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return v.(*goja.Symbol)
+}
+
+func (loop *EventLoop) newAsyncIterable(src iterSource) *goja.Object {
+	r := loop.vm
+	it := &asyncIterable{
+		loop: loop,
+		src:  src,
+	}
+	o := r.NewObject()
+	o.Set("next", func(call goja.FunctionCall) goja.Value {
+		p, settle := loop.newPendingPromise()
+		it.requests = append(it.requests, settle)
+		it.pump()
+		return r.ToValue(p)
+	})
+	o.Set("return", func(call goja.FunctionCall) goja.Value {
+		it.finish()
+		p, resolve, _ := r.NewPromise()
+		_ = resolve(it.result(call.Argument(0), true))
+		return r.ToValue(p)
+	})
+	loop.setAsyncIterator(o)
+	return o
+}
+
+func (it *asyncIterable) result(value goja.Value, done bool) *goja.Object {
+	r := it.loop.vm
+	res := r.NewObject()
+	res.Set("value", value)
+	res.Set("done", done)
+	return res
+}
+
+// pump starts fetching the value for the earliest waiting next() call, unless a fetch is already in progress.
+// Once the iteration is finished the waiting calls are resolved in order.
+func (it *asyncIterable) pump() {
+	for len(it.requests) > 0 && !it.fetching {
+		settle := it.requests[0]
+		it.requests[0] = nil
+		it.requests = it.requests[1:]
+		if it.done {
+			settle(func(resolve, _ func(interface{}) error) {
+				_ = resolve(it.result(goja.Undefined(), true))
+			})
+			continue
+		}
+		it.fetching = true
+		ctx := it.loop.asyncContext()
+		go func() {
+			v, done, err := it.src.fetch(ctx)
+			if ctx.Err() != nil {
+				it.src.close()
+				return
+			}
+			ok := settle(func(resolve, reject func(interface{}) error) {
+				it.fetching = false
+				switch {
+				case err != nil:
+					it.finish()
+					_ = reject(errors.NewGoError(it.loop.vm, err))
+				case done:
+					it.finish()
+					_ = resolve(it.result(goja.Undefined(), true))
+				default:
+					_ = resolve(it.result(it.loop.toValue(v), false))
+				}
+				it.pump()
+			})
+			if !ok {
+				// the loop has been terminated
+				it.src.close()
+			}
+		}()
+	}
+}
+
+func (it *asyncIterable) finish() {
+	if !it.done {
+		it.done = true
+		it.src.close()
+	}
+}
+
+func (loop *EventLoop) toValue(v interface{}) goja.Value {
+	if b, ok := v.([]byte); ok {
+		return buffer.WrapBytes(loop.vm, b)
+	}
+	return loop.vm.ToValue(v)
+}
+
+type chanSource[T any] struct {
+	ch        <-chan T
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *chanSource[T]) fetch(ctx context.Context) (interface{}, bool, error) {
+	select {
+	case v, ok := <-s.ch:
+		return v, !ok, nil
+	case <-s.closed:
+		return nil, true, nil
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	}
+}
+
+func (s *chanSource[T]) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+type readerSource struct {
+	r      io.Reader
+	closer io.Closer
+	// the error returned by Read() together with the last chunk
+	err       error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *readerSource) fetch(ctx context.Context) (interface{}, bool, error) {
+	if s.err != nil {
+		return s.end(s.err)
+	}
+	if s.closer != nil {
+		// a blocked Read() can only be interrupted by closing the reader
+		reading := make(chan struct{})
+		defer close(reading)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.close()
+			case <-reading:
+			}
+		}()
+	}
+	buf := make([]byte, readerChunkSize)
+	for i := 0; i < maxConsecutiveEmptyReads; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, true, err
+		}
+		n, err := s.r.Read(buf)
+		if n > 0 {
+			s.err = err
+			return buf[:n:n], false, nil
+		}
+		if err != nil {
+			return s.end(err)
+		}
+	}
+	return s.end(io.ErrNoProgress)
+}
+
+// end finishes the iteration because Read() has returned an error.
+func (s *readerSource) end(err error) (interface{}, bool, error) {
+	select {
+	case <-s.closed:
+		// the error is caused by closing the reader
+		return nil, true, nil
+	default:
+	}
+	if err == io.EOF {
+		return nil, true, nil
+	}
+	return nil, true, err
+}
+
+func (s *readerSource) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.closer != nil {
+			_ = s.closer.Close()
+		}
+	})
+}
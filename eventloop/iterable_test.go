@@ -0,0 +1,234 @@
+package eventloop
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// consumeScript reads the iterable named src the way a transpiled for-await loop does.
+const consumeScript = `
+async function consume(iterable, each) {
+	const it = iterable[Symbol.asyncIterator]();
+	for (let r = await it.next(); !r.done; r = await it.next()) {
+		if (each(r.value) === false) {
+			await it.return();
+			break;
+		}
+	}
+}
+`
+
+func TestChanToAsyncIterable(t *testing.T) {
+	t.Parallel()
+	ch := make(chan int)
+	var sent int32
+	go func() {
+		for i := 1; i <= 3; i++ {
+			ch <- i
+			atomic.AddInt32(&sent, 1)
+		}
+		close(ch)
+	}()
+	bytes := make(chan []byte, 1)
+	bytes <- []byte("abc")
+	close(bytes)
+
+	loop := NewEventLoop()
+	var res string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("src", ChanToAsyncIterable(loop, ch))
+		vm.Set("bytes", ChanToAsyncIterable(loop, bytes))
+		vm.Set("sent", func() int32 {
+			return atomic.LoadInt32(&sent)
+		})
+		vm.Set("done", func(s string) {
+			res = s
+		})
+		_, err := vm.RunString(consumeScript + `
+		const { Buffer } = require("buffer");
+		const log = [typeof Symbol.asyncIterator];
+		consume(src, v => {
+			// the producer can not get ahead of the consumer
+			log.push(v, sent() <= v);
+		}).then(() => consume(bytes, b => {
+			log.push(b instanceof Buffer, b.toString());
+		})).then(() => src.next()).then(r => {
+			log.push(r.done);
+			done(log.join());
+		});
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "symbol,1,true,2,true,3,true,true,abc,true" {
+		t.Fatal(res)
+	}
+}
+
+type testReadCloser struct {
+	io.Reader
+	reads, closes int32
+}
+
+func (r *testReadCloser) Read(p []byte) (int, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.Reader.Read(p)
+}
+
+func (r *testReadCloser) Close() error {
+	atomic.AddInt32(&r.closes, 1)
+	return nil
+}
+
+func TestReaderToAsyncIterable(t *testing.T) {
+	t.Parallel()
+	full := &testReadCloser{Reader: strings.NewReader(strings.Repeat("x", 150*1024))}
+	partial := &testReadCloser{Reader: strings.NewReader(strings.Repeat("y", 150*1024))}
+	loop := NewEventLoop()
+	var res string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("full", ReaderToAsyncIterable(loop, full))
+		vm.Set("partial", ReaderToAsyncIterable(loop, partial))
+		vm.Set("reads", func() int32 {
+			return atomic.LoadInt32(&partial.reads)
+		})
+		vm.Set("done", func(s string) {
+			res = s
+		})
+		_, err := vm.RunString(consumeScript + `
+		const sizes = [];
+		consume(full, b => {
+			sizes.push(b.length);
+		}).then(() => consume(partial, b => {
+			sizes.push(reads());
+			return false;
+		})).then(() => done(sizes.join()));
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "65536,65536,22528,1" {
+		t.Fatal(res)
+	}
+	if full.closes != 1 || partial.closes != 1 {
+		t.Fatal(full.closes, partial.closes)
+	}
+}
+
+func TestReaderToAsyncIterableTerminate(t *testing.T) {
+	t.Parallel()
+	pr, pw := io.Pipe()
+	loop := NewEventLoop()
+	loop.Start()
+	started := make(chan struct{})
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		it := ReaderToAsyncIterable(loop, pr)
+		next, _ := goja.AssertFunction(it.Get("next"))
+		if _, err := next(it); err != nil {
+			t.Error(err)
+		}
+		close(started)
+	})
+	<-started
+	loop.Terminate()
+
+	// the pending Read() is interrupted by closing the reader
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := pw.Write([]byte("data")); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-errCh:
+		if err != io.ErrClosedPipe {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reader has not been closed")
+	}
+}
+
+// emptyReader returns neither data nor an error.
+type emptyReader struct {
+	reads int32
+	delay time.Duration
+}
+
+func (r *emptyReader) Read([]byte) (int, error) {
+	atomic.AddInt32(&r.reads, 1)
+	time.Sleep(r.delay)
+	return 0, nil
+}
+
+func TestReaderToAsyncIterableNoProgress(t *testing.T) {
+	t.Parallel()
+	r := &emptyReader{}
+	loop := NewEventLoop()
+	var res string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("r", ReaderToAsyncIterable(loop, r))
+		vm.Set("done", func(s string) {
+			res = s
+		})
+		_, err := vm.RunString(consumeScript + `
+		consume(r, () => {}).then(() => done("finished"), e => done(e.message));
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != io.ErrNoProgress.Error() {
+		t.Fatal(res)
+	}
+	if n := atomic.LoadInt32(&r.reads); n != maxConsecutiveEmptyReads {
+		t.Fatal(n)
+	}
+}
+
+func TestReaderToAsyncIterableTerminateNoCloser(t *testing.T) {
+	t.Parallel()
+	r := &emptyReader{delay: time.Millisecond}
+	loop := NewEventLoop()
+	loop.Start()
+	started := make(chan struct{})
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		it := ReaderToAsyncIterable(loop, r)
+		next, _ := goja.AssertFunction(it.Get("next"))
+		if _, err := next(it); err != nil {
+			t.Error(err)
+		}
+		close(started)
+	})
+	<-started
+	for atomic.LoadInt32(&r.reads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	loop.Terminate()
+
+	// the reader cannot be closed, but it's no longer read from
+	n := atomic.LoadInt32(&r.reads)
+	time.Sleep(20 * time.Millisecond)
+	if n1 := atomic.LoadInt32(&r.reads); n1 > n+1 {
+		t.Fatalf("%d reads after Terminate()", n1-n)
+	}
+}
@@ -0,0 +1,583 @@
+package eventloop
+
+import (
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/goutil"
+	"github.com/dop251/goja_nodejs/process"
+	"github.com/dop251/goja_nodejs/structuredclone"
+)
+
+// messageTarget is the part shared by MessagePort and BroadcastChannel: it dispatches the received messages
+// to the listeners and keeps the loop alive while it's started and ref'd. All fields are only accessed from
+// the loop.
+type messageTarget struct {
+	loop *EventLoop
+	obj  *goja.Object
+	// EventEmitter-style listeners, called with the message data (MessagePort only)
+	emitter process.Emitter
+	// listeners added by addEventListener() and the 'on<event>' handlers, called with an event object
+	listeners map[string][]goja.Value
+	handlers  map[string]goja.Value
+
+	// queue the messages until the target is started, rather than dropping them
+	queueUntilStarted bool
+	queue             []*structuredclone.Data
+	flushing          bool
+
+	started, closed, unref bool
+	job                    *job
+}
+
+// messageEndpoint is the receiving end of a message channel.
+type messageEndpoint interface {
+	// deliver queues the message for dispatching, it can be called from any goroutine
+	deliver(data *structuredclone.Data)
+	// disentangle is called from the loop of the other end once it has been closed
+	disentangle()
+}
+
+// messagePort is a MessagePort in a loop's runtime.
+type messagePort struct {
+	messageTarget
+	peer messageEndpoint
+}
+
+var symMessageTarget = goja.NewSymbol("messageTarget")
+
+func messageTargetOf(v goja.Value) interface{} {
+	if o, ok := v.(*goja.Object); ok {
+		if v := o.GetSymbol(symMessageTarget); v != nil {
+			return v.Export()
+		}
+	}
+	return nil
+}
+
+func (loop *EventLoop) toMessageTarget(v goja.Value, typ string) *messageTarget {
+	switch t := messageTargetOf(v).(type) {
+	case *messagePort:
+		if typ == "MessagePort" {
+			return &t.messageTarget
+		}
+	case *broadcastChannel:
+		if typ == "BroadcastChannel" {
+			return &t.messageTarget
+		}
+	}
+	panic(errors.NewTypeError(loop.vm, errors.ErrCodeInvalidThis, `Value of "this" must be of type %s`, typ))
+}
+
+func (loop *EventLoop) toMessagePort(v goja.Value) *messagePort {
+	return loop.toMessageTarget(v, "MessagePort").obj.GetSymbol(symMessageTarget).Export().(*messagePort)
+}
+
+func (t *messageTarget) initTarget(loop *EventLoop, obj *goja.Object, self interface{}) {
+	t.loop = loop
+	t.obj = obj
+	t.emitter.Changed = func(event string) {
+		if event == "message" && t.emitter.ListenerCount(event) > 0 {
+			t.start()
+		}
+	}
+	loop.initEmitter(obj, &t.emitter)
+	obj.DefineDataPropertySymbol(symMessageTarget, loop.vm.ToValue(self), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+// deliver queues the message to be dispatched on the loop. Can be called from any goroutine.
+func (t *messageTarget) deliver(data *structuredclone.Data) {
+	t.loop.addAuxJob(func() {
+		t.receive(data)
+	})
+}
+
+func (t *messageTarget) receive(data *structuredclone.Data) {
+	if t.closed {
+		return
+	}
+	if !t.started || len(t.queue) > 0 {
+		// preserve the order of the messages queued before the target was started
+		if t.started || t.queueUntilStarted {
+			t.queue = append(t.queue, data)
+		}
+		return
+	}
+	t.dispatchMessage(data)
+}
+
+func (t *messageTarget) dispatchMessage(data *structuredclone.Data) {
+	loop := t.loop
+	loop.runJob(func() {
+		t.dispatch("message", data.Deserialize(loop.vm))
+	})
+}
+
+// start starts dispatching the messages, including the ones that have been queued.
+func (t *messageTarget) start() {
+	if t.started || t.closed {
+		return
+	}
+	t.started = true
+	t.updateRef()
+	if len(t.queue) > 0 && !t.flushing {
+		t.flushing = true
+		t.loop.addAuxJob(t.flush)
+	}
+}
+
+func (t *messageTarget) flush() {
+	t.flushing = false
+	for len(t.queue) > 0 && !t.closed {
+		data := t.queue[0]
+		t.queue[0] = nil
+		t.queue = t.queue[1:]
+		t.dispatchMessage(data)
+	}
+	t.queue = nil
+}
+
+// updateRef makes the target keep the loop alive while it's started, not closed and ref'd.
+func (t *messageTarget) updateRef() {
+	loop := t.loop
+	active := t.started && !t.closed
+	if active && t.job == nil {
+		t.job = &job{}
+		loop.jobCount++
+		if loop.pending == nil {
+			loop.pending = make(map[*job]struct{})
+		}
+		loop.pending[t.job] = struct{}{}
+		if t.unref {
+			loop.unref(t.job)
+		}
+	} else if !active && t.job != nil {
+		if !t.job.cancelled {
+			loop.finishJob(t.job)
+			delete(loop.pending, t.job)
+		}
+		t.job = nil
+	}
+}
+
+func (t *messageTarget) setRef(ref bool) {
+	t.unref = !ref
+	if t.job != nil {
+		if ref {
+			t.loop.ref(t.job)
+		} else {
+			t.loop.unref(t.job)
+		}
+	}
+}
+
+// close closes the target and dispatches the 'close' event asynchronously. Returns false if it was already closed.
+func (t *messageTarget) close() bool {
+	if t.closed {
+		return false
+	}
+	t.closed = true
+	t.queue = nil
+	t.updateRef()
+	loop := t.loop
+	loop.jobCount++
+	loop.addImmediate(func() {
+		loop.runJob(func() {
+			t.dispatch("close", nil)
+		})
+	})
+	return true
+}
+
+// dispatch calls the EventEmitter-style listeners with the data, then the event listeners and the 'on<event>'
+// handler with an event object. The exceptions are reported as uncaught. Must be called from runJob().
+func (t *messageTarget) dispatch(event string, data goja.Value) {
+	loop := t.loop
+	r := loop.vm
+	var args []goja.Value
+	if data != nil {
+		args = []goja.Value{data}
+	}
+	if _, err := t.emitter.Emit(t.obj, event, args...); err != nil {
+		loop.handleException(err)
+	}
+	listeners := t.listeners[event]
+	handler := t.handlers[event]
+	if len(listeners) == 0 && handler == nil {
+		return
+	}
+	ev := r.NewObject()
+	ev.Set("type", event)
+	ev.Set("target", t.obj)
+	ev.Set("currentTarget", t.obj)
+	if data != nil {
+		ev.Set("data", data)
+	}
+	if handler != nil {
+		listeners = append(listeners[:len(listeners):len(listeners)], handler)
+	}
+	for _, l := range listeners {
+		if fn, ok := goja.AssertFunction(l); ok {
+			if _, err := fn(t.obj, ev); err != nil {
+				loop.handleException(err)
+			}
+		}
+	}
+}
+
+// defineMessageTargetMethods defines the EventTarget-style methods and the 'on<event>' handlers on the prototype.
+func (loop *EventLoop) defineMessageTargetMethods(proto *goja.Object, typ string, events ...string) {
+	r := loop.vm
+	proto.Set("addEventListener", func(call goja.FunctionCall) goja.Value {
+		t := loop.toMessageTarget(call.This, typ)
+		event := call.Argument(0).String()
+		fn := call.Argument(1)
+		if _, ok := goja.AssertFunction(fn); !ok {
+			return goja.Undefined()
+		}
+		for _, l := range t.listeners[event] {
+			if l.SameAs(fn) {
+				return goja.Undefined()
+			}
+		}
+		if t.listeners == nil {
+			t.listeners = make(map[string][]goja.Value)
+		}
+		t.listeners[event] = append(t.listeners[event], fn)
+		if event == "message" {
+			t.start()
+		}
+		return goja.Undefined()
+	})
+	proto.Set("removeEventListener", func(call goja.FunctionCall) goja.Value {
+		t := loop.toMessageTarget(call.This, typ)
+		event := call.Argument(0).String()
+		fn := call.Argument(1)
+		list := t.listeners[event]
+		for i, l := range list {
+			if l.SameAs(fn) {
+				// the slice may be iterated over by dispatch(), so it must not be modified in place
+				newList := make([]goja.Value, 0, len(list)-1)
+				newList = append(newList, list[:i]...)
+				t.listeners[event] = append(newList, list[i+1:]...)
+				break
+			}
+		}
+		return goja.Undefined()
+	})
+	for _, event := range events {
+		event := event
+		proto.DefineAccessorProperty("on"+event, r.ToValue(func(call goja.FunctionCall) goja.Value {
+			if h := loop.toMessageTarget(call.This, typ).handlers[event]; h != nil {
+				return h
+			}
+			return goja.Null()
+		}), r.ToValue(func(call goja.FunctionCall) goja.Value {
+			t := loop.toMessageTarget(call.This, typ)
+			v := call.Argument(0)
+			if _, ok := goja.AssertFunction(v); !ok {
+				delete(t.handlers, event)
+				return goja.Undefined()
+			}
+			if t.handlers == nil {
+				t.handlers = make(map[string]goja.Value)
+			}
+			t.handlers[event] = v
+			if event == "message" {
+				t.start()
+			}
+			return goja.Undefined()
+		}), goja.FLAG_TRUE, goja.FLAG_TRUE)
+	}
+	proto.Set("ref", func(call goja.FunctionCall) goja.Value {
+		loop.toMessageTarget(call.This, typ).setRef(true)
+		return call.This
+	})
+	proto.Set("unref", func(call goja.FunctionCall) goja.Value {
+		loop.toMessageTarget(call.This, typ).setRef(false)
+		return call.This
+	})
+	proto.Set("hasRef", func(call goja.FunctionCall) goja.Value {
+		t := loop.toMessageTarget(call.This, typ)
+		return r.ToValue(t.job != nil && !t.unref)
+	})
+}
+
+// enableMessaging defines the MessageChannel, MessagePort and BroadcastChannel globals (see EnableWebGlobals()).
+func (loop *EventLoop) enableMessaging() {
+	for _, name := range []string{"MessageChannel", "MessagePort", "BroadcastChannel"} {
+		name := name
+		loop.defineLazyGlobal(name, func() goja.Value {
+			return loop.messaging()[name]
+		})
+	}
+}
+
+// messaging returns the MessageChannel, MessagePort and BroadcastChannel classes, creating them on first use.
+func (loop *EventLoop) messaging() map[string]goja.Value {
+	if loop.messagingCtors != nil {
+		return loop.messagingCtors
+	}
+	r := loop.vm
+	portCtor, portProto := goutil.NewClass(r, "MessagePort", func(call goja.ConstructorCall) {
+		panic(errors.NewTypeError(r, errors.ErrCodeIllegalConstructor, "Illegal constructor"))
+	})
+	loop.defineEmitterMethods(portProto)
+	loop.defineMessageTargetMethods(portProto, "MessagePort", "message", "messageerror")
+	portProto.Set("postMessage", func(call goja.FunctionCall) goja.Value {
+		p := loop.toMessagePort(call.This)
+		data := loop.serializeMessage(call.Argument(0), call.Argument(1))
+		if !p.closed && p.peer != nil {
+			p.peer.deliver(data)
+		}
+		return goja.Undefined()
+	})
+	portProto.Set("start", func(call goja.FunctionCall) goja.Value {
+		loop.toMessagePort(call.This).start()
+		return goja.Undefined()
+	})
+	portProto.Set("close", func(call goja.FunctionCall) goja.Value {
+		p := loop.toMessagePort(call.This)
+		if p.close() && p.peer != nil {
+			p.peer.disentangle()
+		}
+		return goja.Undefined()
+	})
+	loop.messagePortProto = portProto
+
+	channelCtor, _ := goutil.NewClass(r, "MessageChannel", func(call goja.ConstructorCall) {
+		port1, port2 := loop.newMessagePort(), loop.newMessagePort()
+		port1.peer, port2.peer = port2, port1
+		call.This.DefineDataProperty("port1", port1.obj, goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+		call.This.DefineDataProperty("port2", port2.obj, goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+	})
+
+	loop.messagingCtors = map[string]goja.Value{
+		"MessageChannel":   channelCtor,
+		"MessagePort":      portCtor,
+		"BroadcastChannel": loop.createBroadcastChannel(),
+	}
+	return loop.messagingCtors
+}
+
+func (loop *EventLoop) newMessagePort() *messagePort {
+	loop.messaging()
+	p := &messagePort{}
+	p.queueUntilStarted = true
+	p.initTarget(loop, loop.vm.CreateObject(loop.messagePortProto), p)
+	return p
+}
+
+// disentangle closes the port once the other end has been closed. The ports of a MessageChannel belong to the
+// same loop.
+func (p *messagePort) disentangle() {
+	p.close()
+}
+
+// Port is the Go end of a message channel, the other end of which is a MessagePort in the loop's runtime
+// (see NewPort()).
+type Port struct {
+	loop      *EventLoop
+	port      *messagePort
+	onMessage func(*goja.Runtime, goja.Value)
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPort creates a message channel between Go and the loop's runtime. The messages posted to the MessagePort
+// (see Port.Object()) are structured-cloned and passed to onMessage, which is called from the loop (as a separate
+// job for each message). The onMessage function may be nil, in which case the messages are discarded.
+// NewPort must be called from the loop (i.e. from a function passed to Run() or RunOnLoop(), or from a callback).
+// Like any MessagePort, the JS end keeps the loop alive once it's started and until it's closed or unref'd.
+func (loop *EventLoop) NewPort(onMessage func(vm *goja.Runtime, msg goja.Value)) *Port {
+	p := &Port{
+		loop:      loop,
+		port:      loop.newMessagePort(),
+		onMessage: onMessage,
+		done:      make(chan struct{}),
+	}
+	p.port.peer = p
+	return p
+}
+
+// Object returns the MessagePort object. It must only be used in the loop.
+func (p *Port) Object() *goja.Object {
+	return p.port.obj
+}
+
+// PostMessage sends a message to the MessagePort. The value is converted using goja.Runtime.ToValue() and then
+// structured-cloned on the loop. If it cannot be cloned, a 'messageerror' event is dispatched instead.
+// It is safe to call from any goroutine. Returns false if the port has been closed or the loop is terminated.
+func (p *Port) PostMessage(msg interface{}) bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	loop := p.loop
+	return loop.submit(func() {
+		t := &p.port.messageTarget
+		data, err := structuredclone.Serialize(loop.vm, loop.vm.ToValue(msg), nil)
+		if err != nil {
+			if !t.closed {
+				loop.runJob(func() {
+					t.dispatch("messageerror", structuredclone.ErrorValue(loop.vm, err))
+				})
+			}
+			return
+		}
+		t.receive(data)
+	})
+}
+
+// Close closes the channel, the 'close' event is dispatched on the MessagePort. It is safe to call from any
+// goroutine.
+func (p *Port) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.loop.addAuxJob(func() {
+			p.port.close()
+		})
+	})
+}
+
+// Done returns a channel that is closed once the channel has been closed by either end.
+func (p *Port) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Port) deliver(data *structuredclone.Data) {
+	loop := p.loop
+	loop.addAuxJob(func() {
+		if p.onMessage != nil {
+			loop.runJob(func() {
+				p.onMessage(loop.vm, data.Deserialize(loop.vm))
+			})
+		}
+	})
+}
+
+func (p *Port) disentangle() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// broadcastChannel is a BroadcastChannel. The messages are delivered to all channels with the same name in all
+// loops of the process, except the sender. The open channels of a loop are only registered while the loop is
+// running or has been stopped by Stop(): once it runs out of jobs they are unregistered, so that a loop which is
+// no longer used is not reachable from the registry and does not accumulate messages. They are registered again
+// when the loop is restarted.
+type broadcastChannel struct {
+	messageTarget
+	name string
+}
+
+var broadcast struct {
+	sync.Mutex
+	channels map[string]map[*broadcastChannel]struct{}
+}
+
+func (loop *EventLoop) createBroadcastChannel() *goja.Object {
+	r := loop.vm
+	ctor, proto := goutil.NewClass(r, "BroadcastChannel", func(call goja.ConstructorCall) {
+		if len(call.Arguments) == 0 {
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "name" argument must be specified`))
+		}
+		c := &broadcastChannel{
+			name: call.Argument(0).String(),
+		}
+		c.initTarget(loop, call.This, c)
+		call.This.DefineDataProperty("name", r.ToValue(c.name), goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+		if loop.broadcastChannels == nil {
+			loop.broadcastChannels = make(map[*broadcastChannel]struct{})
+		}
+		loop.broadcastChannels[c] = struct{}{}
+		c.register()
+	})
+	loop.defineMessageTargetMethods(proto, "BroadcastChannel", "message", "messageerror")
+
+	toChannel := func(v goja.Value) *broadcastChannel {
+		return loop.toMessageTarget(v, "BroadcastChannel").obj.GetSymbol(symMessageTarget).Export().(*broadcastChannel)
+	}
+	proto.Set("postMessage", func(call goja.FunctionCall) goja.Value {
+		c := toChannel(call.This)
+		if c.closed {
+			panic(structuredclone.NewDataCloneError(r, "BroadcastChannel is closed."))
+		}
+		// each recipient needs its own copy, because the data can only be deserialized once
+		var recipients []*broadcastChannel
+		broadcast.Lock()
+		for c1 := range broadcast.channels[c.name] {
+			if c1 != c {
+				recipients = append(recipients, c1)
+			}
+		}
+		broadcast.Unlock()
+		for _, c1 := range recipients {
+			c1.deliver(loop.serializeMessage(call.Argument(0), nil))
+		}
+		return goja.Undefined()
+	})
+	proto.Set("close", func(call goja.FunctionCall) goja.Value {
+		c := toChannel(call.This)
+		if !c.closed {
+			c.closed = true
+			c.queue = nil
+			c.updateRef()
+			delete(loop.broadcastChannels, c)
+			c.unregister()
+		}
+		return goja.Undefined()
+	})
+	return ctor
+}
+
+func (c *broadcastChannel) register() {
+	broadcast.Lock()
+	if broadcast.channels == nil {
+		broadcast.channels = make(map[string]map[*broadcastChannel]struct{})
+	}
+	if broadcast.channels[c.name] == nil {
+		broadcast.channels[c.name] = make(map[*broadcastChannel]struct{})
+	}
+	broadcast.channels[c.name][c] = struct{}{}
+	broadcast.Unlock()
+}
+
+func (c *broadcastChannel) unregister() {
+	broadcast.Lock()
+	if m := broadcast.channels[c.name]; m != nil {
+		delete(m, c)
+		if len(m) == 0 {
+			delete(broadcast.channels, c.name)
+		}
+	}
+	broadcast.Unlock()
+}
+
+// registerBroadcastChannels registers the open channels of the loop when it is started.
+func (loop *EventLoop) registerBroadcastChannels() {
+	for c := range loop.broadcastChannels {
+		c.register()
+	}
+}
+
+// unregisterBroadcastChannels unregisters the open channels of the loop when it has run out of jobs.
+func (loop *EventLoop) unregisterBroadcastChannels() {
+	for c := range loop.broadcastChannels {
+		c.unregister()
+	}
+}
+
+// closeBroadcastChannels closes the channels of the loop, it's called by Terminate().
+func (loop *EventLoop) closeBroadcastChannels() {
+	for c := range loop.broadcastChannels {
+		c.closed = true
+		c.queue = nil
+		c.updateRef()
+		c.unregister()
+	}
+	loop.broadcastChannels = nil
+}
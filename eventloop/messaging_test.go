@@ -0,0 +1,216 @@
+package eventloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestMessageChannel(t *testing.T) {
+	t.Parallel()
+	s := runUntilDone(t, `
+	const { port1, port2 } = new MessageChannel();
+	const log = [];
+	let received = 0;
+	port2.postMessage({ n: 1 });
+	port2.postMessage(new Map([["n", 2]]));
+	setTimeout(() => {
+		port1.onmessage = e => {
+			log.push(e.type, e.target === port1, e.data instanceof Map ? e.data.get("n") : e.data.n || e.data);
+			if (++received === 2) {
+				port2.postMessage(3);
+			}
+		};
+		port1.on("message", data => {
+			if (data === 3) {
+				port1.close();
+			}
+		});
+	}, 1);
+	port2.addEventListener("close", e => {
+		log.push(e.type);
+		done(log.join());
+	});
+	try {
+		new MessagePort();
+	} catch (e) {
+		log.push(e.code);
+	}
+	`)
+	if s != "ERR_ILLEGAL_CONSTRUCTOR,message,true,1,message,true,2,message,true,3,close" {
+		t.Fatal(s)
+	}
+}
+
+func TestMessagePortUnref(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	ch := make(chan error, 1)
+	go func() {
+		ch <- loop.RunChecked(func(vm *goja.Runtime) {
+			_, err := vm.RunString(`
+			const { port1 } = new MessageChannel();
+			port1.onmessage = () => {};
+			port1.unref();
+			`)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}()
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		loop.Terminate()
+		t.Fatal("the loop has been kept alive by an unref'd port")
+	}
+}
+
+func TestPort(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var received []string
+	var port *Port
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		port = loop.NewPort(func(vm *goja.Runtime, msg goja.Value) {
+			received = append(received, msg.ToObject(vm).Get("text").String())
+			if len(received) == 1 {
+				go port.PostMessage(map[string]interface{}{"text": "from go"})
+			}
+		})
+		vm.Set("port", port.Object())
+		_, err := vm.RunString(`
+		port.on("message", msg => {
+			port.postMessage({ text: "echo " + msg.text });
+		});
+		port.on("close", () => port.postMessage({ text: "after close" }));
+		port.postMessage({ text: "from js" });
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loop.SetTimeout(func(*goja.Runtime) {
+			port.Close()
+		}, 50*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-port.Done():
+	default:
+		t.Fatal("Done() is not closed")
+	}
+	if port.PostMessage(1) {
+		t.Fatal("PostMessage() on a closed port has succeeded")
+	}
+	if len(received) != 2 || received[0] != "from js" || received[1] != "echo from go" {
+		t.Fatal(received)
+	}
+}
+
+func TestBroadcastChannel(t *testing.T) {
+	t.Parallel()
+	listener := NewEventLoop()
+	listener.Start()
+	defer listener.Terminate()
+	received := make(chan string, 1)
+	listener.RunOnLoop(func(vm *goja.Runtime) {
+		vm.Set("received", func(s string) {
+			received <- s
+		})
+		_, err := vm.RunString(`
+		const bc = new BroadcastChannel("test-broadcast");
+		bc.onmessage = e => received(e.data.join());
+		`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	sender := NewEventLoop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := sender.RunChecked(func(vm *goja.Runtime) {
+			_, err := vm.RunString(`(() => {
+			const bc = new BroadcastChannel("test-broadcast");
+			bc.onmessage = () => { throw new Error("received own message"); };
+			bc.postMessage([1, 2, 3]);
+			bc.close();
+			})()`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case s := <-received:
+			if s != "1,2,3" {
+				t.Fatal(s)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+			// the listener may not have created its channel yet
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+		}
+	}
+}
+
+func TestBroadcastChannelUnregistered(t *testing.T) {
+	t.Parallel()
+	const name = "test-broadcast-unregistered"
+	loop := NewEventLoop()
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`new BroadcastChannel("` + name + `");`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcast.Lock()
+	n := len(broadcast.channels[name])
+	broadcast.Unlock()
+	if n != 0 {
+		t.Fatal("the channel is still registered")
+	}
+
+	sender := NewEventLoop()
+	err = sender.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		const bc = new BroadcastChannel("` + name + `");
+		for (let i = 0; i < 3; i++) {
+			bc.postMessage(i);
+		}
+		bc.close();
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := loop.Stats().AuxJobs; n != 0 {
+		t.Fatal(n)
+	}
+
+	// the channel is registered again when the loop is restarted
+	loop.Start()
+	defer loop.Terminate()
+	broadcast.Lock()
+	n = len(broadcast.channels[name])
+	broadcast.Unlock()
+	if n != 1 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,121 @@
+package eventloop
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/dop251/goja"
+)
+
+var (
+	// ErrCalledFromLoop is returned when a method that waits for the loop (such as Stop(), Terminate() or Call())
+	// or starts it is called from the loop itself, i.e. from a function passed to Run() or RunOnLoop(), from
+	// a callback, or from a function run by Terminate(). Waiting in this case would deadlock.
+	ErrCalledFromLoop = errors.New("eventloop: called from the loop")
+	// ErrAlreadyRunning is returned when the loop is started while it is already running or is being terminated
+	// by another goroutine.
+	ErrAlreadyRunning = errors.New("eventloop: the loop is already running")
+)
+
+// MisuseError is returned (or passed to panic()) instead of ErrCalledFromLoop and ErrAlreadyRunning when the package
+// is built with the eventloop_debug build tag. It wraps the original error, so errors.Is() works in both modes.
+type MisuseError struct {
+	Err error
+	// Stack is the stack trace of the offending call.
+	Stack []byte
+	// StartStack is the stack trace of the call that has started the loop, if it is running.
+	StartStack []byte
+}
+
+func (e *MisuseError) Error() string {
+	if e.StartStack == nil {
+		return fmt.Sprintf("%v\n\n%s", e.Err, e.Stack)
+	}
+	return fmt.Sprintf("%v\n\n%s\nthe loop was started at:\n%s", e.Err, e.Stack, e.StartStack)
+}
+
+func (e *MisuseError) Unwrap() error {
+	return e.Err
+}
+
+// misuse returns the error, wrapped in a *MisuseError in the debug mode. It must be called either from the loop
+// or with stopLock held.
+func (loop *EventLoop) misuse(err error) error {
+	if !debugMisuse {
+		return err
+	}
+	return &MisuseError{
+		Err:        err,
+		Stack:      debug.Stack(),
+		StartStack: loop.startStack,
+	}
+}
+
+// StartChecked is like Start(), but instead of panicking it returns ErrAlreadyRunning if the loop is already
+// running or ErrCalledFromLoop if it is called from the loop.
+func (loop *EventLoop) StartChecked() error {
+	if err := loop.trySetRunning(); err != nil {
+		return err
+	}
+	go loop.run(true, nil)
+	return nil
+}
+
+// RunChecked is like Run(), but instead of panicking it returns ErrAlreadyRunning if the loop is already running
+// or ErrCalledFromLoop if it is called from the loop. Otherwise, it returns the error that has stopped the loop
+// (the same as Err() after Run()).
+func (loop *EventLoop) RunChecked(fn func(*goja.Runtime)) error {
+	if err := loop.trySetRunning(); err != nil {
+		return err
+	}
+	return loop.runFunc(fn, nil)
+}
+
+// StopChecked is like Stop(), but instead of panicking it returns ErrCalledFromLoop if it is called from the loop.
+func (loop *EventLoop) StopChecked() (int, error) {
+	if loop.onLoop() {
+		return 0, loop.misuse(ErrCalledFromLoop)
+	}
+	loop.stopLock.Lock()
+	loop.waitStopped()
+	loop.stopLock.Unlock()
+	return int(loop.jobCount), nil
+}
+
+// TerminateChecked is like Terminate(), but instead of panicking it returns ErrCalledFromLoop if it is called from
+// the loop.
+func (loop *EventLoop) TerminateChecked() error {
+	if loop.onLoop() {
+		return loop.misuse(ErrCalledFromLoop)
+	}
+	loop.stopLock.Lock()
+	loop.waitStopped()
+	// the functions run by terminate() are considered to be running on the loop, and starting the loop
+	// concurrently fails until it's done
+	loop.terminating = true
+	loop.setExecuting(true)
+	loop.stopLock.Unlock()
+
+	loop.terminate()
+
+	loop.setExecuting(false)
+	loop.stopLock.Lock()
+	loop.terminating = false
+	loop.stopLock.Unlock()
+	loop.stopCond.Broadcast()
+	return nil
+}
+
+// waitStopped stops the loop and waits until it is no longer running and is not being terminated. Must be called
+// with stopLock held.
+func (loop *EventLoop) waitStopped() {
+	for loop.running || loop.terminating {
+		if loop.running {
+			atomic.StoreInt32(&loop.canRun, 0)
+			loop.wakeup()
+		}
+		loop.stopCond.Wait()
+	}
+}
@@ -0,0 +1,30 @@
+//go:build eventloop_debug
+
+package eventloop
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMisuseErrorStack(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	loop.Start()
+	defer loop.Terminate()
+	err := loop.StartChecked()
+	var me *MisuseError
+	if !errors.As(err, &me) || me.Err != ErrAlreadyRunning {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(me.Stack), "TestMisuseErrorStack") {
+		t.Fatalf("%s", me.Stack)
+	}
+	if !strings.Contains(string(me.StartStack), "TestMisuseErrorStack") {
+		t.Fatalf("%s", me.StartStack)
+	}
+	if !strings.Contains(err.Error(), "the loop was started at:") {
+		t.Fatal(err)
+	}
+}
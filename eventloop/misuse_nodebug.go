@@ -0,0 +1,6 @@
+//go:build !eventloop_debug
+
+package eventloop
+
+// debugMisuse enables capturing the stack traces for MisuseError (see the eventloop_debug build tag).
+const debugMisuse = false
@@ -0,0 +1,118 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func expectPanic(t *testing.T, target error, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		x := recover()
+		if err, ok := x.(error); !ok || !errors.Is(err, target) {
+			t.Fatalf("unexpected panic: %v", x)
+		}
+	}()
+	fn()
+}
+
+func TestCalledFromLoop(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		if _, err := loop.StopChecked(); !errors.Is(err, ErrCalledFromLoop) {
+			t.Errorf("StopChecked: %v", err)
+		}
+		if err := loop.TerminateChecked(); !errors.Is(err, ErrCalledFromLoop) {
+			t.Errorf("TerminateChecked: %v", err)
+		}
+		if err := loop.StartChecked(); !errors.Is(err, ErrCalledFromLoop) {
+			t.Errorf("StartChecked: %v", err)
+		}
+		if err := loop.RunChecked(func(*goja.Runtime) {}); !errors.Is(err, ErrCalledFromLoop) {
+			t.Errorf("RunChecked: %v", err)
+		}
+		if err := loop.Shutdown(context.Background()); !errors.Is(err, ErrCalledFromLoop) {
+			t.Errorf("Shutdown: %v", err)
+		}
+		loop.SetTimeout(func(*goja.Runtime) {
+			expectPanic(t, ErrCalledFromLoop, func() {
+				loop.Stop()
+			})
+			expectPanic(t, ErrCalledFromLoop, func() {
+				loop.Terminate()
+			})
+		}, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalledFromTerminate(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var stopErr, startErr error
+	loop.RunOnLoop(func(*goja.Runtime) {
+		_, stopErr = loop.StopChecked()
+		startErr = loop.StartChecked()
+	})
+	loop.Terminate()
+	if !errors.Is(stopErr, ErrCalledFromLoop) {
+		t.Fatal(stopErr)
+	}
+	if !errors.Is(startErr, ErrCalledFromLoop) {
+		t.Fatal(startErr)
+	}
+}
+
+func TestAlreadyRunning(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	if err := loop.StartChecked(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loop.StartChecked(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatal(err)
+	}
+	if err := loop.RunChecked(func(*goja.Runtime) {}); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatal(err)
+	}
+	expectPanic(t, ErrAlreadyRunning, loop.Start)
+	if _, err := loop.StopChecked(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loop.RunChecked(func(*goja.Runtime) {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartWhileTerminating(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	started, release := make(chan struct{}), make(chan struct{})
+	loop.RunOnLoop(func(*goja.Runtime) {
+		close(started)
+		<-release
+	})
+	done := make(chan error)
+	go func() {
+		done <- loop.TerminateChecked()
+	}()
+	<-started
+	err := loop.StartChecked()
+	close(release)
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := loop.RunChecked(func(*goja.Runtime) {}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package eventloop
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// pendingStackDepth is the maximum number of frames captured when a job is scheduled from JS (see PendingJob).
+const pendingStackDepth = 10
+
+// PendingJob describes a live timer, interval or immediate (see EventLoop.PendingJobs()).
+type PendingJob struct {
+	// Kind is JobKindTimeout, JobKindInterval or JobKindImmediate.
+	Kind JobKind
+	// Delay is the delay of a timeout or the period of an interval (0 for immediates).
+	Delay time.Duration
+	// Remaining is the time left until the timer is due, it is negative if the timer is overdue (0 for immediates).
+	Remaining time.Duration
+	// Ref is false if the job does not keep the loop alive (see unref() in nodejs).
+	Ref bool
+	// Stack is the JS call stack (the most recent frame first) at the time the job was scheduled. It is only
+	// captured if enabled by CaptureJobStacks(), and it is empty for the jobs scheduled from Go (e.g. by
+	// SetTimeout()).
+	Stack []goja.StackFrame
+}
+
+// CaptureJobStacks controls whether the JS call stack is captured when a timer, an interval or an immediate is
+// scheduled from JS, so that it is reported by PendingJobs(). This helps to find out where the jobs that keep the
+// loop alive come from, but it slows down scheduling, so it is disabled by default.
+func CaptureJobStacks(capture bool) Option {
+	return func(loop *EventLoop) {
+		loop.captureStacks = capture
+	}
+}
+
+// PendingJobs returns the descriptors of all active timeouts and intervals (in the order they are due) followed
+// by the immediates waiting to run (in the order they will run). This is useful for finding out what is keeping
+// the loop alive. The timers that have been submitted from Go but have not been picked up by the loop yet are not
+// included, nor are the promises returned by NewPromise().
+//
+// It is safe to call from any goroutine. If the loop is running and it is called from a different goroutine,
+// the snapshot is taken on the loop (with PriorityHigh), so it waits until the current job has finished, or until
+// the loop stops, whichever comes first. Otherwise it must not be called concurrently with Start() or Run().
+func (loop *EventLoop) PendingJobs() []PendingJob {
+	if !loop.onLoop() {
+		loop.stopLock.Lock()
+		if loop.running {
+			res := make(chan []PendingJob, 1)
+			loop.snapshotRequests = append(loop.snapshotRequests, res)
+			loop.stopLock.Unlock()
+			loop.queueAuxJob(loop.takeSnapshots, PriorityHigh, queueInternal)
+			return <-res
+		}
+		loop.stopLock.Unlock()
+	}
+	return loop.pendingJobs()
+}
+
+// takeSnapshots runs on the loop and answers the waiting PendingJobs() calls.
+func (loop *EventLoop) takeSnapshots() {
+	loop.stopLock.Lock()
+	loop.takeSnapshotsLocked()
+	loop.stopLock.Unlock()
+}
+
+// takeSnapshotsLocked must be called from the loop (or while it is not running) with stopLock held. run() calls
+// it before it clears the running flag, so that the requests queued after the last job do not wait forever.
+func (loop *EventLoop) takeSnapshotsLocked() {
+	if len(loop.snapshotRequests) == 0 {
+		return
+	}
+	jobs := loop.pendingJobs()
+	for _, res := range loop.snapshotRequests {
+		res <- jobs
+	}
+	loop.snapshotRequests = nil
+}
+
+func (loop *EventLoop) pendingJobs() []PendingJob {
+	timers := make([]*timer, 0, len(loop.timers))
+	for _, t := range loop.timers {
+		if !t.internal {
+			timers = append(timers, t)
+		}
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		if timers[i].when.Equal(timers[j].when) {
+			return timers[i].seq < timers[j].seq
+		}
+		return timers[i].when.Before(timers[j].when)
+	})
+	jobs := make([]PendingJob, 0, len(timers)+len(loop.immediates))
+	now := loop.clock.Now()
+	for _, t := range timers {
+		kind := JobKindTimeout
+		if t.repeating {
+			kind = JobKindInterval
+		}
+		jobs = append(jobs, PendingJob{
+			Kind:      kind,
+			Delay:     t.delay,
+			Remaining: t.when.Sub(now),
+			Ref:       !t.unref,
+			Stack:     t.stack,
+		})
+	}
+	for _, i := range loop.immediates {
+		if !i.cancelled && !i.internal {
+			jobs = append(jobs, PendingJob{
+				Kind:  JobKindImmediate,
+				Ref:   !i.unref,
+				Stack: i.stack,
+			})
+		}
+	}
+	return jobs
+}
+
+// captureStack returns the current JS call stack to be stored in a job scheduled from JS (see PendingJob), or nil
+// unless enabled by CaptureJobStacks().
+func (loop *EventLoop) captureStack() []goja.StackFrame {
+	if !loop.captureStacks {
+		return nil
+	}
+	return loop.vm.CaptureCallStack(pendingStackDepth, nil)
+}
+
+// getActiveResourcesInfo implements process.getActiveResourcesInfo(): it returns the names of the resources
+// that keep the loop alive, i.e. 'Timeout' for each referenced timer (including intervals) and 'Immediate' for
+// each referenced immediate.
+func (loop *EventLoop) getActiveResourcesInfo(call goja.FunctionCall) goja.Value {
+	var names []interface{}
+	for _, job := range loop.pendingJobs() {
+		if !job.Ref {
+			continue
+		}
+		if job.Kind == JobKindImmediate {
+			names = append(names, "Immediate")
+		} else {
+			names = append(names, "Timeout")
+		}
+	}
+	return loop.vm.NewArray(names...)
+}
@@ -0,0 +1,146 @@
+package eventloop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestPendingJobs(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(CaptureJobStacks(true))
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		const h = require("perf_hooks").monitorEventLoopDelay();
+		h.enable();
+		function scheduleTimeout() {
+			return setTimeout(() => {}, 1000);
+		}
+		const t = scheduleTimeout();
+		const i = setInterval(() => {}, 500);
+		const u = setTimeout(() => {}, 2000).unref();
+		const imm = setImmediate(() => {});
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs := loop.PendingJobs()
+		if len(jobs) != 4 {
+			t.Fatalf("%+v", jobs)
+		}
+		for i, kind := range []JobKind{JobKindInterval, JobKindTimeout, JobKindTimeout, JobKindImmediate} {
+			if jobs[i].Kind != kind {
+				t.Fatalf("%d: %v", i, jobs[i].Kind)
+			}
+		}
+		if jobs[1].Delay != time.Second || jobs[1].Remaining <= 0 || jobs[1].Remaining > time.Second || !jobs[1].Ref {
+			t.Fatalf("%+v", jobs[1])
+		}
+		if jobs[2].Ref {
+			t.Fatal("the timer should be unref'd")
+		}
+		found := false
+		for _, frame := range jobs[1].Stack {
+			if frame.FuncName() == "scheduleTimeout" {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("%v", jobs[1].Stack)
+		}
+
+		v, err := vm.RunString(`
+		const info = require("process").getActiveResourcesInfo().join();
+		h.disable();
+		clearTimeout(t);
+		clearInterval(i);
+		clearTimeout(u);
+		clearImmediate(imm);
+		info;
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := v.String(); s != "Timeout,Timeout,Immediate" {
+			t.Fatal(s)
+		}
+		if jobs := loop.PendingJobs(); len(jobs) != 0 {
+			t.Fatalf("%+v", jobs)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPendingJobsFromGo(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	if jobs := loop.PendingJobs(); len(jobs) != 0 {
+		t.Fatalf("%+v", jobs)
+	}
+	loop.Start()
+	defer loop.Terminate()
+	loop.SetInterval(func(*goja.Runtime) {}, time.Hour)
+	// the snapshot is taken with a higher priority, so wait until the interval has been picked up
+	if _, err := loop.Call(context.Background(), func(*goja.Runtime) (goja.Value, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	jobs := loop.PendingJobs()
+	if len(jobs) != 1 || jobs[0].Kind != JobKindInterval || jobs[0].Delay != time.Hour || len(jobs[0].Stack) != 0 {
+		t.Fatalf("%+v", jobs)
+	}
+}
+
+func TestPendingJobsWhileStopping(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	done := make(chan []PendingJob, 1)
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("sleep", func(ms int64) {
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+		})
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			done <- loop.PendingJobs()
+		}()
+		if _, err := vm.RunString(`setTimeout(() => sleep(200), 0)`); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the request may have been queued after the last job, the loop must still answer it when it stops
+	select {
+	case jobs := <-done:
+		if len(jobs) != 0 {
+			t.Fatalf("%+v", jobs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PendingJobs() has not returned")
+	}
+}
+
+func TestPendingJobsNoStacks(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	loop.Run(func(vm *goja.Runtime) {
+		if _, err := vm.RunString(`setTimeout(() => {}, 0); setImmediate(() => {});`); err != nil {
+			t.Fatal(err)
+		}
+		jobs := loop.PendingJobs()
+		if len(jobs) != 2 {
+			t.Fatalf("%+v", jobs)
+		}
+		for _, job := range jobs {
+			if job.Stack != nil {
+				t.Fatalf("the stack has been captured: %v", job.Stack)
+			}
+		}
+	})
+}
@@ -0,0 +1,770 @@
+package eventloop
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/goutil"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+const PerfHooksModuleName = "perf_hooks"
+
+// perfEntry is the Go side of a PerformanceEntry (a mark or a measure).
+type perfEntry struct {
+	obj       *goja.Object
+	name      string
+	entryType string
+	startTime float64
+	duration  float64
+	detail    goja.Value
+}
+
+type perfObserver struct {
+	obj        *goja.Object
+	callback   goja.Callable
+	entryTypes map[string]bool
+	buffer     []*perfEntry
+	pending    bool
+}
+
+// delayMonitor is the Go side of the IntervalHistogram returned by monitorEventLoopDelay(). While enabled, the
+// loop records the time by which each timer has been late when it's run (see recordDelay()), so nothing is
+// recorded while there are no timers. The resolution is validated, but not used otherwise.
+type delayMonitor struct {
+	hist       *histogram
+	resolution time.Duration
+	enabled    bool
+}
+
+// performance holds the state of the performance global and the perf_hooks module.
+type performance struct {
+	loop       *EventLoop
+	timeOrigin time.Time
+	// the marks and measures in the order they have been created, at most maxPerfEntries
+	entries   []*perfEntry
+	observers []*perfObserver
+	// the enabled delay monitors
+	monitors []*delayMonitor
+	// the observers that have buffered entries and are waiting for the callback to be called
+	pending []*perfObserver
+
+	obj                                           *goja.Object
+	markProto, measureProto, listProto, histProto *goja.Object
+	// the classes installed as globals, also exported by the perf_hooks module
+	entryCtor, markCtor, measureCtor, observerCtor, listCtor *goja.Object
+	monitorEventLoop                                         goja.Value
+}
+
+var perfEntryTypes = []string{"mark", "measure"}
+
+// maxPerfEntries is the maximum number of entries in the timeline. Once it's full, the new marks and measures are
+// still delivered to the observers, but not added to the timeline until it's cleared with clearMarks() or
+// clearMeasures().
+const maxPerfEntries = 100000
+
+var (
+	symPerfEntry     = goja.NewSymbol("perfEntry")
+	symPerfObserver  = goja.NewSymbol("perfObserver")
+	symPerfEntryList = goja.NewSymbol("perfEntryList")
+	symDelayMonitor  = goja.NewSymbol("delayMonitor")
+)
+
+func requirePerfHooks(runtime *goja.Runtime, module *goja.Object) {
+	p := getLoop(runtime).performance()
+	o := module.Get("exports").(*goja.Object)
+	o.Set("performance", p.obj)
+	for name, v := range p.globals() {
+		o.Set(name, v)
+	}
+	o.Set("monitorEventLoopDelay", p.monitorEventLoop)
+}
+
+// enablePerformance defines the performance global and the related classes (see EnableWebGlobals()).
+func (loop *EventLoop) enablePerformance() {
+	loop.defineLazyGlobal("performance", func() goja.Value {
+		return loop.performance().obj
+	})
+	for name := range (&performance{}).globals() {
+		name := name
+		loop.defineLazyGlobal(name, func() goja.Value {
+			return loop.performance().globals()[name]
+		})
+	}
+}
+
+// performance returns the performance API of the loop, creating it on first use. performance.now() is relative
+// to the time the loop has been created.
+func (loop *EventLoop) performance() *performance {
+	if loop.perf != nil {
+		return loop.perf
+	}
+	p := &performance{
+		loop:       loop,
+		timeOrigin: loop.created,
+	}
+	loop.perf = p
+	p.createEntryClasses()
+	p.createObserver()
+	p.createPerformance()
+	p.createHistogram()
+	return p
+}
+
+// reset clears the timeline and the observers, and moves the time origin to the time the loop has been reset
+// (see Pool).
+func (p *performance) reset() {
+	for _, o := range p.observers {
+		o.pending = false
+	}
+	for _, m := range p.monitors {
+		m.enabled = false
+	}
+	p.monitors = nil
+	p.entries = nil
+	p.observers = nil
+	p.pending = nil
+	p.timeOrigin = p.loop.created
+	p.defineTimeOrigin()
+}
+
+func (p *performance) globals() map[string]goja.Value {
+	return map[string]goja.Value{
+		"PerformanceEntry":             p.entryCtor,
+		"PerformanceMark":              p.markCtor,
+		"PerformanceMeasure":           p.measureCtor,
+		"PerformanceObserver":          p.observerCtor,
+		"PerformanceObserverEntryList": p.listCtor,
+	}
+}
+
+// now returns the number of milliseconds since the time origin.
+func (p *performance) now() float64 {
+	return float64(p.loop.clock.Now().Sub(p.timeOrigin)) / float64(time.Millisecond)
+}
+
+func illegalConstructor(r *goja.Runtime) func(goja.ConstructorCall) {
+	return func(goja.ConstructorCall) {
+		panic(errors.NewTypeError(r, errors.ErrCodeIllegalConstructor, "Illegal constructor"))
+	}
+}
+
+func (p *performance) createEntryClasses() {
+	r := p.loop.vm
+	var entryProto *goja.Object
+	p.entryCtor, entryProto = goutil.NewClass(r, "PerformanceEntry", illegalConstructor(r))
+
+	getter := func(name string, get func(e *perfEntry) goja.Value) {
+		entryProto.DefineAccessorProperty(name, r.ToValue(func(call goja.FunctionCall) goja.Value {
+			return get(p.toEntry(call.This))
+		}), nil, goja.FLAG_TRUE, goja.FLAG_TRUE)
+	}
+	getter("name", func(e *perfEntry) goja.Value {
+		return r.ToValue(e.name)
+	})
+	getter("entryType", func(e *perfEntry) goja.Value {
+		return r.ToValue(e.entryType)
+	})
+	getter("startTime", func(e *perfEntry) goja.Value {
+		return r.ToValue(e.startTime)
+	})
+	getter("duration", func(e *perfEntry) goja.Value {
+		return r.ToValue(e.duration)
+	})
+	entryProto.Set("toJSON", func(call goja.FunctionCall) goja.Value {
+		e := p.toEntry(call.This)
+		o := r.NewObject()
+		o.Set("name", e.name)
+		o.Set("entryType", e.entryType)
+		o.Set("startTime", e.startTime)
+		o.Set("duration", e.duration)
+		if e.detail != nil {
+			o.Set("detail", e.detail)
+		}
+		return o
+	})
+
+	// unlike a PerformanceMeasure, a PerformanceMark can be created directly, but it's not added to the timeline
+	p.markCtor, p.markProto = goutil.NewClass(r, "PerformanceMark", func(call goja.ConstructorCall) {
+		p.initEntry(call.This, p.newMark(call.Argument(0), call.Argument(1)))
+	})
+	p.measureCtor, p.measureProto = goutil.NewClass(r, "PerformanceMeasure", illegalConstructor(r))
+	for _, c := range []*goja.Object{p.markCtor, p.measureCtor} {
+		c.SetPrototype(p.entryCtor)
+		proto := c.Get("prototype").(*goja.Object)
+		proto.SetPrototype(entryProto)
+		proto.DefineAccessorProperty("detail", r.ToValue(func(call goja.FunctionCall) goja.Value {
+			return p.toEntry(call.This).detail
+		}), nil, goja.FLAG_TRUE, goja.FLAG_TRUE)
+	}
+
+	p.listCtor, p.listProto = goutil.NewClass(r, "PerformanceObserverEntryList", illegalConstructor(r))
+	p.defineEntryGetters(p.listProto, func(this goja.Value) []*perfEntry {
+		if o, ok := this.(*goja.Object); ok {
+			if v := o.GetSymbol(symPerfEntryList); v != nil {
+				if list, ok := v.Export().([]*perfEntry); ok {
+					return list
+				}
+			}
+		}
+		panic(errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type PerformanceObserverEntryList`))
+	})
+}
+
+func (p *performance) initEntry(obj *goja.Object, e *perfEntry) {
+	e.obj = obj
+	obj.DefineDataPropertySymbol(symPerfEntry, p.loop.vm.ToValue(e), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+func (p *performance) toEntry(v goja.Value) *perfEntry {
+	if o, ok := v.(*goja.Object); ok {
+		if v := o.GetSymbol(symPerfEntry); v != nil {
+			if e, ok := v.Export().(*perfEntry); ok {
+				return e
+			}
+		}
+	}
+	panic(errors.NewTypeError(p.loop.vm, errors.ErrCodeInvalidThis, `Value of "this" must be of type PerformanceEntry`))
+}
+
+// defineEntryGetters adds getEntries(), getEntriesByName() and getEntriesByType() to the object.
+func (p *performance) defineEntryGetters(o *goja.Object, entries func(this goja.Value) []*perfEntry) {
+	r := p.loop.vm
+	o.Set("getEntries", func(call goja.FunctionCall) goja.Value {
+		return p.entryArray(entries(call.This), func(*perfEntry) bool {
+			return true
+		})
+	})
+	o.Set("getEntriesByName", func(call goja.FunctionCall) goja.Value {
+		list := entries(call.This)
+		if len(call.Arguments) == 0 {
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "name" argument must be specified`))
+		}
+		name := call.Argument(0).String()
+		typ := call.Argument(1)
+		return p.entryArray(list, func(e *perfEntry) bool {
+			return e.name == name && (goja.IsUndefined(typ) || e.entryType == typ.String())
+		})
+	})
+	o.Set("getEntriesByType", func(call goja.FunctionCall) goja.Value {
+		list := entries(call.This)
+		if len(call.Arguments) == 0 {
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "type" argument must be specified`))
+		}
+		typ := call.Argument(0).String()
+		return p.entryArray(list, func(e *perfEntry) bool {
+			return e.entryType == typ
+		})
+	})
+}
+
+// entryArray returns the matching entries sorted by their start time.
+func (p *performance) entryArray(list []*perfEntry, match func(e *perfEntry) bool) goja.Value {
+	var res []*perfEntry
+	for _, e := range list {
+		if match(e) {
+			res = append(res, e)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].startTime < res[j].startTime
+	})
+	values := make([]interface{}, len(res))
+	for i, e := range res {
+		values[i] = e.obj
+	}
+	return p.loop.vm.NewArray(values...)
+}
+
+// timestamp converts a startTime, start or end option (which are in milliseconds relative to the time origin).
+func (p *performance) timestamp(v goja.Value) float64 {
+	r := p.loop.vm
+	if _, ok := v.Export().(float64); !ok {
+		if _, ok := v.Export().(int64); !ok {
+			panic(errors.NewNotCorrectTypeError(r, "timestamp", "number"))
+		}
+	}
+	f := v.ToFloat()
+	if f < 0 || math.IsNaN(f) {
+		panic(errors.NewTypeError(r, errors.ErrCodePerformanceInvalidTimestamp, "%v is not a valid timestamp", v))
+	}
+	return f
+}
+
+func (p *performance) newMark(name, options goja.Value) *perfEntry {
+	r := p.loop.vm
+	if name == nil || goja.IsUndefined(name) {
+		panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "name" argument must be specified`))
+	}
+	e := &perfEntry{
+		name:      name.String(),
+		entryType: "mark",
+		detail:    goja.Null(),
+	}
+	var startTime goja.Value
+	switch o := options.(type) {
+	case *goja.Object:
+		startTime = o.Get("startTime")
+		if d := o.Get("detail"); d != nil && !goja.IsUndefined(d) {
+			e.detail = d
+		}
+	default:
+		if !goja.IsUndefined(options) && !goja.IsNull(options) {
+			panic(errors.NewNotCorrectTypeError(r, "options", "object"))
+		}
+	}
+	if startTime != nil && !goja.IsUndefined(startTime) {
+		e.startTime = p.timestamp(startTime)
+	} else {
+		e.startTime = p.now()
+	}
+	return e
+}
+
+// markTime returns the start time of the latest mark with the name, or the timestamp if v is a number.
+func (p *performance) markTime(v goja.Value) float64 {
+	if _, ok := v.(goja.String); !ok {
+		return p.timestamp(v)
+	}
+	name := v.String()
+	for i := len(p.entries) - 1; i >= 0; i-- {
+		if e := p.entries[i]; e.entryType == "mark" && e.name == name {
+			return e.startTime
+		}
+	}
+	r := p.loop.vm
+	ctor, _ := r.Get("Error").(*goja.Object)
+	e, err := r.New(ctor, r.ToValue(`The "`+name+`" performance mark has not been set`))
+	if err != nil {
+		panic(err)
+	}
+	// resembles a 'SyntaxError' DOMException
+	e.DefineDataProperty("name", r.ToValue("SyntaxError"), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	e.DefineDataProperty("code", r.ToValue(12), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	panic(e)
+}
+
+func (p *performance) newMeasure(name, startOrOptions, endMark goja.Value) *perfEntry {
+	r := p.loop.vm
+	if name == nil || goja.IsUndefined(name) {
+		panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs, `The "name" argument must be specified`))
+	}
+	e := &perfEntry{
+		name:      name.String(),
+		entryType: "measure",
+		detail:    goja.Null(),
+	}
+	hasEndMark := endMark != nil && !goja.IsUndefined(endMark)
+	var end float64
+	if o, ok := startOrOptions.(*goja.Object); ok {
+		start, endOpt, duration := o.Get("start"), o.Get("end"), o.Get("duration")
+		defined := func(v goja.Value) bool {
+			return v != nil && !goja.IsUndefined(v)
+		}
+		if d := o.Get("detail"); defined(d) {
+			e.detail = d
+		}
+		if defined(start) || defined(endOpt) {
+			if hasEndMark {
+				panic(errors.NewTypeError(r, errors.ErrCodePerformanceMeasureInvalidOptions,
+					"Invalid options: endMark must not be specified"))
+			}
+			if defined(start) && defined(endOpt) && defined(duration) {
+				panic(errors.NewTypeError(r, errors.ErrCodePerformanceMeasureInvalidOptions,
+					"Invalid options: start, end, and duration must not all be specified"))
+			}
+		} else if defined(duration) {
+			panic(errors.NewTypeError(r, errors.ErrCodePerformanceMeasureInvalidOptions,
+				"Invalid options: One of options.start or options.end is required"))
+		}
+		switch {
+		case defined(endOpt):
+			end = p.markTime(endOpt)
+		case defined(start) && defined(duration):
+			end = p.markTime(start) + p.timestamp(duration)
+		case hasEndMark:
+			end = p.markTime(endMark)
+		default:
+			end = p.now()
+		}
+		switch {
+		case defined(start):
+			e.startTime = p.markTime(start)
+		case defined(duration):
+			e.startTime = end - p.timestamp(duration)
+		}
+	} else {
+		if hasEndMark {
+			end = p.markTime(endMark)
+		} else {
+			end = p.now()
+		}
+		if startOrOptions != nil && !goja.IsUndefined(startOrOptions) && !goja.IsNull(startOrOptions) {
+			e.startTime = p.markTime(startOrOptions)
+		}
+	}
+	e.duration = end - e.startTime
+	return e
+}
+
+func (p *performance) createPerformance() {
+	r := p.loop.vm
+	o := r.NewObject()
+	p.obj = o
+	p.defineTimeOrigin()
+	o.Set("now", func(goja.FunctionCall) goja.Value {
+		return r.ToValue(p.now())
+	})
+	o.Set("mark", func(call goja.FunctionCall) goja.Value {
+		e := p.newMark(call.Argument(0), call.Argument(1))
+		p.initEntry(r.CreateObject(p.markProto), e)
+		p.add(e)
+		return e.obj
+	})
+	o.Set("measure", func(call goja.FunctionCall) goja.Value {
+		e := p.newMeasure(call.Argument(0), call.Argument(1), call.Argument(2))
+		p.initEntry(r.CreateObject(p.measureProto), e)
+		p.add(e)
+		return e.obj
+	})
+	clear := func(typ string) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			name := call.Argument(0)
+			entries := p.entries[:0]
+			for _, e := range p.entries {
+				if e.entryType != typ || !goja.IsUndefined(name) && e.name != name.String() {
+					entries = append(entries, e)
+				}
+			}
+			for i := len(entries); i < len(p.entries); i++ {
+				p.entries[i] = nil
+			}
+			p.entries = entries
+			return goja.Undefined()
+		}
+	}
+	o.Set("clearMarks", clear("mark"))
+	o.Set("clearMeasures", clear("measure"))
+	p.defineEntryGetters(o, func(goja.Value) []*perfEntry {
+		return p.entries
+	})
+	o.Set("toJSON", func(goja.FunctionCall) goja.Value {
+		res := r.NewObject()
+		res.Set("timeOrigin", o.Get("timeOrigin"))
+		return res
+	})
+}
+
+func (p *performance) defineTimeOrigin() {
+	r := p.loop.vm
+	p.obj.DefineDataProperty("timeOrigin", r.ToValue(float64(p.timeOrigin.UnixNano())/float64(time.Millisecond)),
+		goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+}
+
+// add adds the entry to the timeline (unless it's full) and queues it to the interested observers.
+func (p *performance) add(e *perfEntry) {
+	if len(p.entries) < maxPerfEntries {
+		p.entries = append(p.entries, e)
+	}
+	for _, o := range p.observers {
+		if o.entryTypes[e.entryType] {
+			p.enqueue(o, e)
+		}
+	}
+}
+
+// enqueue buffers the entry for the observer. The callbacks of all the observers with buffered entries are called
+// from an immediate.
+func (p *performance) enqueue(o *perfObserver, e *perfEntry) {
+	o.buffer = append(o.buffer, e)
+	if o.pending {
+		return
+	}
+	o.pending = true
+	p.pending = append(p.pending, o)
+	if len(p.pending) == 1 {
+		loop := p.loop
+		loop.jobCount++
+		loop.addImmediate(func() {
+			loop.runJob(p.notifyObservers)
+		})
+	}
+}
+
+func (p *performance) notifyObservers() {
+	pending := p.pending
+	p.pending = nil
+	for _, o := range pending {
+		o.pending = false
+		if len(o.buffer) == 0 {
+			continue
+		}
+		if _, err := o.callback(o.obj, p.newEntryList(o.takeRecords()), o.obj); err != nil {
+			p.loop.handleException(err)
+		}
+	}
+}
+
+func (o *perfObserver) takeRecords() []*perfEntry {
+	list := o.buffer
+	o.buffer = nil
+	return list
+}
+
+func (p *performance) newEntryList(list []*perfEntry) *goja.Object {
+	o := p.loop.vm.CreateObject(p.listProto)
+	o.DefineDataPropertySymbol(symPerfEntryList, p.loop.vm.ToValue(list), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return o
+}
+
+func (p *performance) createObserver() {
+	r := p.loop.vm
+	ctor, proto := goutil.NewClass(r, "PerformanceObserver", func(call goja.ConstructorCall) {
+		fn, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			panic(errors.NewNotCorrectTypeError(r, "callback", "function"))
+		}
+		o := &perfObserver{
+			obj:        call.This,
+			callback:   fn,
+			entryTypes: make(map[string]bool),
+		}
+		call.This.DefineDataPropertySymbol(symPerfObserver, r.ToValue(o), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	})
+	toObserver := func(v goja.Value) *perfObserver {
+		if o, ok := v.(*goja.Object); ok {
+			if v := o.GetSymbol(symPerfObserver); v != nil {
+				if o, ok := v.Export().(*perfObserver); ok {
+					return o
+				}
+			}
+		}
+		panic(errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type PerformanceObserver`))
+	}
+	supported := func(typ string) bool {
+		for _, t := range perfEntryTypes {
+			if t == typ {
+				return true
+			}
+		}
+		return false
+	}
+
+	proto.Set("observe", func(call goja.FunctionCall) goja.Value {
+		o := toObserver(call.This)
+		opts, ok := call.Argument(0).(*goja.Object)
+		if !ok {
+			panic(errors.NewNotCorrectTypeError(r, "options", "object"))
+		}
+		entryTypes, typ := opts.Get("entryTypes"), opts.Get("type")
+		hasEntryTypes := entryTypes != nil && !goja.IsUndefined(entryTypes)
+		hasType := typ != nil && !goja.IsUndefined(typ)
+		switch {
+		case hasEntryTypes && hasType:
+			panic(errors.NewTypeError(r, errors.ErrCodeInvalidArgValue,
+				"The property 'options.entryTypes' can not be set with options.type together"))
+		case hasEntryTypes:
+			var types []string
+			if err := r.ExportTo(entryTypes, &types); err != nil {
+				panic(errors.NewNotCorrectTypeError(r, "options.entryTypes", "string[]"))
+			}
+			o.entryTypes = make(map[string]bool)
+			for _, t := range types {
+				if supported(t) {
+					o.entryTypes[t] = true
+				}
+			}
+		case hasType:
+			t := typ.String()
+			if !supported(t) {
+				return goja.Undefined()
+			}
+			o.entryTypes[t] = true
+			if buffered := opts.Get("buffered"); buffered != nil && buffered.ToBoolean() {
+				for _, e := range p.entries {
+					if e.entryType == t {
+						p.enqueue(o, e)
+					}
+				}
+			}
+		default:
+			panic(errors.NewTypeError(r, errors.ErrCodeMissingArgs,
+				`The "options.entryTypes" or "options.type" argument must be specified`))
+		}
+		if len(o.entryTypes) == 0 {
+			return goja.Undefined()
+		}
+		for _, observer := range p.observers {
+			if observer == o {
+				return goja.Undefined()
+			}
+		}
+		p.observers = append(p.observers, o)
+		return goja.Undefined()
+	})
+	proto.Set("disconnect", func(call goja.FunctionCall) goja.Value {
+		o := toObserver(call.This)
+		for i, observer := range p.observers {
+			if observer == o {
+				copy(p.observers[i:], p.observers[i+1:])
+				p.observers[len(p.observers)-1] = nil
+				p.observers = p.observers[:len(p.observers)-1]
+				break
+			}
+		}
+		o.entryTypes = make(map[string]bool)
+		o.buffer = nil
+		return goja.Undefined()
+	})
+	proto.Set("takeRecords", func(call goja.FunctionCall) goja.Value {
+		list := toObserver(call.This).takeRecords()
+		values := make([]interface{}, len(list))
+		for i, e := range list {
+			values[i] = e.obj
+		}
+		return r.NewArray(values...)
+	})
+	ctor.DefineAccessorProperty("supportedEntryTypes", r.ToValue(func(goja.FunctionCall) goja.Value {
+		values := make([]interface{}, len(perfEntryTypes))
+		for i, t := range perfEntryTypes {
+			values[i] = t
+		}
+		return r.NewArray(values...)
+	}), nil, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	p.observerCtor = ctor
+}
+
+func (p *performance) createHistogram() {
+	r := p.loop.vm
+	_, proto := goutil.NewClass(r, "IntervalHistogram", illegalConstructor(r))
+	toMonitor := func(v goja.Value) *delayMonitor {
+		if o, ok := v.(*goja.Object); ok {
+			if v := o.GetSymbol(symDelayMonitor); v != nil {
+				if m, ok := v.Export().(*delayMonitor); ok {
+					return m
+				}
+			}
+		}
+		panic(errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type IntervalHistogram`))
+	}
+	getter := func(name string, get func(h *histogram) interface{}) {
+		proto.DefineAccessorProperty(name, r.ToValue(func(call goja.FunctionCall) goja.Value {
+			return r.ToValue(get(toMonitor(call.This).hist))
+		}), nil, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	}
+	getter("count", func(h *histogram) interface{} {
+		return h.count
+	})
+	getter("exceeds", func(h *histogram) interface{} {
+		return h.exceeds
+	})
+	getter("min", func(h *histogram) interface{} {
+		return h.min
+	})
+	getter("max", func(h *histogram) interface{} {
+		return h.max
+	})
+	getter("mean", func(h *histogram) interface{} {
+		return h.mean()
+	})
+	getter("stddev", func(h *histogram) interface{} {
+		return h.stddev()
+	})
+	proto.DefineAccessorProperty("percentiles", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		m := toMonitor(call.This)
+		mapCtor, _ := r.Get("Map").(*goja.Object)
+		res, err := r.New(mapCtor)
+		if err != nil {
+			panic(err)
+		}
+		set, _ := goja.AssertFunction(res.Get("set"))
+		m.hist.percentiles(func(percentile float64, v int64) {
+			if _, err := set(res, r.ToValue(percentile), r.ToValue(v)); err != nil {
+				panic(err)
+			}
+		})
+		return res
+	}), nil, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	proto.Set("percentile", func(call goja.FunctionCall) goja.Value {
+		m := toMonitor(call.This)
+		percentile := call.Argument(0).ToFloat()
+		if !(percentile > 0 && percentile <= 100) {
+			panic(errors.NewArgumentOutOfRangeError(r, "percentile", call.Argument(0)))
+		}
+		return r.ToValue(m.hist.valueAt(percentile))
+	})
+	proto.Set("reset", func(call goja.FunctionCall) goja.Value {
+		toMonitor(call.This).hist.reset()
+		return goja.Undefined()
+	})
+	proto.Set("enable", func(call goja.FunctionCall) goja.Value {
+		m := toMonitor(call.This)
+		if m.enabled {
+			return r.ToValue(false)
+		}
+		m.enabled = true
+		p.monitors = append(p.monitors, m)
+		return r.ToValue(true)
+	})
+	proto.Set("disable", func(call goja.FunctionCall) goja.Value {
+		m := toMonitor(call.This)
+		if !m.enabled {
+			return r.ToValue(false)
+		}
+		m.enabled = false
+		for i, m1 := range p.monitors {
+			if m1 == m {
+				copy(p.monitors[i:], p.monitors[i+1:])
+				p.monitors[len(p.monitors)-1] = nil
+				p.monitors = p.monitors[:len(p.monitors)-1]
+				break
+			}
+		}
+		return r.ToValue(true)
+	})
+	p.histProto = proto
+
+	p.monitorEventLoop = r.ToValue(func(call goja.FunctionCall) goja.Value {
+		resolution := int64(10)
+		switch opts := call.Argument(0).(type) {
+		case *goja.Object:
+			if v := opts.Get("resolution"); v != nil && !goja.IsUndefined(v) {
+				if _, ok := v.Export().(int64); !ok {
+					if _, ok := v.Export().(float64); !ok {
+						panic(errors.NewNotCorrectTypeError(r, "options.resolution", "number"))
+					}
+				}
+				f := v.ToFloat()
+				if f < 1 || f > math.MaxInt32 || f != math.Trunc(f) {
+					panic(errors.NewArgumentOutOfRangeError(r, "options.resolution", v))
+				}
+				resolution = int64(f)
+			}
+		default:
+			if !goja.IsUndefined(opts) {
+				panic(errors.NewNotCorrectTypeError(r, "options", "object"))
+			}
+		}
+		m := &delayMonitor{
+			hist:       newHistogram(),
+			resolution: time.Duration(resolution) * time.Millisecond,
+		}
+		o := r.CreateObject(p.histProto)
+		o.DefineDataPropertySymbol(symDelayMonitor, r.ToValue(m), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+		return o
+	})
+}
+
+// recordDelay records the time by which a timer has been late in the enabled delay monitors.
+func (p *performance) recordDelay(latency time.Duration) {
+	for _, m := range p.monitors {
+		m.hist.record(int64(latency))
+	}
+}
+
+func init() {
+	require.RegisterCoreModule(PerfHooksModuleName, requirePerfHooks)
+}
@@ -0,0 +1,135 @@
+package eventloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestPerformance(t *testing.T) {
+	t.Parallel()
+	clock := NewFakeClock(time.UnixMilli(1000))
+	loop := NewEventLoop(WithClock(clock))
+	var res goja.Value
+	ch := make(chan error, 1)
+	go func() {
+		ch <- loop.RunChecked(func(vm *goja.Runtime) {
+			vm.Set("done", func(v goja.Value) {
+				res = v
+			})
+			_, err := vm.RunString(`
+			const { performance: perf, PerformanceObserver, PerformanceMark } = require("node:perf_hooks");
+			const log = [perf === performance, performance.timeOrigin, performance.now()];
+			const obs = new PerformanceObserver((list, observer) => {
+				log.push(observer === obs, list.getEntries().map(e => [e.entryType, e.name, e.startTime, e.duration].join(":")).join(" "));
+				if (list.getEntriesByType("measure").length > 0) {
+					observer.disconnect();
+					done(log.join());
+				}
+			});
+			obs.observe({ entryTypes: ["mark", "measure", "unknown"] });
+			performance.mark("a", { detail: { x: 1 } });
+			setTimeout(() => {
+				performance.mark("b");
+				const m = performance.measure("a-b", "a", "b");
+				log.push(m instanceof PerformanceEntry, m.duration, performance.now(), performance.getEntriesByName("a")[0].detail.x);
+				performance.clearMarks("a");
+				log.push(performance.getEntries().length);
+				log.push(new PerformanceMark("c", { startTime: 5 }).startTime, performance.getEntriesByName("c").length);
+				for (const f of [() => performance.measure("x", "a"), () => performance.mark(), () => obs.observe({})]) {
+					try {
+						f();
+					} catch (e) {
+						log.push(e.code || e.name);
+					}
+				}
+			}, 1500);
+			`)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}()
+	clock.BlockUntil(1)
+	clock.RunUntilIdle()
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	if res == nil {
+		t.Fatal("done() has not been called")
+	}
+	if s := res.String(); s != "true,1000,0,true,mark:a:0:0,true,1500,1500,1,2,5,0,12,ERR_MISSING_ARGS,ERR_MISSING_ARGS,true,measure:a-b:0:1500 mark:b:1500:0" {
+		t.Fatal(s)
+	}
+}
+
+func TestMonitorEventLoopDelay(t *testing.T) {
+	t.Parallel()
+	s := runUntilDone(t, `
+	const { monitorEventLoopDelay } = require("perf_hooks");
+	const h = monitorEventLoopDelay({ resolution: 5 });
+	const log = [h.enable(), h.enable()];
+	setTimeout(() => {
+		const end = Date.now() + 50;
+		while (Date.now() < end);
+	}, 20);
+	setTimeout(() => {
+		setTimeout(() => {
+			log.push(h.disable(), h.disable(), h.count >= 2, h.min >= 0, h.max >= 40e6, h.percentile(100) === h.max);
+			log.push(h.percentiles instanceof Map, h.percentiles.get(100) === h.max, h.mean >= h.min);
+			h.reset();
+			log.push(h.count, h.max, isNaN(h.mean), h.percentiles.size);
+			done(log.join());
+		}, 20);
+	}, 25);
+	`)
+	if s != "true,false,true,false,true,true,true,true,true,true,true,0,0,true,1" {
+		t.Fatal(s)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	for i := int64(1); i <= 1000; i++ {
+		h.record(i * 1000)
+	}
+	if h.min != 1000 || h.max != 1000000 || h.mean() != 500500 {
+		t.Fatal(h.min, h.max, h.mean())
+	}
+	if v := h.valueAt(50); v < 495000 || v > 505000 {
+		t.Fatal(v)
+	}
+	var percentiles []float64
+	h.percentiles(func(p float64, v int64) {
+		percentiles = append(percentiles, p)
+	})
+	if len(percentiles) < 4 || percentiles[0] != 0 || percentiles[1] != 50 || percentiles[len(percentiles)-1] != 100 {
+		t.Fatal(percentiles)
+	}
+	h.record(-1)
+	if h.exceeds != 1 || h.count != 1000 {
+		t.Fatal(h.exceeds, h.count)
+	}
+}
+
+func TestPerformanceEntriesLimit(t *testing.T) {
+	t.Parallel()
+	s := runUntilDone(t, `
+	const log = [];
+	new PerformanceObserver(list => {
+		log.push(list.getEntries().length);
+		done(log.join());
+	}).observe({ type: "mark" });
+	for (let i = 0; i <= 100000; i++) {
+		performance.mark("m");
+	}
+	log.push(performance.getEntries().length);
+	performance.clearMarks();
+	performance.mark("m");
+	log.push(performance.getEntries().length);
+	`)
+	if s != "100000,1,100002" {
+		t.Fatal(s)
+	}
+}
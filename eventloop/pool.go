@@ -0,0 +1,155 @@
+package eventloop
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+// snapshotPrg returns a function that restores the own properties of the global object (including their
+// attributes) to the state they were in when it was run. It relies only on the functions captured at that
+// point, so that scripts cannot interfere by replacing them.
+var snapshotPrg = goja.MustCompile("node:internal/eventloop/pool", `(function() {
+	var global = globalThis;
+	var getOwnPropertyDescriptor = Object.getOwnPropertyDescriptor, defineProperty = Object.defineProperty;
+	var ownKeys = Reflect.ownKeys, deleteProperty = Reflect.deleteProperty, apply = Reflect.apply;
+	var hasOwn = Object.prototype.hasOwnProperty;
+	var keys = ownKeys(global), saved = {};
+	for (var i = 0; i < keys.length; i++) {
+		saved[keys[i]] = getOwnPropertyDescriptor(global, keys[i]);
+	}
+	return function() {
+		var current = ownKeys(global);
+		for (var i = 0; i < current.length; i++) {
+			var k = current[i];
+			if (!apply(hasOwn, saved, [k]) && !deleteProperty(global, k)) {
+				// e.g. a global 'var', the best that can be done is to clear the value
+				var c = getOwnPropertyDescriptor(global, k);
+				if (c.writable) {
+					global[k] = undefined;
+				}
+			}
+		}
+		for (var i = 0; i < keys.length; i++) {
+			var k = keys[i], d = saved[k], c = getOwnPropertyDescriptor(global, k);
+			if (c === undefined || c.value !== d.value || c.get !== d.get || c.set !== d.set ||
+				c.writable !== d.writable || c.enumerable !== d.enumerable || c.configurable !== d.configurable) {
+				try {
+					defineProperty(global, k, d);
+				} catch (e) {
+					// the property has been made non-configurable
+				}
+			}
+		}
+	};
+})()`, false)
+
+// Pool is a fixed-size set of EventLoops that can be reused for running independent scripts (e.g. one per
+// request), which saves the cost of creating a new loop and runtime every time. All the loops share the same
+// require.Registry, so the compiled modules are cached once.
+//
+// When a loop is released back to the pool, it is terminated (see EventLoop.Terminate()) and the own properties
+// of its global object are restored to the state they were in after the loop was created. Note that other
+// changes made by the scripts (such as modifications of the built-in prototypes or of the exports of the
+// required modules) are not reverted, so a Pool must not be used to isolate untrusted scripts from each other.
+// Also, the properties that cannot be deleted (such as the ones created by global 'var' declarations) are set to
+// undefined, and the top-level lexical declarations ('let', 'const' and 'class') cannot be reverted at all, so the
+// scripts that are run more than once should be wrapped in a function or loaded as modules.
+type Pool struct {
+	registry *require.Registry
+	idle     chan *EventLoop
+	restore  map[*EventLoop]goja.Callable
+
+	mu sync.Mutex
+	// the loops returned by Acquire() that have not been released yet
+	leased map[*EventLoop]struct{}
+}
+
+// NewPool creates a pool of size loops, created by NewEventLoop() with the specified options. Unless an option
+// sets a registry (see WithRegistry()), a new one is created and shared by all the loops.
+func NewPool(size int, opts ...Option) *Pool {
+	if size <= 0 {
+		panic("pool size must be positive")
+	}
+	p := &Pool{
+		idle:    make(chan *EventLoop, size),
+		restore: make(map[*EventLoop]goja.Callable, size),
+		leased:  make(map[*EventLoop]struct{}, size),
+	}
+	opts = append([]Option{WithRegistry(new(require.Registry))}, opts...)
+	for i := 0; i < size; i++ {
+		loop := NewEventLoop(opts...)
+		v, err := loop.vm.RunProgram(snapshotPrg)
+		if err != nil {
+			panic(err)
+		}
+		p.restore[loop], _ = goja.AssertFunction(v)
+		p.registry = loop.registry
+		p.idle <- loop
+	}
+	return p
+}
+
+// Registry returns the require.Registry shared by the loops.
+func (p *Pool) Registry() *require.Registry {
+	return p.registry
+}
+
+// Acquire takes a loop from the pool, waiting until one is available or the context is cancelled, in which
+// case ctx.Err() is returned. The loop is not running and must be returned using Release() once it is no
+// longer needed. Note that tasks cannot be submitted to it (e.g. by RunOnLoop()) until it is started.
+func (p *Pool) Acquire(ctx context.Context) (*EventLoop, error) {
+	var loop *EventLoop
+	select {
+	case loop = <-p.idle:
+	default:
+		select {
+		case loop = <-p.idle:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	p.leased[loop] = struct{}{}
+	p.mu.Unlock()
+	return loop, nil
+}
+
+// Release terminates the loop (so it must not be called concurrently with Run() or Start()), resets its state
+// and returns it to the pool. The loop must not be used after this call. Because the loop is terminated, the
+// promises created by the previous lease (see NewPromise() and AsyncFunc()) are never settled, so the results
+// that arrive late cannot leak into the next lease.
+// Release panics if the loop does not belong to the pool or has already been released.
+func (p *Pool) Release(loop *EventLoop) {
+	restore := p.restore[loop]
+	if restore == nil {
+		panic("the loop does not belong to the pool")
+	}
+	p.mu.Lock()
+	if _, ok := p.leased[loop]; !ok {
+		p.mu.Unlock()
+		panic("the loop has already been released")
+	}
+	delete(p.leased, loop)
+	p.mu.Unlock()
+	loop.Terminate()
+	loop.reset()
+	if _, err := restore(nil); err != nil {
+		panic(err)
+	}
+	p.idle <- loop
+}
+
+// reset clears the state left by the scripts that is not cleared by Terminate().
+func (loop *EventLoop) reset() {
+	loop.vm.ClearInterrupt()
+	loop.process.RemoveAllListeners()
+	loop.rejections = nil
+	loop.reportedRejections = nil
+	loop.created = loop.clock.Now()
+	if loop.perf != nil {
+		loop.perf.reset()
+	}
+}
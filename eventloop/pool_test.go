@@ -0,0 +1,166 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+	p := NewPool(2)
+	ctx := context.Background()
+	loop1, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop2, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loop1 == loop2 {
+		t.Fatal("same loop")
+	}
+	if loop1.registry != loop2.registry || loop1.registry != p.Registry() {
+		t.Fatal("registry is not shared")
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(tctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+
+	loop1.Start()
+	ch := make(chan error, 1)
+	loop1.RunOnLoop(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		var leaked = 1;
+		globalThis.setTimeout = null;
+		Object.defineProperty(globalThis, "hidden", {value: 2, configurable: true});
+		delete globalThis.clearTimeout;
+		setInterval(function() {}, 1000);
+		performance.mark("tenant-A-secret");
+		new PerformanceObserver(function() {}).observe({entryTypes: ["mark"]});
+		`)
+		ch <- err
+	})
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	p.Release(loop1)
+	if s := loop1.Stats(); s.Intervals != 0 {
+		t.Fatalf("%+v", s)
+	}
+	if n := len(loop1.perf.observers); n != 0 {
+		t.Fatalf("%d performance observers left", n)
+	}
+
+	loop3, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loop3 != loop1 {
+		t.Fatal("loop was not returned to the pool")
+	}
+	var scriptErr error
+	err = loop3.RunChecked(func(vm *goja.Runtime) {
+		_, scriptErr = vm.RunString(`
+		if (leaked !== undefined || "hidden" in globalThis) {
+			throw new Error("globals were not removed");
+		}
+		if (typeof setTimeout !== "function" || typeof clearTimeout !== "function") {
+			throw new Error("globals were not restored");
+		}
+		if (performance.getEntries().length !== 0) {
+			throw new Error("performance entries were not cleared");
+		}
+		`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scriptErr != nil {
+		t.Fatal(scriptErr)
+	}
+	p.Release(loop3)
+	p.Release(loop2)
+}
+
+func TestPoolStaleResolver(t *testing.T) {
+	t.Parallel()
+	p := NewPool(1)
+	ctx := context.Background()
+	loop, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resolve func(interface{}) bool
+	err = loop.RunChecked(func(vm *goja.Runtime) {
+		var promise *goja.Promise
+		promise, resolve, _ = loop.NewPromise()
+		vm.Set("p", promise)
+		if _, err := vm.RunString(`p.then(() => { globalThis.stale = true; })`); err != nil {
+			t.Error(err)
+		}
+		loop.StopNoWait()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(loop)
+
+	loop, err = p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release(loop)
+	var stale goja.Value
+	err = loop.RunChecked(func(vm *goja.Runtime) {
+		if resolve("from the previous lease") {
+			t.Error("resolve() has succeeded in the next lease")
+		}
+		loop.SetTimeout(func(vm *goja.Runtime) {
+			stale = vm.Get("stale")
+		}, 10*time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale != nil {
+		t.Fatalf("the continuation from the previous lease has run: %v", stale)
+	}
+}
+
+func TestPoolReleaseTwice(t *testing.T) {
+	t.Parallel()
+	p := NewPool(1)
+	loop, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(loop)
+	defer func() {
+		if x := recover(); x == nil {
+			t.Fatal("the second Release() has not panicked")
+		}
+		if len(p.idle) != 1 {
+			t.Fatalf("%d idle loops", len(p.idle))
+		}
+	}()
+	p.Release(loop)
+}
+
+func TestPoolReleaseForeign(t *testing.T) {
+	t.Parallel()
+	p := NewPool(1)
+	defer func() {
+		if x := recover(); x == nil {
+			t.Fatal("Release() has not panicked")
+		}
+	}()
+	p.Release(NewEventLoop())
+}
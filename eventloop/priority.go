@@ -0,0 +1,152 @@
+package eventloop
+
+import "github.com/dop251/goja"
+
+// Priority is the priority of a function submitted to the loop from Go (see RunOnLoopWithPriority()).
+type Priority int
+
+const (
+	// PriorityLow is for background work that may be delayed by everything else.
+	PriorityLow Priority = iota
+	// PriorityNormal is used by RunOnLoop(), SetTimeout(), SetInterval() and for the internal jobs (such as
+	// settling the promises returned by NewPromise()).
+	PriorityNormal
+	// PriorityHigh is for latency-critical work, it runs ahead of the other submitted functions.
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+// queueMode defines how a job is added to the queue.
+type queueMode int
+
+const (
+	// the internal jobs are only rejected once the loop is terminated
+	queueInternal queueMode = iota
+	// the jobs submitted from Go also fail while the loop is shutting down
+	queueExternal
+	// like queueExternal, but if the queue is full, wait until there is space (unless called from the loop)
+	queueWait
+	// like queueExternal, but fail if the queue is full
+	queueTry
+)
+
+// auxJobsBatch is the maximum number of submitted functions the loop runs before giving the due timers and
+// immediates a chance to run.
+const auxJobsBatch = 64
+
+// auxQueue is a FIFO of the functions submitted with the same priority. Guarded by auxJobsLock.
+type auxQueue struct {
+	jobs []func()
+	head int
+}
+
+func (q *auxQueue) len() int {
+	return len(q.jobs) - q.head
+}
+
+func (q *auxQueue) push(fn func()) {
+	if q.head > 0 && len(q.jobs) == cap(q.jobs) {
+		// reuse the space taken by the functions that have already been removed
+		n := copy(q.jobs, q.jobs[q.head:])
+		for i := n; i < len(q.jobs); i++ {
+			q.jobs[i] = nil
+		}
+		q.jobs = q.jobs[:n]
+		q.head = 0
+	}
+	q.jobs = append(q.jobs, fn)
+}
+
+// pop removes up to n functions from the front of the queue and appends them to dst.
+func (q *auxQueue) pop(n int, dst []func()) []func() {
+	if l := q.len(); n > l {
+		n = l
+	}
+	end := q.head + n
+	dst = append(dst, q.jobs[q.head:end]...)
+	for i := q.head; i < end; i++ {
+		q.jobs[i] = nil
+	}
+	q.head = end
+	if q.head == len(q.jobs) {
+		q.jobs = q.jobs[:0]
+		q.head = 0
+	}
+	return dst
+}
+
+// WithQueueCapacity limits the number of functions submitted from Go that can wait in the loop's queue for each
+// priority. When the queue is full, RunOnLoop() and RunOnLoopWithPriority() block until there is space (unless
+// they are called from the loop, in which case the limit is ignored), and TryRunOnLoop() fails. SetTimeout(),
+// SetInterval() and the internal jobs are not limited. By default, the queue is unbounded.
+func WithQueueCapacity(n int) Option {
+	return func(loop *EventLoop) {
+		loop.auxCapacity = n
+	}
+}
+
+// RunOnLoopWithPriority is like RunOnLoop(), but the function is queued with the specified priority.
+//
+// The functions with the same priority run in the order they have been submitted. Each time the loop picks up
+// the submitted functions it runs a batch of at most 64 of them, taking the higher priority ones first, but at
+// least one from each non-empty queue, so that a steady stream of high priority submissions does not starve
+// the lower priority ones. The timers and immediates that are due run between the batches, so a burst of
+// submissions delays them by at most one batch.
+//
+// If the queue capacity is limited (see WithQueueCapacity()) and the queue for the priority is full, it blocks
+// until there is space, or until the loop is terminated or starts shutting down, in which case it returns false.
+func (loop *EventLoop) RunOnLoopWithPriority(fn func(*goja.Runtime), prio Priority) bool {
+	return loop.queueAuxJob(loop.withFrame(loop.captureFrame(), func() { fn(loop.vm) }), prio, queueWait)
+}
+
+// TryRunOnLoop is like RunOnLoopWithPriority(), but it never blocks. It returns false if the queue for the
+// priority is full (see WithQueueCapacity()), the loop is terminated or is shutting down.
+func (loop *EventLoop) TryRunOnLoop(fn func(*goja.Runtime), prio Priority) bool {
+	return loop.queueAuxJob(loop.withFrame(loop.captureFrame(), func() { fn(loop.vm) }), prio, queueTry)
+}
+
+// takeAuxJobs removes the functions that should run next from the queues. If batch is false, all of them are
+// removed, otherwise at most auxJobsBatch (see RunOnLoopWithPriority()). Returns true if the queues are not empty
+// afterwards. Must be called with auxJobsLock held.
+func (loop *EventLoop) takeAuxJobs(dst []func(), batch bool) ([]func(), bool) {
+	if !batch {
+		for p := numPriorities - 1; p >= 0; p-- {
+			q := &loop.auxJobs[p]
+			dst = q.pop(q.len(), dst)
+		}
+		return dst, false
+	}
+	// every non-empty queue gets one slot, the rest goes to the higher priorities first
+	budget := auxJobsBatch
+	for p := range loop.auxJobs {
+		if loop.auxJobs[p].len() > 0 {
+			budget--
+		}
+	}
+	more := false
+	for p := numPriorities - 1; p >= 0; p-- {
+		q := &loop.auxJobs[p]
+		if q.len() == 0 {
+			continue
+		}
+		n := q.len()
+		if n > budget+1 {
+			n = budget + 1
+		}
+		budget -= n - 1
+		dst = q.pop(n, dst)
+		if q.len() > 0 {
+			more = true
+		}
+	}
+	return dst, more
+}
+
+func (loop *EventLoop) auxJobsLen() int {
+	n := 0
+	for p := range loop.auxJobs {
+		n += loop.auxJobs[p].len()
+	}
+	return n
+}
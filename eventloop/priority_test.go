@@ -0,0 +1,164 @@
+package eventloop
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestRunOnLoopWithPriority(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	var log []string
+	for i, prio := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityLow, PriorityNormal, PriorityHigh} {
+		s := string("lnh"[prio]) + string(rune('0'+i))
+		loop.RunOnLoopWithPriority(func(*goja.Runtime) {
+			log = append(log, s)
+		}, prio)
+	}
+	if err := loop.RunChecked(func(*goja.Runtime) {}); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(log, ","); s != "h2,h5,n1,n4,l0,l3" {
+		t.Fatal(s)
+	}
+}
+
+func TestRunOnLoopBatches(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	loop.Start()
+	defer loop.Terminate()
+
+	var log []string
+	started, gate := make(chan struct{}), make(chan struct{})
+	finished := make(chan struct{})
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		loop.SetTimeout(func(*goja.Runtime) {
+			log = append(log, "timer")
+		}, 10*time.Millisecond)
+		close(started)
+		<-gate
+	})
+	<-started
+	for i := 0; i < 200; i++ {
+		loop.RunOnLoop(func(*goja.Runtime) {
+			time.Sleep(time.Millisecond)
+			log = append(log, "job")
+		})
+	}
+	loop.RunOnLoop(func(*goja.Runtime) {
+		close(finished)
+	})
+	close(gate)
+	<-finished
+	loop.Stop()
+	pos := -1
+	for i, s := range log {
+		if s == "timer" {
+			pos = i
+		}
+	}
+	// the timer has become due while the first batch was running
+	if pos < 0 || pos > 2*auxJobsBatch {
+		t.Fatal(pos, len(log))
+	}
+}
+
+func TestTakeAuxJobs(t *testing.T) {
+	loop := NewEventLoop()
+	var order []Priority
+	for _, n := range []struct {
+		prio  Priority
+		count int
+	}{{PriorityHigh, 100}, {PriorityLow, 5}} {
+		prio := n.prio
+		for i := 0; i < n.count; i++ {
+			loop.auxJobs[prio].push(func() {
+				order = append(order, prio)
+			})
+		}
+	}
+	jobs, more := loop.takeAuxJobs(nil, true)
+	if len(jobs) != auxJobsBatch || !more {
+		t.Fatal(len(jobs), more)
+	}
+	for _, job := range jobs {
+		job()
+	}
+	if order[auxJobsBatch-2] != PriorityHigh || order[auxJobsBatch-1] != PriorityLow {
+		t.Fatal(order)
+	}
+	if n := loop.auxJobs[PriorityHigh].len(); n != 100-auxJobsBatch+1 {
+		t.Fatal(n)
+	}
+	jobs, more = loop.takeAuxJobs(nil, false)
+	if len(jobs) != 100-auxJobsBatch+1+4 || more || loop.auxJobsLen() != 0 {
+		t.Fatal(len(jobs), more)
+	}
+}
+
+func TestQueueCapacity(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(WithQueueCapacity(2))
+	noop := func(*goja.Runtime) {}
+	if !loop.TryRunOnLoop(noop, PriorityNormal) || !loop.TryRunOnLoop(noop, PriorityNormal) {
+		t.Fatal("TryRunOnLoop() has failed")
+	}
+	if loop.TryRunOnLoop(noop, PriorityNormal) {
+		t.Fatal("TryRunOnLoop() has succeeded on a full queue")
+	}
+	if !loop.TryRunOnLoop(noop, PriorityHigh) {
+		t.Fatal("the queues are not separate")
+	}
+	// SetTimeout() is not limited
+	if loop.SetTimeout(noop, 0) == nil {
+		t.Fatal("SetTimeout() has failed")
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan bool, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results <- loop.RunOnLoop(noop)
+	}()
+	select {
+	case <-results:
+		t.Fatal("RunOnLoop() has not blocked on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := loop.RunChecked(func(vm *goja.Runtime) {
+		// the limit does not apply on the loop
+		for i := 0; i < 5; i++ {
+			if !loop.RunOnLoop(noop) {
+				t.Error("RunOnLoop() on the loop has failed")
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if !<-results {
+		t.Fatal("RunOnLoop() has failed")
+	}
+
+	loop.TryRunOnLoop(noop, PriorityLow)
+	loop.TryRunOnLoop(noop, PriorityLow)
+	go func() {
+		results <- loop.RunOnLoopWithPriority(noop, PriorityLow)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	loop.Terminate()
+	select {
+	case ok := <-results:
+		if ok {
+			t.Fatal("RunOnLoopWithPriority() has succeeded on a terminated loop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunOnLoopWithPriority() has not been unblocked by Terminate()")
+	}
+}
@@ -0,0 +1,155 @@
+package eventloop
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/console"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+// UnhandledRejectionMode defines what the loop does when a promise is rejected without a handler and there are
+// no 'unhandledRejection' listeners on process (see WithUnhandledRejectionMode()). The values match the ones of
+// the nodejs --unhandled-rejections option.
+type UnhandledRejectionMode string
+
+const (
+	// UnhandledRejectionsThrow raises the rejection as an uncaught exception, i.e. it is passed to the
+	// 'uncaughtException' listeners or to the error handler (see WithErrorHandler()). If there are none, the loop
+	// is stopped and returns an *UnhandledRejectionError.
+	UnhandledRejectionsThrow UnhandledRejectionMode = "throw"
+	// UnhandledRejectionsWarn prints a warning using console.warn().
+	UnhandledRejectionsWarn UnhandledRejectionMode = "warn"
+	// UnhandledRejectionsNone ignores the rejection. This is the default.
+	UnhandledRejectionsNone UnhandledRejectionMode = "none"
+)
+
+// UnhandledRejectionHandler is called from the loop for every promise that has been rejected without a handler,
+// before the 'unhandledRejection' event is emitted and regardless of the mode. The values must not be used
+// outside the handler.
+type UnhandledRejectionHandler func(vm *goja.Runtime, promise *goja.Promise, reason goja.Value)
+
+// UnhandledRejectionError is returned when the loop is stopped because of an unhandled rejection in
+// UnhandledRejectionsThrow mode.
+type UnhandledRejectionError struct {
+	// Reason is the value the promise was rejected with. It must only be used from the loop.
+	Reason goja.Value
+	msg    string
+}
+
+func (e *UnhandledRejectionError) Error() string {
+	return "unhandled promise rejection: " + e.msg
+}
+
+// WithUnhandledRejectionMode sets the action taken when a promise is rejected without a handler and there are
+// no 'unhandledRejection' listeners. The default is UnhandledRejectionsNone.
+func WithUnhandledRejectionMode(mode UnhandledRejectionMode) Option {
+	switch mode {
+	case UnhandledRejectionsThrow, UnhandledRejectionsWarn, UnhandledRejectionsNone:
+	default:
+		panic("invalid unhandled rejection mode: " + string(mode))
+	}
+	return func(loop *EventLoop) {
+		loop.rejectionMode = mode
+	}
+}
+
+// WithUnhandledRejectionHandler sets a function that is called for every unhandled rejection (see
+// UnhandledRejectionHandler).
+func WithUnhandledRejectionHandler(handler UnhandledRejectionHandler) Option {
+	return func(loop *EventLoop) {
+		loop.rejectionHandler = handler
+	}
+}
+
+// trackRejection is the goja.PromiseRejectionTracker of the loop's runtime. The rejections are not reported
+// straight away, but after the promise jobs have run (see processRejections()), so that a handler added
+// in the meantime prevents the report.
+func (loop *EventLoop) trackRejection(p *goja.Promise, operation goja.PromiseRejectionOperation) {
+	switch operation {
+	case goja.PromiseRejectionReject:
+		loop.rejections = append(loop.rejections, p)
+	case goja.PromiseRejectionHandle:
+		for i, p1 := range loop.rejections {
+			if p1 == p {
+				loop.rejections[i] = nil
+				return
+			}
+		}
+		if _, exists := loop.reportedRejections[p]; exists {
+			delete(loop.reportedRejections, p)
+			loop.ticks = append(loop.ticks, func() {
+				if _, err := loop.process.Emit("rejectionHandled", loop.vm.ToValue(p)); err != nil {
+					loop.handleException(err)
+				}
+			})
+		}
+	}
+}
+
+// processRejections reports the pending unhandled rejections. Returns true if there were any.
+func (loop *EventLoop) processRejections() bool {
+	if len(loop.rejections) == 0 {
+		return false
+	}
+	list := loop.rejections
+	loop.rejections = nil
+	for _, p := range list {
+		if p != nil {
+			loop.reportRejection(p)
+		}
+	}
+	return true
+}
+
+func (loop *EventLoop) reportRejection(p *goja.Promise) {
+	reason := p.Result()
+	if loop.rejectionHandler != nil {
+		loop.rejectionHandler(loop.vm, p, reason)
+	}
+	emitted, err := loop.process.Emit("unhandledRejection", reason, loop.vm.ToValue(p))
+	if err != nil {
+		loop.handleException(err)
+		return
+	}
+	// Only remember the promise if someone is interested in it being handled later, otherwise it would
+	// stay in the map forever.
+	if loop.process.ListenerCount("rejectionHandled") > 0 {
+		if loop.reportedRejections == nil {
+			loop.reportedRejections = make(map[*goja.Promise]struct{})
+		}
+		loop.reportedRejections[p] = struct{}{}
+	}
+	if emitted {
+		return
+	}
+	switch loop.rejectionMode {
+	case UnhandledRejectionsThrow:
+		loop.uncaught(&UnhandledRejectionError{
+			Reason: reason,
+			msg:    loop.describe(reason),
+		}, reason, "unhandledRejection")
+	case UnhandledRejectionsWarn:
+		if c, ok := require.Require(loop.vm, console.ModuleName).(*goja.Object); ok {
+			if warn, ok := goja.AssertFunction(c.Get("warn")); ok {
+				_, _ = warn(c, loop.vm.ToValue("UnhandledPromiseRejectionWarning:"), reason)
+			}
+		}
+	}
+}
+
+// describe returns the stack of an Error or the string representation of any other value, without failing
+// if the conversion throws.
+func (loop *EventLoop) describe(v goja.Value) (s string) {
+	ex := loop.vm.Try(func() {
+		if o, ok := v.(*goja.Object); ok {
+			if stack := o.Get("stack"); stack != nil && goja.IsString(stack) {
+				s = stack.String()
+				return
+			}
+		}
+		s = v.String()
+	})
+	if ex != nil {
+		s = "<" + ex.Error() + ">"
+	}
+	return
+}
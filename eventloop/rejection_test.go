@@ -0,0 +1,114 @@
+package eventloop
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestUnhandledRejectionEvents(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	const process = require("process");
+	var log = [];
+	process.on("unhandledRejection", function(reason, p) {
+		log.push("unhandled " + reason);
+		setTimeout(function() {
+			p.catch(function() {});
+		});
+	});
+	process.on("rejectionHandled", function(p) {
+		log.push("handled");
+	});
+	Promise.reject("a");
+	Promise.reject("b").catch(function() {});
+	var p = Promise.reject("c");
+	Promise.resolve().then(function() {
+		p.catch(function() {});
+	});
+	`
+	loop := NewEventLoop(WithUnhandledRejectionMode(UnhandledRejectionsThrow))
+	var log string
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(SCRIPT)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		log = vm.Get("log").String()
+	})
+	if log != "unhandled a,handled" {
+		t.Fatal(log)
+	}
+}
+
+func TestUnhandledRejectionThrow(t *testing.T) {
+	t.Parallel()
+	var reasons []string
+	loop := NewEventLoop(WithUnhandledRejectionMode(UnhandledRejectionsThrow),
+		WithUnhandledRejectionHandler(func(vm *goja.Runtime, promise *goja.Promise, reason goja.Value) {
+			reasons = append(reasons, reason.String())
+		}))
+	var ran bool
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("ran", func() {
+			ran = true
+		})
+		_, err := vm.RunString(`
+		setTimeout(function() {
+			Promise.reject(new Error("boom"));
+		});
+		setTimeout(ran, 10);
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	var rejErr *UnhandledRejectionError
+	if !errors.As(err, &rejErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(rejErr.Error(), "unhandled promise rejection: Error: boom") {
+		t.Fatal(rejErr.Error())
+	}
+	if ran {
+		t.Fatal("the loop has not stopped")
+	}
+	if len(reasons) != 1 || reasons[0] != "Error: boom" {
+		t.Fatal(reasons)
+	}
+	// the loop can be resumed
+	err = loop.RunChecked(func(*goja.Runtime) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Fatal("not resumed")
+	}
+}
+
+func TestUnhandledRejectionNone(t *testing.T) {
+	t.Parallel()
+	var reasons []string
+	loop := NewEventLoop(WithUnhandledRejectionHandler(func(vm *goja.Runtime, promise *goja.Promise, reason goja.Value) {
+		reasons = append(reasons, reason.String())
+	}))
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`Promise.reject("a");`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 1 || reasons[0] != "a" {
+		t.Fatal(reasons)
+	}
+}
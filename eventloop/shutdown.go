@@ -0,0 +1,100 @@
+package eventloop
+
+import "context"
+
+// Shutdown gracefully stops the loop. It stops accepting new submissions from Go (RunOnLoop(), SetTimeout() and
+// SetInterval() fail from now on), but lets the loop run until it has no more jobs, i.e. until all the active
+// timers (including intervals, which have to be cleared by the script) and immediates have run and all the promises
+// returned by NewPromise() have been settled. A loop started by Start() no longer waits for Stop() after that.
+//
+// Once the loop has been drained, the 'beforeExit' process event is emitted. Its listeners may schedule more
+// work, in which case the loop continues running and the event is emitted again once it has been drained. After
+// that the 'exit' event is emitted with the exit code (0) as the argument.
+//
+// If ctx is done before the loop has been drained, the running script is interrupted (see goja.Runtime.Interrupt()),
+// the loop is stopped and the 'exit' event is emitted with the code 1. In this case a *ContextError is returned.
+//
+// Shutdown waits until the loop has stopped, after which the loop is terminated (see Terminate()). If the loop
+// has been stopped for a different reason in the meantime, the error that has caused it (see Err()) is returned.
+// Calling it on a non-running loop is the same as calling Terminate().
+//
+// It must not be called from the loop (ErrCalledFromLoop is returned in this case), nor concurrently with Stop*(),
+// Start(), Run() or Terminate().
+func (loop *EventLoop) Shutdown(ctx context.Context) error {
+	if loop.onLoop() {
+		return loop.misuse(ErrCalledFromLoop)
+	}
+	loop.stopLock.Lock()
+	running := loop.running
+	loop.stopLock.Unlock()
+	if !running {
+		loop.Terminate()
+		return nil
+	}
+
+	loop.auxJobsLock.Lock()
+	loop.draining = true
+	loop.auxSpace.Broadcast()
+	loop.auxJobsLock.Unlock()
+	loop.addAuxJob(loop.beginShutdown)
+
+	stopped := make(chan struct{})
+	go func() {
+		loop.stopLock.Lock()
+		for loop.running {
+			loop.stopCond.Wait()
+		}
+		loop.stopLock.Unlock()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+		err = loop.Err()
+	case <-ctx.Done():
+		loop.vm.Interrupt(ctx.Err())
+		loop.StopNoWait()
+		<-stopped
+		loop.vm.ClearInterrupt()
+		err = &ContextError{
+			Err:      ctx.Err(),
+			JobsLeft: int(loop.jobCount),
+		}
+	}
+	if !loop.exitEmitted {
+		code := 0
+		if err != nil {
+			code = 1
+		}
+		loop.emitExit(code)
+	}
+	loop.Terminate()
+	return err
+}
+
+// beginShutdown runs on the loop. It lets the loop exit once it has no more jobs.
+func (loop *EventLoop) beginShutdown() {
+	loop.shuttingDown = true
+	if loop.keepAlive {
+		loop.keepAlive = false
+		loop.jobCount--
+	}
+}
+
+func (loop *EventLoop) emitBeforeExit() {
+	loop.runJob(func() {
+		if _, err := loop.process.Emit("beforeExit", loop.vm.ToValue(0)); err != nil {
+			loop.handleException(err)
+		}
+	})
+}
+
+// emitExit emits the 'exit' process event. The nextTick callbacks and the timers scheduled by the listeners are
+// never run.
+func (loop *EventLoop) emitExit(code int) {
+	loop.exitEmitted = true
+	if _, err := loop.process.Emit("exit", loop.vm.ToValue(code)); err != nil {
+		loop.handleException(err)
+	}
+}
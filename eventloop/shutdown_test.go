@@ -0,0 +1,124 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	const process = require("process");
+	var log = [];
+	let ticks = 0;
+	const interval = setInterval(function() {
+		if (++ticks === 3) {
+			log.push("interval");
+			clearInterval(interval);
+		}
+	}, 5);
+	setTimeout(function() {
+		log.push("submitted: " + tryRunOnLoop());
+	}, 30);
+	promise.then(function(v) {
+		log.push(v);
+	});
+	let again = true;
+	process.on("beforeExit", function() {
+		log.push("beforeExit");
+		if (again) {
+			again = false;
+			setTimeout(function() {
+				log.push("again");
+			});
+		}
+	});
+	process.on("exit", function(code) {
+		log.push("exit " + code);
+		setTimeout(function() {
+			log.push("should not run");
+		});
+	});
+	`
+	loop := NewEventLoop()
+	loop.Start()
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		p, resolve, _ := loop.NewPromise()
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			resolve("resolved")
+		}()
+		vm.Set("promise", p)
+		vm.Set("tryRunOnLoop", func() bool {
+			return loop.RunOnLoop(func(*goja.Runtime) {})
+		})
+		_, err := vm.RunString(SCRIPT)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := loop.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if loop.RunOnLoop(func(*goja.Runtime) {}) {
+		t.Fatal("RunOnLoop() has succeeded after Shutdown()")
+	}
+	var log string
+	loop.Run(func(vm *goja.Runtime) {
+		log = vm.Get("log").String()
+	})
+	if log != "interval,resolved,submitted: false,beforeExit,again,beforeExit,exit 0" {
+		t.Fatal(log)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	const process = require("process");
+	var log = [];
+	setInterval(function() {
+		log.push("interval");
+	}, 5);
+	setTimeout(function() {
+		for (;;) {
+			log.length;
+		}
+	}, 20);
+	process.on("exit", function(code) {
+		log.push("exit " + code);
+	});
+	`
+	loop := NewEventLoop()
+	loop.Start()
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		_, err := vm.RunString(SCRIPT)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := loop.Shutdown(ctx)
+	var ctxErr *ContextError
+	if !errors.As(err, &ctxErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if ctxErr.JobsLeft != 1 {
+		t.Fatal(ctxErr.JobsLeft)
+	}
+	var last string
+	loop.Run(func(vm *goja.Runtime) {
+		v, _ := vm.RunString("log[log.length - 1]")
+		last = v.String()
+	})
+	if last != "exit 1" {
+		t.Fatal(last)
+	}
+}
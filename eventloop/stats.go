@@ -0,0 +1,106 @@
+package eventloop
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the loop's state and counters (see EventLoop.Stats()).
+type Stats struct {
+	// Timers is the number of active timeouts (set by setTimeout() or SetTimeout()).
+	Timers int
+	// Intervals is the number of active intervals (set by setInterval() or SetInterval()).
+	Intervals int
+	// Immediates is the number of immediates waiting to run.
+	Immediates int
+	// AuxJobs is the number of functions submitted from Go (e.g. by RunOnLoop()) that have not run yet.
+	AuxJobs int
+
+	// JobsExecuted is the total number of jobs run by the loop.
+	JobsExecuted uint64
+	// BusyTime is the total time spent running jobs.
+	BusyTime time.Duration
+	// MaxLatency is the maximum delay between the time a timer became due and the time it was run.
+	MaxLatency time.Duration
+}
+
+// JobInfo describes a job for a JobObserver.
+type JobInfo struct {
+	Kind JobKind
+	// Latency is the delay between the time the job became due and the time it started. Only set for timers.
+	Latency time.Duration
+}
+
+// JobObserver is notified about every job run by the loop (see WithJobObserver()). Its methods are called from
+// the loop, so they should return quickly.
+type JobObserver interface {
+	// BeforeJob is called before a job starts.
+	BeforeJob(info JobInfo)
+	// AfterJob is called after a job (including the nextTick callbacks and the promise jobs it has queued) has
+	// finished. It is not called if the job has panicked.
+	AfterJob(info JobInfo, elapsed time.Duration)
+}
+
+// WithJobObserver sets a JobObserver for the loop.
+func WithJobObserver(observer JobObserver) Option {
+	return func(loop *EventLoop) {
+		loop.observer = observer
+	}
+}
+
+// loopStats holds the counters that can be read from any goroutine. All access must be atomic.
+type loopStats struct {
+	jobsExecuted uint64
+	busyTime     int64
+	maxLatency   int64
+	timers       int32
+	intervals    int32
+	immediates   int32
+}
+
+// Stats returns a snapshot of the loop's counters. It is safe to call from any goroutine.
+func (loop *EventLoop) Stats() Stats {
+	s := loop.stats
+	loop.auxJobsLock.Lock()
+	auxJobs := loop.auxJobsLen()
+	loop.auxJobsLock.Unlock()
+	return Stats{
+		Timers:       int(atomic.LoadInt32(&s.timers)),
+		Intervals:    int(atomic.LoadInt32(&s.intervals)),
+		Immediates:   int(atomic.LoadInt32(&s.immediates)),
+		AuxJobs:      auxJobs,
+		JobsExecuted: atomic.LoadUint64(&s.jobsExecuted),
+		BusyTime:     time.Duration(atomic.LoadInt64(&s.busyTime)),
+		MaxLatency:   time.Duration(atomic.LoadInt64(&s.maxLatency)),
+	}
+}
+
+func (loop *EventLoop) countTimer(t *timer, delta int32) {
+	if t.repeating {
+		atomic.AddInt32(&loop.stats.intervals, delta)
+	} else {
+		atomic.AddInt32(&loop.stats.timers, delta)
+	}
+}
+
+func (loop *EventLoop) jobStarted(info JobInfo) time.Time {
+	if info.Latency > time.Duration(atomic.LoadInt64(&loop.stats.maxLatency)) {
+		atomic.StoreInt64(&loop.stats.maxLatency, int64(info.Latency))
+	}
+	if loop.perf != nil && len(loop.perf.monitors) > 0 && (info.Kind == JobKindTimeout || info.Kind == JobKindInterval) {
+		loop.perf.recordDelay(info.Latency)
+	}
+	if loop.observer != nil {
+		loop.observer.BeforeJob(info)
+	}
+	return time.Now()
+}
+
+func (loop *EventLoop) jobFinished(info JobInfo, start time.Time) {
+	elapsed := time.Since(start)
+	atomic.AddUint64(&loop.stats.jobsExecuted, 1)
+	atomic.AddInt64(&loop.stats.busyTime, int64(elapsed))
+	if loop.observer != nil {
+		loop.observer.AfterJob(info, elapsed)
+	}
+}
@@ -0,0 +1,76 @@
+package eventloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+type testObserver struct {
+	before, after map[JobKind]int
+}
+
+func (o *testObserver) BeforeJob(info JobInfo) {
+	o.before[info.Kind]++
+}
+
+func (o *testObserver) AfterJob(info JobInfo, elapsed time.Duration) {
+	o.after[info.Kind]++
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	setTimeout(function() {}, 5);
+	clearTimeout(setTimeout(function() {}, 5));
+	var count = 0;
+	var i = setInterval(function() {
+		if (++count === 3) {
+			clearInterval(i);
+		}
+	}, 1);
+	setImmediate(function() {});
+	`
+	o := &testObserver{
+		before: make(map[JobKind]int),
+		after:  make(map[JobKind]int),
+	}
+	loop := NewEventLoop(WithJobObserver(o))
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(SCRIPT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := loop.Stats()
+		if s.Timers != 1 || s.Intervals != 1 || s.Immediates != 1 || s.JobsExecuted != 0 {
+			t.Fatalf("%+v", s)
+		}
+		loop.RunOnLoop(func(*goja.Runtime) {})
+		if s := loop.Stats(); s.AuxJobs != 1 {
+			t.Fatalf("%+v", s)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := loop.Stats()
+	if s.Timers != 0 || s.Intervals != 0 || s.Immediates != 0 || s.AuxJobs != 0 || s.JobsExecuted != 6 {
+		t.Fatalf("%+v", s)
+	}
+	if s.BusyTime <= 0 || s.MaxLatency < 0 {
+		t.Fatalf("%+v", s)
+	}
+	if o.before[JobKindTimeout] != 1 || o.before[JobKindInterval] != 3 || o.before[JobKindImmediate] != 1 ||
+		o.before[JobKindTask] != 1 {
+		t.Fatal(o.before)
+	}
+	if len(o.after) != len(o.before) {
+		t.Fatal(o.after)
+	}
+	for k, v := range o.before {
+		if o.after[k] != v {
+			t.Fatal(o.after)
+		}
+	}
+}
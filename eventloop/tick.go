@@ -0,0 +1,150 @@
+package eventloop
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/process"
+)
+
+// The helpers are JS functions because goja only runs the promise jobs when the outermost call returns. Calling
+// the jobs and the nextTick callbacks from within runJob() makes them nested, so the promise jobs run after the
+// nextTick queue has been drained.
+const helpersPrgName = "node:internal/eventloop"
+
+var helpersPrg = goja.MustCompile(helpersPrgName, `(function() {
+	var resolved = Promise.resolve(), then = Promise.prototype.then;
+	return function(runMicrotask) {
+		return {
+			runJob: function runJob(run) {
+				run();
+			},
+			queueMicrotask: function queueMicrotask(callback) {
+				then.call(resolved, function() {
+					runMicrotask(callback);
+				});
+			}
+		};
+	};
+})()`, true)
+
+func (loop *EventLoop) initHelpers() {
+	v, err := loop.vm.RunProgram(helpersPrg)
+	if err != nil {
+		panic(err)
+	}
+	init, _ := goja.AssertFunction(v)
+	v, err = init(nil, loop.vm.ToValue(loop.runMicrotask))
+	if err != nil {
+		panic(err)
+	}
+	helpers := v.(*goja.Object)
+	loop.jobRunner, _ = goja.AssertFunction(helpers.Get("runJob"))
+	loop.enqueueMicrotask, _ = goja.AssertFunction(helpers.Get("queueMicrotask"))
+	loop.runCurrentJobFn = loop.vm.ToValue(loop.runCurrentJob)
+}
+
+// enableProcess adds the loop-specific functions to the "process" module.
+func (loop *EventLoop) enableProcess() {
+	loop.process = process.GetApi(loop.vm)
+	loop.process.Object().Set("nextTick", loop.nextTick)
+	loop.process.Object().Set("getActiveResourcesInfo", loop.getActiveResourcesInfo)
+}
+
+// runJob runs the function followed by the nextTick queue and the promise jobs queue, in that order, repeating
+// the last two until both are empty, after which the unhandled rejections are reported (i.e. the same way nodejs
+// runs a macrotask).
+func (loop *EventLoop) runJob(fn func()) {
+	loop.currentJob = fn
+	loop.runCurrent()
+	// promise jobs and rejection listeners may have queued more ticks
+	for len(loop.ticks) > 0 || loop.processRejections() {
+		loop.runCurrent()
+	}
+}
+
+func (loop *EventLoop) runCurrent() {
+	_, err := loop.jobRunner(nil, loop.runCurrentJobFn)
+	loop.currentJob = nil
+	if err != nil {
+		loop.handleException(err)
+	}
+}
+
+func (loop *EventLoop) runCurrentJob(goja.FunctionCall) goja.Value {
+	if fn := loop.currentJob; fn != nil {
+		loop.currentJob = nil
+		fn()
+	}
+	loop.runTicks()
+	return nil
+}
+
+func (loop *EventLoop) runTicks() {
+	for len(loop.ticks) > 0 {
+		ticks := loop.ticks
+		loop.ticks = nil
+		for i, tick := range ticks {
+			ticks[i] = nil
+			tick()
+		}
+	}
+}
+
+// afterJob runs the nextTick callbacks queued by native code (e.g. a function passed to RunOnLoop()) and
+// reports the unhandled rejections.
+func (loop *EventLoop) afterJob() {
+	if len(loop.ticks) > 0 || len(loop.rejections) > 0 {
+		loop.runJob(nil)
+	}
+}
+
+// jsJob returns a job function that calls a JS callback (see runJob()).
+func (loop *EventLoop) jsJob(fn goja.Callable, args []goja.Value) func() {
+	call := func() {
+		if _, err := fn(nil, args...); err != nil {
+			loop.handleException(err)
+		}
+	}
+	return func() {
+		loop.runJob(call)
+	}
+}
+
+func (loop *EventLoop) assertCallback(v goja.Value) goja.Callable {
+	if fn, ok := goja.AssertFunction(v); ok {
+		return fn
+	}
+	panic(errors.NewNotCorrectTypeError(loop.vm, "callback", "function"))
+}
+
+func (loop *EventLoop) nextTick(call goja.FunctionCall) goja.Value {
+	fn := loop.assertCallback(call.Argument(0))
+	var args []goja.Value
+	if len(call.Arguments) > 1 {
+		args = append(args, call.Arguments[1:]...)
+	}
+	loop.ticks = append(loop.ticks, loop.bindFrame(func() {
+		if _, err := fn(nil, args...); err != nil {
+			loop.handleException(err)
+		}
+	}))
+	return nil
+}
+
+func (loop *EventLoop) runMicrotask(call goja.FunctionCall) goja.Value {
+	if fn, ok := goja.AssertFunction(call.Argument(0)); ok {
+		if _, err := fn(nil); err != nil {
+			loop.handleException(err)
+		}
+	}
+	return nil
+}
+
+func (loop *EventLoop) queueMicrotask(call goja.FunctionCall) goja.Value {
+	loop.assertCallback(call.Argument(0))
+	_, err := loop.enqueueMicrotask(nil, call.Argument(0))
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package eventloop
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+)
+
+// createTimeoutProto creates the prototype for the objects returned by setTimeout() and setInterval(). It
+// implements the methods of the nodejs Timeout class.
+func (loop *EventLoop) createTimeoutProto() *goja.Object {
+	r := loop.vm
+	p := r.NewObject()
+
+	p.Set("ref", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		loop.ref(&loop.toTimer(call.This).job)
+		return call.This
+	}))
+
+	p.Set("unref", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		loop.unref(&loop.toTimer(call.This).job)
+		return call.This
+	}))
+
+	p.Set("hasRef", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		return r.ToValue(!loop.toTimer(call.This).unref)
+	}))
+
+	p.Set("refresh", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		switch t := call.This.Export().(type) {
+		case *Timer:
+			loop.refreshTimeout(t)
+		case *Interval:
+			loop.refreshTimer(&t.timer)
+		default:
+			panic(newInvalidTimeoutThisError(r))
+		}
+		return call.This
+	}))
+
+	p.Set("close", r.ToValue(func(call goja.FunctionCall) goja.Value {
+		loop.clearTimer(loop.toTimer(call.This))
+		return call.This
+	}))
+
+	p.SetSymbol(goja.SymToPrimitive, r.ToValue(func(call goja.FunctionCall) goja.Value {
+		return r.ToValue(loop.toTimer(call.This).id)
+	}))
+
+	return p
+}
+
+func newInvalidTimeoutThisError(r *goja.Runtime) *goja.Object {
+	return errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type Timeout`)
+}
+
+func (loop *EventLoop) toTimer(v goja.Value) *timer {
+	if t := lookupTimerObject(v); t != nil {
+		return t
+	}
+	panic(newInvalidTimeoutThisError(loop.vm))
+}
+
+func lookupTimerObject(v goja.Value) *timer {
+	switch t := v.Export().(type) {
+	case *Timer:
+		return &t.timer
+	case *Interval:
+		return &t.timer
+	}
+	return nil
+}
+
+// addTimerId assigns a numeric id to the job so that it can be passed to clearTimeout() and clearInterval()
+// as a primitive value.
+func (loop *EventLoop) addTimerId(t *timer) {
+	loop.lastTimerId++
+	t.id = loop.lastTimerId
+	if loop.timersById == nil {
+		loop.timersById = make(map[int64]*timer)
+	}
+	loop.timersById[t.id] = t
+}
+
+// lookupTimer returns the timer corresponding to the argument of clearTimeout() or clearInterval() which can
+// either be a Timeout object or its primitive id. Returns nil if there is no such active timer.
+func (loop *EventLoop) lookupTimer(v goja.Value) *timer {
+	if goja.IsNumber(v) || goja.IsString(v) {
+		return loop.timersById[v.ToInteger()]
+	}
+	return lookupTimerObject(v)
+}
+
+func (loop *EventLoop) jsClearTimer(call goja.FunctionCall) goja.Value {
+	if t := loop.lookupTimer(call.Argument(0)); t != nil {
+		loop.clearTimer(t)
+	}
+	return nil
+}
+
+func (loop *EventLoop) ref(job *job) {
+	if job.unref {
+		job.unref = false
+		if !job.cancelled {
+			loop.jobCount++
+		}
+	}
+}
+
+func (loop *EventLoop) unref(job *job) {
+	if !job.unref {
+		job.unref = true
+		if !job.cancelled {
+			loop.jobCount--
+		}
+	}
+}
+
+// refreshTimeout restarts the timer using the original delay. If the timer has already fired it is re-activated.
+// Calling it on a cleared timer has no effect.
+func (loop *EventLoop) refreshTimeout(t *Timer) {
+	if t.fired {
+		t.fired = false
+		t.cancelled = false
+		if !t.unref {
+			loop.jobCount++
+		}
+		loop.timersById[t.id] = &t.timer
+		loop.addTimer(&t.timer)
+		return
+	}
+	loop.refreshTimer(&t.timer)
+}
+
+func (loop *EventLoop) refreshTimer(t *timer) {
+	if !t.cancelled {
+		loop.removeTimer(t)
+		loop.addTimer(t)
+	}
+}
@@ -0,0 +1,381 @@
+package eventloop
+
+import (
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+const (
+	TimersModuleName         = "timers"
+	TimersPromisesModuleName = "timers/promises"
+)
+
+var symLoop = goja.NewSymbol("eventloop")
+
+// loopRef is stored in the runtime so that the core modules can find the loop. It has no exported fields
+// or methods, so it's opaque for the scripts.
+type loopRef struct {
+	loop *EventLoop
+}
+
+func (loop *EventLoop) bindToRuntime() {
+	loop.vm.GlobalObject().DefineDataPropertySymbol(symLoop, loop.vm.ToValue(&loopRef{loop: loop}),
+		goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+// getLoop returns the EventLoop that owns the runtime. It panics with a JS error if there is none.
+func getLoop(r *goja.Runtime) *EventLoop {
+	if v := r.GlobalObject().GetSymbol(symLoop); v != nil {
+		if ref, ok := v.Export().(*loopRef); ok {
+			return ref.loop
+		}
+	}
+	panic(r.NewGoError(errNoLoop))
+}
+
+type noLoopError struct{}
+
+func (noLoopError) Error() string {
+	return "the module can only be used in a runtime that belongs to an EventLoop"
+}
+
+var errNoLoop noLoopError
+
+func requireTimers(runtime *goja.Runtime, module *goja.Object) {
+	loop := getLoop(runtime)
+	o := module.Get("exports").(*goja.Object)
+	for _, name := range []string{"setTimeout", "clearTimeout", "setInterval", "clearInterval", "setImmediate",
+		"clearImmediate"} {
+		o.Set(name, loop.timerFuncs[name])
+	}
+	o.Set("promises", require.Require(runtime, TimersPromisesModuleName))
+}
+
+func requireTimersPromises(runtime *goja.Runtime, module *goja.Object) {
+	loop := getLoop(runtime)
+	o := module.Get("exports").(*goja.Object)
+	o.Set("setTimeout", loop.promiseSetTimeout)
+	o.Set("setImmediate", loop.promiseSetImmediate)
+	o.Set("setInterval", loop.promiseSetInterval)
+
+	scheduler := runtime.NewObject()
+	scheduler.Set("wait", func(call goja.FunctionCall) goja.Value {
+		return loop.promiseSetTimeout(goja.FunctionCall{
+			This:      call.This,
+			Arguments: []goja.Value{call.Argument(0), goja.Undefined(), call.Argument(1)},
+		})
+	})
+	scheduler.Set("yield", func(call goja.FunctionCall) goja.Value {
+		return loop.promiseSetImmediate(goja.FunctionCall{})
+	})
+	o.Set("scheduler", scheduler)
+}
+
+// timerOptions are the options of the functions in timers/promises.
+type timerOptions struct {
+	signal *goja.Object
+	ref    bool
+}
+
+func (loop *EventLoop) parseTimerOptions(v goja.Value) timerOptions {
+	opts := timerOptions{ref: true}
+	if v == nil || goja.IsUndefined(v) {
+		return opts
+	}
+	r := loop.vm
+	o, ok := v.(*goja.Object)
+	if !ok {
+		panic(errors.NewNotCorrectTypeError(r, "options", "object"))
+	}
+	if signal := o.Get("signal"); signal != nil && !goja.IsUndefined(signal) {
+		opts.signal = loop.assertAbortSignal(signal, "options.signal")
+	}
+	if ref := o.Get("ref"); ref != nil && !goja.IsUndefined(ref) {
+		if !isBoolean(ref) {
+			panic(errors.NewTypeError(r, errors.ErrCodeInvalidArgType, `The "options.ref" property must be of type boolean.`))
+		}
+		opts.ref = ref.ToBoolean()
+	}
+	return opts
+}
+
+func isBoolean(v goja.Value) bool {
+	_, ok := v.Export().(bool)
+	return ok
+}
+
+// assertAbortSignal checks that the value looks like an AbortSignal (i.e. has the 'aborted' property and the
+// addEventListener() method).
+func (loop *EventLoop) assertAbortSignal(v goja.Value, name string) *goja.Object {
+	if o, ok := v.(*goja.Object); ok && o.Get("aborted") != nil {
+		if _, ok := goja.AssertFunction(o.Get("addEventListener")); ok {
+			return o
+		}
+	}
+	panic(errors.NewTypeError(loop.vm, errors.ErrCodeInvalidArgType, "The %q property must be an instance of AbortSignal.", name))
+}
+
+// onAbort subscribes to the signal's 'abort' event. Returns a function that removes the listener.
+func (loop *EventLoop) onAbort(signal *goja.Object, fn func()) func() {
+	r := loop.vm
+	listener := r.ToValue(func(goja.FunctionCall) goja.Value {
+		fn()
+		return nil
+	})
+	opts := r.NewObject()
+	opts.Set("once", true)
+	add, _ := goja.AssertFunction(signal.Get("addEventListener"))
+	if _, err := add(signal, r.ToValue("abort"), listener, opts); err != nil {
+		panic(err)
+	}
+	return func() {
+		if remove, ok := goja.AssertFunction(signal.Get("removeEventListener")); ok {
+			_, _ = remove(signal, r.ToValue("abort"), listener)
+		}
+	}
+}
+
+func (loop *EventLoop) abortError(signal *goja.Object) *goja.Object {
+	return errors.NewAbortError(loop.vm, signal.Get("reason"))
+}
+
+// startTimeout schedules a native callback which runs like a JS callback (see runJob()).
+func (loop *EventLoop) startTimeout(fn func(), delay time.Duration, ref bool) *Timer {
+	t := loop.newTimeout(func() { loop.runJob(fn) }, delay)
+	loop.startTimer(&t.timer, ref)
+	return t
+}
+
+func (loop *EventLoop) startInterval(fn func(), delay time.Duration, ref bool) *Interval {
+	i := loop.newInterval(func() { loop.runJob(fn) }, delay)
+	loop.startTimer(&i.timer, ref)
+	return i
+}
+
+func (loop *EventLoop) startTimer(t *timer, ref bool) {
+	t.stack = loop.captureStack()
+	if ref {
+		loop.jobCount++
+	} else {
+		t.unref = true
+	}
+	loop.addTimer(t)
+}
+
+func toDelay(v goja.Value) time.Duration {
+	delay := v.ToInteger()
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// settleOnce wraps the promise's resolving functions so that only the first call has effect.
+func settleOnce(resolve, reject func(interface{}) error) (func(interface{}), func(interface{})) {
+	settled := false
+	return func(v interface{}) {
+			if !settled {
+				settled = true
+				_ = resolve(v)
+			}
+		}, func(v interface{}) {
+			if !settled {
+				settled = true
+				_ = reject(v)
+			}
+		}
+}
+
+func (loop *EventLoop) promiseSetTimeout(call goja.FunctionCall) goja.Value {
+	delay := toDelay(call.Argument(0))
+	value := call.Argument(1)
+	opts := loop.parseTimerOptions(call.Argument(2))
+	p, res, rej := loop.vm.NewPromise()
+	resolve, reject := settleOnce(res, rej)
+	if opts.signal != nil {
+		if opts.signal.Get("aborted").ToBoolean() {
+			reject(loop.abortError(opts.signal))
+			return loop.vm.ToValue(p)
+		}
+	}
+	var removeListener func()
+	t := loop.startTimeout(func() {
+		if removeListener != nil {
+			removeListener()
+		}
+		resolve(value)
+	}, delay, opts.ref)
+	if opts.signal != nil {
+		signal := opts.signal
+		removeListener = loop.onAbort(signal, func() {
+			loop.clearTimeout(t)
+			reject(loop.abortError(signal))
+		})
+	}
+	return loop.vm.ToValue(p)
+}
+
+func (loop *EventLoop) promiseSetImmediate(call goja.FunctionCall) goja.Value {
+	value := call.Argument(0)
+	opts := loop.parseTimerOptions(call.Argument(1))
+	p, res, rej := loop.vm.NewPromise()
+	resolve, reject := settleOnce(res, rej)
+	if opts.signal != nil {
+		if opts.signal.Get("aborted").ToBoolean() {
+			reject(loop.abortError(opts.signal))
+			return loop.vm.ToValue(p)
+		}
+	}
+	var removeListener func()
+	imm := loop.addImmediate(func() {
+		loop.runJob(func() {
+			if removeListener != nil {
+				removeListener()
+			}
+			resolve(value)
+		})
+	})
+	imm.stack = loop.captureStack()
+	if opts.ref {
+		loop.jobCount++
+	} else {
+		imm.unref = true
+	}
+	if opts.signal != nil {
+		signal := opts.signal
+		removeListener = loop.onAbort(signal, func() {
+			loop.clearImmediate(imm)
+			reject(loop.abortError(signal))
+		})
+	}
+	return loop.vm.ToValue(p)
+}
+
+type intervalWaiter struct {
+	resolve, reject func(interface{}) error
+}
+
+// intervalIterator is the async iterator returned by setInterval() from timers/promises.
+type intervalIterator struct {
+	loop     *EventLoop
+	interval *Interval
+	value    goja.Value
+	signal   *goja.Object
+
+	removeListener func()
+
+	// the number of ticks not consumed by next() yet
+	pending int
+	waiting []intervalWaiter
+	// set when the signal is aborted
+	abortErr *goja.Object
+	done     bool
+}
+
+func (loop *EventLoop) promiseSetInterval(call goja.FunctionCall) goja.Value {
+	r := loop.vm
+	delay := toDelay(call.Argument(0))
+	opts := loop.parseTimerOptions(call.Argument(2))
+	it := &intervalIterator{
+		loop:   loop,
+		value:  call.Argument(1),
+		signal: opts.signal,
+	}
+	if it.signal != nil && it.signal.Get("aborted").ToBoolean() {
+		it.abortErr = loop.abortError(it.signal)
+	} else {
+		it.interval = loop.startInterval(it.tick, delay, opts.ref)
+		if it.signal != nil {
+			it.removeListener = loop.onAbort(it.signal, it.abort)
+		}
+	}
+
+	o := r.NewObject()
+	o.Set("next", func(goja.FunctionCall) goja.Value {
+		return it.next()
+	})
+	o.Set("return", func(goja.FunctionCall) goja.Value {
+		return it.doReturn()
+	})
+	loop.setAsyncIterator(o)
+	return o
+}
+
+func (it *intervalIterator) result(value goja.Value, done bool) *goja.Object {
+	res := it.loop.vm.NewObject()
+	res.Set("value", value)
+	res.Set("done", done)
+	return res
+}
+
+func (it *intervalIterator) tick() {
+	if len(it.waiting) > 0 {
+		w := it.waiting[0]
+		it.waiting[0] = intervalWaiter{}
+		it.waiting = it.waiting[1:]
+		_ = w.resolve(it.result(it.value, false))
+	} else {
+		it.pending++
+	}
+}
+
+func (it *intervalIterator) next() goja.Value {
+	r := it.loop.vm
+	p, resolve, reject := r.NewPromise()
+	switch {
+	case it.abortErr != nil:
+		_ = reject(it.abortErr)
+	case it.done:
+		_ = resolve(it.result(goja.Undefined(), true))
+	case it.pending > 0:
+		it.pending--
+		_ = resolve(it.result(it.value, false))
+	default:
+		it.waiting = append(it.waiting, intervalWaiter{
+			resolve: resolve,
+			reject:  reject,
+		})
+	}
+	return r.ToValue(p)
+}
+
+func (it *intervalIterator) stop() {
+	if it.interval != nil {
+		it.loop.clearInterval(it.interval)
+		it.interval = nil
+	}
+	if it.removeListener != nil {
+		it.removeListener()
+		it.removeListener = nil
+	}
+}
+
+func (it *intervalIterator) doReturn() goja.Value {
+	it.stop()
+	it.done = true
+	done := it.result(goja.Undefined(), true)
+	for _, w := range it.waiting {
+		_ = w.resolve(done)
+	}
+	it.waiting = nil
+	p, resolve, _ := it.loop.vm.NewPromise()
+	_ = resolve(done)
+	return it.loop.vm.ToValue(p)
+}
+
+func (it *intervalIterator) abort() {
+	it.stop()
+	it.abortErr = it.loop.abortError(it.signal)
+	for _, w := range it.waiting {
+		_ = w.reject(it.abortErr)
+	}
+	it.waiting = nil
+}
+
+func init() {
+	require.RegisterCoreModule(TimersModuleName, requireTimers)
+	require.RegisterCoreModule(TimersPromisesModuleName, requireTimersPromises)
+}
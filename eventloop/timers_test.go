@@ -0,0 +1,198 @@
+package eventloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const testSignalScript = `
+function makeSignal() {
+	var listeners = [];
+	return {
+		aborted: false,
+		addEventListener: function(type, fn, opts) {
+			var self = this;
+			listeners.push(opts && opts.once ? function wrapper() {
+				self.removeEventListener(type, wrapper);
+				fn();
+			} : fn);
+		},
+		removeEventListener: function(type, fn) {
+			listeners = listeners.filter(function(f) {
+				return f !== fn;
+			});
+		},
+		abort: function(reason) {
+			this.aborted = true;
+			this.reason = reason;
+			listeners.slice().forEach(function(f) {
+				f();
+			});
+		},
+		listenerCount: function() {
+			return listeners.length;
+		}
+	};
+}
+`
+
+func runTimersScript(t *testing.T, script string) *goja.Runtime {
+	loop := NewEventLoop()
+	var scriptErr error
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		if _, err := vm.RunString(testSignalScript); err != nil {
+			t.Fatal(err)
+		}
+		_, scriptErr = vm.RunString(script)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scriptErr != nil {
+		t.Fatal(scriptErr)
+	}
+	var res goja.Value
+	loop.Run(func(vm *goja.Runtime) {
+		res = vm.Get("result")
+	})
+	if res == nil {
+		return loop.vm
+	}
+	if ex, ok := res.Export().(error); ok {
+		t.Fatal(ex)
+	}
+	return loop.vm
+}
+
+func TestTimersModule(t *testing.T) {
+	t.Parallel()
+	vm := runTimersScript(t, `
+	const timers = require("timers");
+	const promises = require("node:timers/promises");
+	var result = timers.setTimeout === setTimeout && timers.clearInterval === clearInterval &&
+		timers.promises === promises && require("node:timers") === timers;
+	`)
+	if res := vm.Get("result"); !res.ToBoolean() {
+		t.Fatal(res)
+	}
+}
+
+func TestTimersPromisesSetTimeout(t *testing.T) {
+	t.Parallel()
+	vm := runTimersScript(t, `
+	const { setTimeout: sleep, setImmediate: immediate } = require("timers/promises");
+	var result = [];
+	(async function() {
+		result.push(await sleep(10, "timeout"));
+		result.push(await immediate("immediate"));
+		const signal = makeSignal();
+		const p = sleep(10000, "never", { signal });
+		signal.abort("reason");
+		try {
+			await p;
+		} catch (e) {
+			result.push(e.name, e.code, e.cause, signal.listenerCount());
+		}
+		try {
+			await sleep(0, "never", { signal });
+		} catch (e) {
+			result.push(e.code);
+		}
+	})().catch(function(e) {
+		result = e;
+	});
+	`)
+	if res := vm.Get("result").String(); res != "timeout,immediate,AbortError,ABORT_ERR,reason,0,ABORT_ERR" {
+		t.Fatal(res)
+	}
+}
+
+func TestTimersPromisesSetInterval(t *testing.T) {
+	t.Parallel()
+	vm := runTimersScript(t, `
+	const { setInterval } = require("timers/promises");
+	var result = [];
+	(async function() {
+		const it = setInterval(1, "tick");
+		for (let i = 0; i < 3; i++) {
+			const { value, done } = await it.next();
+			result.push(value, done);
+		}
+		await it.return();
+		result.push((await it.next()).done);
+
+		const signal = makeSignal();
+		const it1 = setInterval(10000, "never", { signal });
+		const p = it1.next();
+		signal.abort();
+		try {
+			await p;
+		} catch (e) {
+			result.push(e.code);
+		}
+	})().catch(function(e) {
+		result = e;
+	});
+	`)
+	if res := vm.Get("result").String(); res != "tick,false,tick,false,tick,false,true,ABORT_ERR" {
+		t.Fatal(res)
+	}
+}
+
+func TestTimersPromisesSetIntervalAsyncIterator(t *testing.T) {
+	t.Parallel()
+	// the way a transpiled for-await loop iterates, without ChanToAsyncIterable() having been called before
+	vm := runTimersScript(t, `
+	const { setInterval } = require("timers/promises");
+	var result = [];
+	(async function() {
+		const iterable = setInterval(1, "tick");
+		const it = iterable[Symbol.asyncIterator]();
+		for (let i = 0; i < 2; i++) {
+			result.push((await it.next()).value);
+		}
+		await it.return();
+	})().catch(function(e) {
+		result = e;
+	});
+	`)
+	if res := vm.Get("result").String(); res != "tick,tick" {
+		t.Fatal(res)
+	}
+}
+
+func TestTimersPromisesUnref(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	vm := runTimersScript(t, `
+	var result = false;
+	require("timers/promises").setTimeout(10000, undefined, { ref: false }).then(function() {
+		result = true;
+	});
+	`)
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("the loop was kept alive")
+	}
+	if res := vm.Get("result"); res.ToBoolean() {
+		t.Fatal(res)
+	}
+}
+
+func TestTimersInvalidOptions(t *testing.T) {
+	t.Parallel()
+	runTimersScript(t, `
+	const { setTimeout } = require("timers/promises");
+	for (const opts of [1, { signal: {} }, { ref: 1 }]) {
+		try {
+			setTimeout(1, undefined, opts);
+			throw new Error("should have thrown");
+		} catch (e) {
+			if (e.code !== "ERR_INVALID_ARG_TYPE") {
+				throw e;
+			}
+		}
+	}
+	`)
+}
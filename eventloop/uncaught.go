@@ -0,0 +1,129 @@
+package eventloop
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/dop251/goja"
+)
+
+// PanicError is returned when the loop is stopped because a job has panicked (see RecoverPanics()).
+type PanicError struct {
+	// Value is the value passed to panic().
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in event loop job: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// WithErrorHandler sets a function that is called from the loop when a callback (a timer, an immediate, a
+// nextTick or a microtask callback) throws an exception and there are no 'uncaughtException' listeners on process.
+// The error is a *goja.Exception in this case, or an *UnhandledRejectionError (in UnhandledRejectionsThrow mode).
+// Once the handler has returned the loop continues running.
+// The handler is also called with the error that has caused the loop to stop (such as a *PanicError, see
+// RecoverPanics()).
+// Without a handler the exceptions thrown by callbacks are ignored.
+func WithErrorHandler(handler func(error)) Option {
+	return func(loop *EventLoop) {
+		loop.errorHandler = handler
+	}
+}
+
+// RecoverPanics controls whether the loop recovers from panics that occur while running jobs (for example in
+// a native Go function called from a setTimeout() callback). If enabled, a panic stops the loop (the same way as
+// StopNoWait()) and a *PanicError is returned from RunChecked(), RunContext() and StartInForegroundContext(). For
+// loops started by Run() or Start() the error is available via Err() once the loop has stopped.
+// By default, panics are not recovered, which means a panic in a loop started by Start() crashes the program.
+func RecoverPanics(recoverPanics bool) Option {
+	return func(loop *EventLoop) {
+		loop.recoverPanics = recoverPanics
+	}
+}
+
+// Err returns the error that caused the loop to stop the last time it was running, or nil if it was stopped
+// normally. It should be called after the loop has stopped (for example, after Stop() has returned).
+func (loop *EventLoop) Err() error {
+	loop.stopLock.Lock()
+	defer loop.stopLock.Unlock()
+	return loop.lastErr
+}
+
+// exec runs a job followed by the nextTick and the promise job queues.
+func (loop *EventLoop) exec(info JobInfo, fn func()) {
+	if loop.recoverPanics {
+		defer loop.recoverPanic()
+	}
+	start := loop.jobStarted(info)
+	if loop.jobTimeout > 0 {
+		loop.startWatchdog()
+		defer loop.finishWatchdog(info.Kind)
+	}
+	fn()
+	loop.afterJob()
+	// a job that has called AsyncLocalStorage.prototype.enterWith() must not leak the store to the next one
+	loop.frame = nil
+	loop.jobFinished(info, start)
+}
+
+func (loop *EventLoop) recoverPanic() {
+	if x := recover(); x != nil {
+		loop.fail(&PanicError{
+			Value: x,
+			Stack: debug.Stack(),
+		})
+	}
+}
+
+// handleException is called with the error returned by a JS callback.
+func (loop *EventLoop) handleException(err error) {
+	switch err := err.(type) {
+	case *goja.Exception:
+		loop.uncaught(err, err.Value(), "uncaughtException")
+	case *goja.InterruptedError:
+		// The runtime was interrupted on purpose (for example, because the loop's context was cancelled),
+		// whoever did it is responsible for handling it.
+		loop.jobInterrupted(err)
+	default:
+		loop.fail(err)
+	}
+}
+
+// uncaught emits the 'uncaughtException' process event. If there are no listeners, the error is passed to the
+// error handler, if set. Otherwise, unhandled rejections stop the loop, while exceptions are ignored.
+func (loop *EventLoop) uncaught(err error, value goja.Value, origin string) {
+	if loop.process.ListenerCount("uncaughtException") > 0 {
+		if _, err := loop.process.Emit("uncaughtException", value, loop.vm.ToValue(origin)); err != nil {
+			// an exception in an uncaughtException listener is fatal
+			loop.fail(err)
+		}
+		return
+	}
+	if loop.errorHandler != nil {
+		loop.errorHandler(err)
+		return
+	}
+	if origin == "unhandledRejection" {
+		loop.fail(err)
+	}
+}
+
+// fail stops the loop with the specified error. Only the first error is kept.
+func (loop *EventLoop) fail(err error) {
+	if loop.err == nil {
+		loop.err = err
+		if loop.errorHandler != nil {
+			loop.errorHandler(err)
+		}
+	}
+	loop.StopNoWait()
+}
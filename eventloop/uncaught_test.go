@@ -0,0 +1,147 @@
+package eventloop
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestUncaughtExceptionListener(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	const process = require("process");
+	var log = [];
+	process.on("uncaughtException", function(e, origin) {
+		log.push(origin + ": " + e.message);
+	});
+	setTimeout(function() {
+		throw new Error("timeout");
+	});
+	setTimeout(function() {
+		log.push("next");
+		Promise.reject(new Error("rejection"));
+	}, 1);
+	`
+	loop := NewEventLoop(WithUnhandledRejectionMode(UnhandledRejectionsThrow))
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(SCRIPT)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log string
+	loop.Run(func(vm *goja.Runtime) {
+		log = vm.Get("log").String()
+	})
+	if log != "uncaughtException: timeout,next,unhandledRejection: rejection" {
+		t.Fatal(log)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	t.Parallel()
+	var messages []string
+	loop := NewEventLoop(WithErrorHandler(func(err error) {
+		var ex *goja.Exception
+		if !errors.As(err, &ex) {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		messages = append(messages, ex.Value().String())
+	}))
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		setTimeout(function() {
+			setTimeout(function() {
+				throw "timeout";
+			});
+			setImmediate(function() {
+				throw "immediate";
+			});
+			queueMicrotask(function() {
+				throw "microtask";
+			});
+			require("process").nextTick(function() {
+				throw "tick";
+			});
+		});
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 || messages[0] != "tick" || messages[1] != "microtask" || messages[2] != "immediate" ||
+		messages[3] != "timeout" {
+		t.Fatal(messages)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(RecoverPanics(true))
+	loop.Run(func(vm *goja.Runtime) {
+		vm.Set("fail", func() {
+			panic("boom")
+		})
+		_, err := vm.RunString(`
+		setTimeout(fail);
+		setTimeout(function() {
+			throw new Error("should not run");
+		}, 10);
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	err := loop.Err()
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatal(panicErr)
+	}
+	// the runtime must still be usable
+	err = loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		var res;
+		Promise.resolve(1).then(function(v) {
+			res = v;
+		});
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm.Set("fail", func() {})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverPanicsInBackground(t *testing.T) {
+	t.Parallel()
+	errCh := make(chan error, 1)
+	loop := NewEventLoop(RecoverPanics(true), WithErrorHandler(func(err error) {
+		errCh <- err
+	}))
+	loop.Start()
+	loop.RunOnLoop(func(*goja.Runtime) {
+		panic(errors.New("boom"))
+	})
+	err := <-errCh
+	loop.Stop()
+	if err != loop.Err() {
+		t.Fatalf("%v != %v", err, loop.Err())
+	}
+	if err.Error() != "panic in event loop job: boom" {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,170 @@
+package eventloop
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// JobKind describes the origin of a job run by the loop.
+type JobKind string
+
+const (
+	JobKindTimeout   JobKind = "timeout"   // setTimeout() or SetTimeout()
+	JobKindInterval  JobKind = "interval"  // setInterval() or SetInterval()
+	JobKindImmediate JobKind = "immediate" // setImmediate()
+	JobKindTask      JobKind = "task"      // RunOnLoop() and other jobs submitted from Go
+)
+
+// JobTimeoutPolicy defines what happens when a job exceeds the time set by WithJobTimeout().
+type JobTimeoutPolicy int
+
+const (
+	// JobTimeoutContinue passes a *JobTimeoutError to the error handler (see WithErrorHandler()) and
+	// continues running the loop. This is the default.
+	JobTimeoutContinue JobTimeoutPolicy = iota
+	// JobTimeoutTerminate stops the loop and clears all active timers, the *JobTimeoutError is returned by
+	// RunChecked() (see also Err()).
+	JobTimeoutTerminate
+)
+
+// JobTimeoutError is reported when a job exceeds the time set by WithJobTimeout().
+type JobTimeoutError struct {
+	// Kind is the kind of the job that has overrun.
+	Kind JobKind
+	// Location is the source position at which the script was interrupted (e.g. "main.js:3:5"). It is empty
+	// if the job was not running JS code at the time (or the code was run from a native Go function which
+	// has received the interrupt as an error).
+	Location string
+	// Timeout is the configured limit.
+	Timeout time.Duration
+}
+
+func (e *JobTimeoutError) Error() string {
+	msg := fmt.Sprintf("%s job exceeded the time limit of %v", e.Kind, e.Timeout)
+	if e.Location != "" {
+		msg += " at " + e.Location
+	}
+	return msg
+}
+
+// WithJobTimeout limits the time a single job (a timer or an interval callback, an immediate or a function
+// passed to RunOnLoop(), including the nextTick callbacks and the promise jobs it queues) may run for. When the
+// limit is exceeded the runtime is interrupted (see goja.Runtime.Interrupt()) and a *JobTimeoutError is reported
+// according to the policy (see WithJobTimeoutPolicy()).
+// Note that native Go code cannot be interrupted, so if a job is blocked in Go, the timeout is only reported
+// once it returns.
+// The limit is measured using the system clock regardless of WithClock().
+func WithJobTimeout(timeout time.Duration) Option {
+	return func(loop *EventLoop) {
+		loop.jobTimeout = timeout
+	}
+}
+
+// WithJobTimeoutPolicy sets the action taken when a job exceeds the time set by WithJobTimeout().
+func WithJobTimeoutPolicy(policy JobTimeoutPolicy) Option {
+	return func(loop *EventLoop) {
+		loop.jobTimeoutPolicy = policy
+	}
+}
+
+type watchdog struct {
+	sync.Mutex
+	loop    *EventLoop
+	timer   *time.Timer
+	running bool
+	fired   bool
+	start   time.Time
+
+	// set from the loop
+	location string
+}
+
+func (w *watchdog) startJob() {
+	w.Lock()
+	w.running = true
+	w.fired = false
+	w.start = time.Now()
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.loop.jobTimeout, w.expire)
+	} else {
+		w.timer.Reset(w.loop.jobTimeout)
+	}
+	w.Unlock()
+}
+
+// finishJob stops the timer and returns true if the job has been interrupted.
+func (w *watchdog) finishJob() bool {
+	w.Lock()
+	w.running = false
+	w.timer.Stop()
+	fired := w.fired
+	w.fired = false
+	w.Unlock()
+	return fired
+}
+
+func (w *watchdog) expire() {
+	w.Lock()
+	// the timer may fire late, after the job has finished or when a different one is running
+	if w.running && !w.fired && time.Since(w.start) >= w.loop.jobTimeout {
+		w.fired = true
+		w.loop.vm.Interrupt(errJobTimeout)
+	}
+	w.Unlock()
+}
+
+var errJobTimeout = errors.New("job timeout")
+
+// startWatchdog starts measuring the time of a job. It must be followed by finishWatchdog().
+func (loop *EventLoop) startWatchdog() {
+	if loop.watchdog == nil {
+		loop.watchdog = &watchdog{loop: loop}
+	}
+	loop.watchdog.location = ""
+	loop.watchdog.startJob()
+}
+
+func (loop *EventLoop) finishWatchdog(kind JobKind) {
+	w := loop.watchdog
+	if !w.finishJob() {
+		return
+	}
+	// the interrupt may not have been delivered if the job was not running any JS code
+	loop.vm.ClearInterrupt()
+	err := &JobTimeoutError{
+		Kind:     kind,
+		Location: w.location,
+		Timeout:  loop.jobTimeout,
+	}
+	if loop.jobTimeoutPolicy == JobTimeoutTerminate {
+		loop.fail(err)
+		loop.clearOnStop = true
+		return
+	}
+	if loop.errorHandler != nil {
+		loop.errorHandler(err)
+	}
+}
+
+// jobInterrupted is called when a JS callback has returned an InterruptedError. If it was caused by the
+// watchdog, it records the location and discards the remaining nextTick callbacks (goja discards the promise
+// jobs in this case as well).
+func (loop *EventLoop) jobInterrupted(err *goja.InterruptedError) {
+	if err.Value() != errJobTimeout || loop.watchdog == nil {
+		return
+	}
+	loop.ticks = nil
+	if loop.watchdog.location != "" {
+		return
+	}
+	for _, frame := range err.Stack() {
+		if frame.SrcName() != "" && frame.SrcName() != helpersPrgName {
+			loop.watchdog.location = frame.Position().String()
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package eventloop
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestJobTimeoutContinue(t *testing.T) {
+	t.Parallel()
+	const SCRIPT = `
+	var done = false;
+	setTimeout(function() {
+		Promise.resolve().then(function() {
+			done = "promise";
+		});
+		var n = 0;
+		for (;;) {
+			n++;
+		}
+	});
+	setTimeout(function() {
+		done = true;
+	}, 10);
+	`
+	var errs []error
+	loop := NewEventLoop(WithJobTimeout(50*time.Millisecond), WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	prg, err := goja.Compile("main.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunProgram(prg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Fatal(errs)
+	}
+	var timeoutErr *JobTimeoutError
+	if !errors.As(errs[0], &timeoutErr) {
+		t.Fatal(errs[0])
+	}
+	if timeoutErr.Kind != JobKindTimeout || !strings.HasPrefix(timeoutErr.Location, "main.js:9:") {
+		t.Fatal(timeoutErr)
+	}
+	loop.Run(func(vm *goja.Runtime) {
+		if done := vm.Get("done"); !done.StrictEquals(vm.ToValue(true)) {
+			t.Fatal(done)
+		}
+	})
+}
+
+func TestJobTimeoutTerminate(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(WithJobTimeout(50*time.Millisecond), WithJobTimeoutPolicy(JobTimeoutTerminate))
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		var count = 0;
+		setInterval(function() {
+			count++;
+			for (;;) {}
+		}, 1);
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	var timeoutErr *JobTimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Kind != JobKindInterval {
+		t.Fatal(err)
+	}
+	err = loop.RunChecked(func(vm *goja.Runtime) {
+		if count := vm.Get("count").ToInteger(); count != 1 {
+			t.Fatal(count)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobTimeoutTask(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop(WithJobTimeout(50*time.Millisecond), WithJobTimeoutPolicy(JobTimeoutTerminate))
+	loop.Start()
+	ch := make(chan error, 1)
+	loop.RunOnLoop(func(vm *goja.Runtime) {
+		_, err := vm.RunString("for (;;) {}")
+		ch <- err
+	})
+	err := <-ch
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	loop.Stop()
+	var timeoutErr *JobTimeoutError
+	if !errors.As(loop.Err(), &timeoutErr) || timeoutErr.Kind != JobKindTask {
+		t.Fatal(loop.Err())
+	}
+}
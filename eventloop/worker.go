@@ -0,0 +1,443 @@
+package eventloop
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+	"github.com/dop251/goja_nodejs/goutil"
+	"github.com/dop251/goja_nodejs/process"
+	"github.com/dop251/goja_nodejs/require"
+	"github.com/dop251/goja_nodejs/structuredclone"
+)
+
+const WorkerThreadsModuleName = "worker_threads"
+
+// the last assigned threadId, the main thread (i.e. any loop that is not a worker) is 0
+var lastThreadId int64
+
+type workerTerminated struct{}
+
+func (workerTerminated) Error() string {
+	return "the worker has been terminated"
+}
+
+var errWorkerTerminated workerTerminated
+
+// worker connects a Worker object in the parent loop with the loop that runs the worker script in its own
+// goroutine. The fields in the first group are only accessed from the parent loop, the ones in the second group
+// only from the worker loop.
+type worker struct {
+	parent, loop *EventLoop
+	threadId     int64
+	workerData   *structuredclone.Data
+
+	obj      *goja.Object
+	emitter  process.Emitter
+	job      job
+	exited   bool
+	exitCode int
+	// the resolving functions of the promises returned by terminate()
+	onExit []func(interface{}) bool
+
+	port        *goja.Object
+	portEmitter process.Emitter
+	// keeps the worker loop alive while the port has 'message' listeners and has not been closed
+	portJob    *job
+	portUnref  bool
+	portClosed bool
+	// the worker has failed because of an uncaught exception
+	failed bool
+
+	mu         sync.Mutex
+	terminated bool
+}
+
+func requireWorkerThreads(runtime *goja.Runtime, module *goja.Object) {
+	loop := getLoop(runtime)
+	o := module.Get("exports").(*goja.Object)
+	o.Set("Worker", loop.createWorker())
+	for name, ctor := range loop.messaging() {
+		o.Set(name, ctor)
+	}
+	w := loop.worker
+	o.Set("isMainThread", w == nil)
+	if w == nil {
+		o.Set("threadId", 0)
+		o.Set("parentPort", goja.Null())
+		o.Set("workerData", goja.Undefined())
+		return
+	}
+	o.Set("threadId", w.threadId)
+	o.Set("parentPort", w.createParentPort())
+	if w.workerData != nil {
+		o.Set("workerData", w.workerData.Deserialize(runtime))
+		w.workerData = nil
+	} else {
+		o.Set("workerData", goja.Undefined())
+	}
+}
+
+func (loop *EventLoop) createWorker() *goja.Object {
+	r := loop.vm
+	ctor, proto := goutil.NewClass(r, "Worker", func(call goja.ConstructorCall) {
+		loop.newWorker(call)
+	})
+	loop.defineEmitterMethods(proto)
+
+	toWorker := func(v goja.Value) *worker {
+		if o, ok := v.(*goja.Object); ok {
+			if v := o.GetSymbol(symWorker); v != nil {
+				if w, ok := v.Export().(*worker); ok {
+					return w
+				}
+			}
+		}
+		panic(errors.NewTypeError(r, errors.ErrCodeInvalidThis, `Value of "this" must be of type Worker`))
+	}
+
+	proto.Set("postMessage", func(call goja.FunctionCall) goja.Value {
+		w := toWorker(call.This)
+		data := loop.serializeMessage(call.Argument(0), call.Argument(1))
+		if !w.exited {
+			w.loop.addAuxJob(func() {
+				w.receive(data)
+			})
+		}
+		return goja.Undefined()
+	})
+	proto.Set("terminate", func(call goja.FunctionCall) goja.Value {
+		w := toWorker(call.This)
+		p, resolve, _ := loop.NewPromise()
+		if w.exited {
+			resolve(w.exitCode)
+		} else {
+			w.onExit = append(w.onExit, resolve)
+			w.stop()
+		}
+		return r.ToValue(p)
+	})
+	proto.Set("ref", func(call goja.FunctionCall) goja.Value {
+		loop.ref(&toWorker(call.This).job)
+		return goja.Undefined()
+	})
+	proto.Set("unref", func(call goja.FunctionCall) goja.Value {
+		loop.unref(&toWorker(call.This).job)
+		return goja.Undefined()
+	})
+	return ctor
+}
+
+var symWorker = goja.NewSymbol("worker")
+
+// newWorker starts a Worker. The worker loop shares the registry (and therefore the source loader and the
+// native modules) with the parent loop.
+func (loop *EventLoop) newWorker(call goja.ConstructorCall) {
+	r := loop.vm
+	filename, ok := call.Argument(0).Export().(string)
+	if !ok {
+		panic(errors.NewArgumentNotStringTypeError(r, "filename"))
+	}
+	var eval bool
+	var workerData *structuredclone.Data
+	if opts, ok := call.Argument(1).(*goja.Object); ok {
+		if v := opts.Get("eval"); v != nil {
+			eval = v.ToBoolean()
+		}
+		if v := opts.Get("workerData"); v != nil {
+			workerData = loop.serializeMessage(v, opts.Get("transferList"))
+		}
+	}
+	if !eval && !strings.HasPrefix(filename, "/") && !strings.HasPrefix(filename, "./") &&
+		!strings.HasPrefix(filename, "../") {
+		panic(errors.NewTypeError(r, errors.ErrCodeWorkerPath,
+			"The worker script or module filename must be an absolute path or a relative path starting with './' or '../'. Received %q", filename))
+	}
+
+	w := &worker{
+		parent:     loop,
+		threadId:   atomic.AddInt64(&lastThreadId, 1),
+		workerData: workerData,
+		obj:        call.This,
+	}
+	w.loop = NewEventLoop(WithRegistry(loop.registry), EnableConsole(loop.enableConsole), WithClock(loop.clock),
+		WithErrorHandler(w.uncaught))
+	w.loop.worker = w
+	loop.initEmitter(call.This, &w.emitter)
+	call.This.DefineDataPropertySymbol(symWorker, r.ToValue(w), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	call.This.DefineDataProperty("threadId", r.ToValue(w.threadId), goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+
+	loop.jobCount++
+	if loop.pending == nil {
+		loop.pending = make(map[*job]struct{})
+	}
+	loop.pending[&w.job] = struct{}{}
+	if loop.workers == nil {
+		loop.workers = make(map[*worker]struct{})
+	}
+	loop.workers[w] = struct{}{}
+
+	go w.run(filename, eval)
+}
+
+// serializeMessage clones the value for postMessage(). The transfer list is either an array or an object with the
+// 'transfer' property.
+func (loop *EventLoop) serializeMessage(v, transferList goja.Value) *structuredclone.Data {
+	r := loop.vm
+	var transfer []goja.Value
+	if o, ok := transferList.(*goja.Object); ok {
+		if o.ClassName() != "Array" {
+			if t := o.Get("transfer"); t != nil {
+				transferList = t
+			}
+		}
+		if !goja.IsUndefined(transferList) {
+			if err := r.ExportTo(transferList, &transfer); err != nil {
+				panic(errors.NewNotCorrectTypeError(r, "transferList", "Array"))
+			}
+		}
+	}
+	data, err := structuredclone.Serialize(r, v, transfer)
+	if err != nil {
+		panic(structuredclone.ErrorValue(r, err))
+	}
+	return data
+}
+
+// run runs the worker loop until it has no more jobs or is stopped, then reports the exit to the parent.
+func (w *worker) run(filename string, eval bool) {
+	wl := w.loop
+	wl.Run(func(vm *goja.Runtime) {
+		w.mu.Lock()
+		terminated := w.terminated
+		w.mu.Unlock()
+		if terminated {
+			wl.StopNoWait()
+			return
+		}
+		w.post(func() {
+			w.emit("online")
+		})
+		var err error
+		if eval {
+			_, err = vm.RunString(filename)
+		} else {
+			req, _ := goja.AssertFunction(vm.Get("require"))
+			_, err = req(nil, vm.ToValue(filename))
+		}
+		if err != nil {
+			wl.handleException(err)
+		}
+	})
+	wl.Terminate()
+
+	code := 0
+	w.mu.Lock()
+	if w.terminated || w.failed {
+		code = 1
+	}
+	w.mu.Unlock()
+	w.post(func() {
+		w.exit(code)
+	})
+}
+
+// stop terminates the worker. It can be called from any goroutine.
+func (w *worker) stop() {
+	w.mu.Lock()
+	w.terminated = true
+	w.mu.Unlock()
+	w.loop.vm.Interrupt(errWorkerTerminated)
+	w.loop.StopNoWait()
+}
+
+// post schedules a function to run on the parent loop.
+func (w *worker) post(fn func()) {
+	w.parent.addAuxJob(func() {
+		w.parent.runJob(fn)
+	})
+}
+
+// emit emits an event on the Worker object in the parent loop.
+func (w *worker) emit(event string, args ...goja.Value) bool {
+	emitted, err := w.emitter.Emit(w.obj, event, args...)
+	if err != nil {
+		w.parent.handleException(err)
+	}
+	return emitted
+}
+
+// exit is called on the parent loop once the worker loop has stopped.
+func (w *worker) exit(code int) {
+	loop := w.parent
+	if w.exited {
+		return
+	}
+	w.exited = true
+	w.exitCode = code
+	delete(loop.workers, w)
+	if !w.job.cancelled {
+		loop.finishJob(&w.job)
+		delete(loop.pending, &w.job)
+	}
+	for _, resolve := range w.onExit {
+		resolve(code)
+	}
+	w.onExit = nil
+	w.emit("exit", loop.vm.ToValue(code))
+}
+
+// uncaught is the error handler of the worker loop. The error is reported as an 'error' event on the Worker and
+// the worker is stopped.
+func (w *worker) uncaught(err error) {
+	wl := w.loop
+	if _, ok := err.(*goja.InterruptedError); ok {
+		return
+	}
+	var v goja.Value
+	if ex, ok := err.(*goja.Exception); ok {
+		v = ex.Value()
+	} else {
+		v = wl.vm.NewGoError(err)
+	}
+	data, serr := structuredclone.Serialize(wl.vm, v, nil)
+	if serr != nil {
+		data, _ = structuredclone.Serialize(wl.vm, errors.NewError(wl.vm, nil, errors.ErrCodeWorkerUnserializableError,
+			"Serializing an uncaught exception failed"), nil)
+	}
+	w.mu.Lock()
+	w.failed = true
+	w.mu.Unlock()
+	w.post(func() {
+		if w.exited {
+			return
+		}
+		loop := w.parent
+		v := data.Deserialize(loop.vm)
+		if !w.emit("error", v) {
+			loop.handleException(loop.exception(v))
+		}
+	})
+	wl.StopNoWait()
+}
+
+// exception converts a value into a *goja.Exception, as if it was thrown by a callback.
+func (loop *EventLoop) exception(v goja.Value) error {
+	thrower, _ := goja.AssertFunction(loop.vm.ToValue(func(call goja.FunctionCall) goja.Value {
+		panic(call.Argument(0))
+	}))
+	_, err := thrower(nil, v)
+	return err
+}
+
+// receive delivers a message from the parent, it's called on the worker loop.
+func (w *worker) receive(data *structuredclone.Data) {
+	wl := w.loop
+	if w.port == nil || w.portClosed {
+		return
+	}
+	wl.runJob(func() {
+		if _, err := w.portEmitter.Emit(w.port, "message", data.Deserialize(wl.vm)); err != nil {
+			wl.handleException(err)
+		}
+	})
+}
+
+// createParentPort creates the parentPort object in the worker runtime.
+func (w *worker) createParentPort() *goja.Object {
+	wl := w.loop
+	r := wl.vm
+	port := r.NewObject()
+	proto := r.NewObject()
+	wl.defineEmitterMethods(proto)
+	port.SetPrototype(proto)
+	w.portEmitter.Changed = func(event string) {
+		if event == "message" {
+			w.updatePortRef()
+		}
+	}
+	wl.initEmitter(port, &w.portEmitter)
+
+	proto.Set("postMessage", func(call goja.FunctionCall) goja.Value {
+		if w.portClosed {
+			return goja.Undefined()
+		}
+		data := wl.serializeMessage(call.Argument(0), call.Argument(1))
+		w.post(func() {
+			if !w.exited {
+				w.emit("message", data.Deserialize(w.parent.vm))
+			}
+		})
+		return goja.Undefined()
+	})
+	proto.Set("close", func(call goja.FunctionCall) goja.Value {
+		if !w.portClosed {
+			w.portClosed = true
+			w.updatePortRef()
+			wl.addImmediate(func() {
+				wl.runJob(func() {
+					if _, err := w.portEmitter.Emit(port, "close"); err != nil {
+						wl.handleException(err)
+					}
+				})
+			})
+		}
+		return goja.Undefined()
+	})
+	proto.Set("start", func(call goja.FunctionCall) goja.Value {
+		return goja.Undefined()
+	})
+	proto.Set("ref", func(call goja.FunctionCall) goja.Value {
+		w.portUnref = false
+		if w.portJob != nil {
+			wl.ref(w.portJob)
+		}
+		return goja.Undefined()
+	})
+	proto.Set("unref", func(call goja.FunctionCall) goja.Value {
+		w.portUnref = true
+		if w.portJob != nil {
+			wl.unref(w.portJob)
+		}
+		return goja.Undefined()
+	})
+	w.port = port
+	return port
+}
+
+// updatePortRef makes parentPort keep the worker loop alive while it has 'message' listeners.
+func (w *worker) updatePortRef() {
+	wl := w.loop
+	active := !w.portClosed && w.portEmitter.ListenerCount("message") > 0
+	if active && w.portJob == nil {
+		w.portJob = &job{}
+		wl.jobCount++
+		if wl.pending == nil {
+			wl.pending = make(map[*job]struct{})
+		}
+		wl.pending[w.portJob] = struct{}{}
+		if w.portUnref {
+			wl.unref(w.portJob)
+		}
+	} else if !active && w.portJob != nil {
+		if !w.portJob.cancelled {
+			wl.finishJob(w.portJob)
+			delete(wl.pending, w.portJob)
+		}
+		w.portJob = nil
+	}
+}
+
+// stopWorkers terminates all workers started by the loop, it's called by Terminate().
+func (loop *EventLoop) stopWorkers() {
+	for w := range loop.workers {
+		w.stop()
+	}
+	loop.workers = nil
+}
+
+func init() {
+	require.RegisterCoreModule(WorkerThreadsModuleName, requireWorkerThreads)
+}
@@ -0,0 +1,154 @@
+package eventloop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+// runUntilDone runs the script until the loop stops and returns the value passed to done().
+func runUntilDone(t *testing.T, script string) string {
+	t.Helper()
+	loop := NewEventLoop()
+	var res goja.Value
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		vm.Set("done", func(v goja.Value) {
+			res = v
+		})
+		if _, err := vm.RunString(script); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil {
+		t.Fatal("done() has not been called")
+	}
+	return res.String()
+}
+
+func TestWorkerMessages(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "worker.js"), []byte(`
+	const { parentPort, workerData, isMainThread, threadId } = require("node:worker_threads");
+	parentPort.postMessage({ isMainThread, threadId, workerData });
+	parentPort.on("message", msg => {
+		if (msg === "close") {
+			parentPort.close();
+			return;
+		}
+		msg.buf = new Uint8Array(msg.buf).map(x => x * 2).buffer;
+		parentPort.postMessage(msg, [msg.buf]);
+	});
+	`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := runUntilDone(t, `
+	const { Worker, isMainThread, threadId, parentPort } = require("worker_threads");
+	const log = [isMainThread, threadId, parentPort];
+	const w = new Worker(`+"`"+dir+"/worker.js`"+`, { workerData: { m: new Map([[1, 2]]) } });
+	w.on("online", () => log.push("online"));
+	w.once("message", msg => {
+		log.push(msg.isMainThread, msg.threadId === w.threadId, msg.workerData.m.get(1));
+		const buf = new Uint8Array([1, 2, 3]).buffer;
+		w.postMessage({ buf }, [buf]);
+		log.push(buf.byteLength);
+		w.once("message", msg => {
+			log.push(new Uint8Array(msg.buf).join(""));
+			w.postMessage("close");
+		});
+	});
+	w.on("exit", code => {
+		log.push("exit:" + code);
+		done(log.join());
+	});
+	`)
+	if s != "true,0,,online,false,true,2,0,246,exit:0" {
+		t.Fatal(s)
+	}
+}
+
+func TestWorkerTerminate(t *testing.T) {
+	t.Parallel()
+	s := runUntilDone(t, `
+	const { Worker } = require("worker_threads");
+	const w = new Worker("require('worker_threads').parentPort.postMessage('started'); for (;;) {}", { eval: true });
+	const log = [];
+	w.on("exit", code => log.push("exit:" + code));
+	w.on("message", msg => {
+		log.push(msg);
+		w.terminate().then(code => {
+			log.push("terminated:" + code);
+			done(log.join());
+		});
+	});
+	`)
+	if s != "started,exit:1,terminated:1" {
+		t.Fatal(s)
+	}
+}
+
+func TestWorkerError(t *testing.T) {
+	t.Parallel()
+	s := runUntilDone(t, `
+	const { Worker } = require("worker_threads");
+	const w = new Worker("setTimeout(() => { throw new RangeError('boom'); }, 1)", { eval: true });
+	const log = [];
+	w.on("error", e => log.push(e instanceof RangeError, e.message));
+	w.on("exit", code => {
+		log.push(code);
+		done(log.join());
+	});
+	`)
+	if s != "true,boom,1" {
+		t.Fatal(s)
+	}
+
+	s = runUntilDone(t, `
+	const { Worker } = require("worker_threads");
+	const log = [];
+	try {
+		new Worker("worker.js");
+	} catch (e) {
+		log.push(e.code);
+	}
+	try {
+		new Worker("./worker.js", { workerData: () => {} });
+	} catch (e) {
+		log.push(e.name);
+	}
+	done(log.join());
+	`)
+	if s != "ERR_WORKER_PATH,DataCloneError" {
+		t.Fatal(s)
+	}
+}
+
+func TestWorkerUnref(t *testing.T) {
+	t.Parallel()
+	loop := NewEventLoop()
+	err := loop.RunChecked(func(vm *goja.Runtime) {
+		_, err := vm.RunString(`
+		const { Worker } = require("worker_threads");
+		new Worker("setInterval(() => {}, 1000)", { eval: true }).unref();
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loop.workers); n != 1 {
+		t.Fatal(n)
+	}
+	loop.Terminate()
+	if loop.workers != nil {
+		t.Fatal("workers have not been stopped")
+	}
+}
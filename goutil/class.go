@@ -0,0 +1,16 @@
+package goutil
+
+import (
+	"github.com/dop251/goja"
+)
+
+// NewClass creates a native constructor with the specified name and returns it along with its prototype. ctor is
+// called with the new object as call.This.
+func NewClass(r *goja.Runtime, name string, ctor func(call goja.ConstructorCall)) (*goja.Object, *goja.Object) {
+	c := r.ToValue(func(call goja.ConstructorCall) *goja.Object {
+		ctor(call)
+		return nil
+	}).(*goja.Object)
+	c.DefineDataProperty("name", r.ToValue(name), goja.FLAG_FALSE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	return c, c.Get("prototype").(*goja.Object)
+}
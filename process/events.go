@@ -0,0 +1,190 @@
+package process
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+)
+
+// Emitter implements the subset of the EventEmitter methods that is supported by the process object. It can also
+// be used by other modules for their objects that emit events (see DefineEmitterMethods()).
+// Must only be used from the goroutine that runs the runtime.
+type Emitter struct {
+	// Changed is called (if set) after a listener of the event has been added or removed.
+	Changed func(event string)
+
+	listeners map[string][]*listener
+}
+
+type listener struct {
+	fn   goja.Value
+	call goja.Callable
+	once bool
+}
+
+// DefineEmitterMethods defines the EventEmitter methods (on(), once(), off(), emit() etc.) on the object, which is
+// normally a prototype. get returns the Emitter for the 'this' value of a call, it should throw a TypeError if
+// the value is not an object it has been attached to.
+func DefineEmitterMethods(r *goja.Runtime, o *goja.Object, get func(this goja.Value) *Emitter) {
+	addListener := func(once bool) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			e := get(call.This)
+			e.addListener(call.Argument(0).String(), listenerArg(r, call.Argument(1)), once)
+			return call.This
+		}
+	}
+	on := addListener(false)
+	o.Set("on", on)
+	o.Set("addListener", on)
+	o.Set("once", addListener(true))
+	off := func(call goja.FunctionCall) goja.Value {
+		e := get(call.This)
+		e.removeListener(call.Argument(0).String(), listenerArg(r, call.Argument(1)))
+		return call.This
+	}
+	o.Set("off", off)
+	o.Set("removeListener", off)
+	o.Set("removeAllListeners", func(call goja.FunctionCall) goja.Value {
+		e := get(call.This)
+		if event := call.Argument(0); goja.IsUndefined(event) {
+			e.RemoveAllListeners()
+		} else if _, exists := e.listeners[event.String()]; exists {
+			delete(e.listeners, event.String())
+			e.notify(event.String())
+		}
+		return call.This
+	})
+	o.Set("emit", func(call goja.FunctionCall) goja.Value {
+		e := get(call.This)
+		var args []goja.Value
+		if len(call.Arguments) > 1 {
+			args = call.Arguments[1:]
+		}
+		emitted, err := e.Emit(call.This, call.Argument(0).String(), args...)
+		if err != nil {
+			panic(err)
+		}
+		return r.ToValue(emitted)
+	})
+	o.Set("listenerCount", func(call goja.FunctionCall) goja.Value {
+		return r.ToValue(get(call.This).ListenerCount(call.Argument(0).String()))
+	})
+	o.Set("listeners", func(call goja.FunctionCall) goja.Value {
+		list := get(call.This).listeners[call.Argument(0).String()]
+		fns := make([]interface{}, len(list))
+		for i, l := range list {
+			fns[i] = l.fn
+		}
+		return r.NewArray(fns...)
+	})
+}
+
+// initEvents adds the EventEmitter methods that are used to subscribe to the process events (such as
+// 'unhandledRejection').
+func (p *Process) initEvents(o *goja.Object) {
+	DefineEmitterMethods(p.runtime, o, func(goja.Value) *Emitter {
+		return &p.events
+	})
+}
+
+func listenerArg(r *goja.Runtime, v goja.Value) goja.Value {
+	if _, ok := goja.AssertFunction(v); !ok {
+		panic(errors.NewNotCorrectTypeError(r, "listener", "function"))
+	}
+	return v
+}
+
+func (e *Emitter) notify(event string) {
+	if e.Changed != nil {
+		e.Changed(event)
+	}
+}
+
+func (e *Emitter) addListener(event string, fn goja.Value, once bool) {
+	callable, _ := goja.AssertFunction(fn)
+	if e.listeners == nil {
+		e.listeners = make(map[string][]*listener)
+	}
+	e.listeners[event] = append(e.listeners[event], &listener{
+		fn:   fn,
+		call: callable,
+		once: once,
+	})
+	e.notify(event)
+}
+
+func (e *Emitter) removeListener(event string, fn goja.Value) {
+	list := e.listeners[event]
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].fn.SameAs(fn) {
+			e.removeListenerAt(event, i)
+			return
+		}
+	}
+}
+
+func (e *Emitter) removeListenerAt(event string, i int) {
+	list := e.listeners[event]
+	if len(list) == 1 {
+		delete(e.listeners, event)
+	} else {
+		// the slice may be iterated over by Emit(), so it must not be modified in place
+		newList := make([]*listener, 0, len(list)-1)
+		newList = append(newList, list[:i]...)
+		e.listeners[event] = append(newList, list[i+1:]...)
+	}
+	e.notify(event)
+}
+
+// Emit calls the listeners of the specified event in the order they were added, passing the arguments to each
+// and using this as the 'this' value. Returns true if the event had listeners. If a listener throws,
+// the remaining listeners are not called and the exception is returned.
+func (e *Emitter) Emit(this goja.Value, event string, args ...goja.Value) (bool, error) {
+	list := e.listeners[event]
+	for _, l := range list {
+		if l.once {
+			// the same function may have been added more than once, so the listener must be looked up by identity
+			for i, l1 := range e.listeners[event] {
+				if l1 == l {
+					e.removeListenerAt(event, i)
+					break
+				}
+			}
+		}
+		if _, err := l.call(this, args...); err != nil {
+			return true, err
+		}
+	}
+	return len(list) > 0, nil
+}
+
+// ListenerCount returns the number of listeners of the specified event.
+func (e *Emitter) ListenerCount(event string) int {
+	return len(e.listeners[event])
+}
+
+// RemoveAllListeners removes the listeners of all events.
+func (e *Emitter) RemoveAllListeners() {
+	listeners := e.listeners
+	e.listeners = nil
+	for event := range listeners {
+		e.notify(event)
+	}
+}
+
+// Emit calls the listeners of the specified process event in the order they were added, passing the arguments
+// to each. Returns true if the event had listeners. If a listener throws, the remaining listeners are not called
+// and the exception is returned.
+// Must only be called from the goroutine that runs the runtime.
+func (p *Process) Emit(event string, args ...goja.Value) (bool, error) {
+	return p.events.Emit(p.obj, event, args...)
+}
+
+// ListenerCount returns the number of listeners of the specified process event.
+func (p *Process) ListenerCount(event string) int {
+	return p.events.ListenerCount(event)
+}
+
+// RemoveAllListeners removes the listeners of all process events.
+func (p *Process) RemoveAllListeners() {
+	p.events.RemoveAllListeners()
+}
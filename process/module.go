@@ -11,12 +11,21 @@ import (
 const ModuleName = "process"
 
 type Process struct {
-	env map[string]string
+	runtime *goja.Runtime
+	obj     *goja.Object
+	env     map[string]string
+
+	events Emitter
 }
 
+var (
+	symApi = goja.NewSymbol("api")
+)
+
 func Require(runtime *goja.Runtime, module *goja.Object) {
 	p := &Process{
-		env: make(map[string]string),
+		runtime: runtime,
+		env:     make(map[string]string),
 	}
 
 	for _, e := range os.Environ() {
@@ -25,7 +34,30 @@ func Require(runtime *goja.Runtime, module *goja.Object) {
 	}
 
 	o := module.Get("exports").(*goja.Object)
+	p.obj = o
 	o.Set("env", p.env)
+	p.initEvents(o)
+	o.DefineDataPropertySymbol(symApi, runtime.ToValue(p), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+// GetApi returns the Process instance for the runtime, loading the module if it has not been loaded yet.
+// Requires the require module to be enabled in the runtime.
+func GetApi(runtime *goja.Runtime) *Process {
+	o, ok := require.Require(runtime, ModuleName).(*goja.Object)
+	if !ok {
+		panic(runtime.NewTypeError("Could not extract process"))
+	}
+	if s := o.GetSymbol(symApi); s != nil {
+		if p, ok := s.Export().(*Process); ok {
+			return p
+		}
+	}
+	panic(runtime.NewTypeError("Could not extract process"))
+}
+
+// Object returns the process object, i.e. the module's exports.
+func (p *Process) Object() *goja.Object {
+	return p.obj
 }
 
 func Enable(runtime *goja.Runtime) {
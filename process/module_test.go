@@ -66,3 +66,86 @@ func TestProcessEnvValuesBrackets(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessEvents(t *testing.T) {
+	vm := goja.New()
+
+	new(require.Registry).Enable(vm)
+	Enable(vm)
+
+	_, err := vm.RunString(`
+	var log = [];
+	function f(a, b) {
+		log.push("f " + a + b);
+	}
+	process.on("test", f);
+	process.once("test", function(a) {
+		log.push("once " + a);
+	});
+	if (process.listenerCount("test") !== 2) {
+		throw new Error("listenerCount: " + process.listenerCount("test"));
+	}
+	if (!process.emit("test", 1, 2)) {
+		throw new Error("emit returned false");
+	}
+	process.emit("test", 3, 4);
+	process.off("test", f);
+	if (process.emit("test", 5, 6)) {
+		throw new Error("emit returned true");
+	}
+	try {
+		process.on("test", 1);
+		throw new Error("should have thrown");
+	} catch (e) {
+		if (e.code !== "ERR_INVALID_ARG_TYPE") {
+			throw e;
+		}
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if log := vm.Get("log").String(); log != "f 12,once 1,f 34" {
+		t.Fatal(log)
+	}
+
+	p := GetApi(vm)
+	_, err = vm.RunString(`process.on("go", function(v) { throw new Error(v); });`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitted, err := p.Emit("go", vm.ToValue("boom"))
+	if !emitted {
+		t.Fatal("not emitted")
+	}
+	if ex, ok := err.(*goja.Exception); !ok || ex.Value().String() != "Error: boom" {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessEventsOnceAndOn(t *testing.T) {
+	vm := goja.New()
+
+	new(require.Registry).Enable(vm)
+	Enable(vm)
+
+	v, err := vm.RunString(`
+	var count = 0;
+	function f() {
+		count++;
+	}
+	process.once("test", f);
+	process.on("test", f);
+	process.emit("test");
+	process.emit("test");
+	count + ":" + process.listenerCount("test");
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 'once' listener runs only on the first emit, the other one runs every time and is kept
+	if s := v.String(); s != "3:1" {
+		t.Fatal(s)
+	}
+}
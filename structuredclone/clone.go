@@ -0,0 +1,456 @@
+// Package structuredclone implements the HTML structured clone algorithm, which is used to pass values between
+// runtimes (for example, between the loops of worker_threads).
+//
+// Serialize() converts a value into a runtime-independent Data, which can later be turned into an equivalent value
+// in the same or a different runtime using Deserialize(). Primitive values, plain objects, arrays, Date, RegExp,
+// Map, Set, ArrayBuffer, typed arrays, DataView, Error objects and the primitive wrappers are supported; cyclic
+// references and multiple references to the same object are preserved. Other objects (such as functions, symbols,
+// promises and weak collections) cannot be cloned, in which case a *CloneError is returned.
+package structuredclone
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/buffer"
+)
+
+type kind int
+
+const (
+	kindObject kind = iota
+	kindArray
+	kindBoolean
+	kindNumber
+	kindString
+	kindBigInt
+	kindDate
+	kindRegExp
+	kindMap
+	kindSet
+	kindError
+	kindArrayBuffer
+	kindTypedArray
+	kindDataView
+)
+
+// object is a serialized object. Values are represented either as goja.Value (primitives, which do not belong to a
+// particular runtime) or as *object. The same *object may be referenced more than once.
+type object struct {
+	kind kind
+
+	// the own enumerable properties of plain objects and arrays (including the indexes)
+	props  []property
+	length int64
+
+	// the primitive value of a wrapper, the time value of a Date, the source of a RegExp
+	value goja.Value
+	// the name of an Error or of a typed array constructor
+	name string
+	// the flags of a RegExp, "Buffer" for a Uint8Array which is a Buffer
+	flags string
+
+	// key, value pairs of a Map or the values of a Set
+	entries []interface{}
+
+	// the message and the stack of an Error (undefined if missing) and its cause
+	message, stack goja.Value
+	cause          interface{}
+	hasCause       bool
+
+	// the contents of an ArrayBuffer
+	data []byte
+	// the ArrayBuffer and the range of a view (in elements for typed arrays, in bytes for a DataView)
+	buffer *object
+	offset int64
+}
+
+type property struct {
+	key   string
+	value interface{}
+}
+
+// Data is a serialized value created by Serialize(). It does not belong to any runtime and can be passed
+// to another goroutine. It can only be deserialized once, because the resulting ArrayBuffers share the memory
+// with it.
+type Data struct {
+	root interface{}
+	// restore the Buffers as Buffers rather than Uint8Arrays (the way v8.deserialize() does)
+	nodeBuffers bool
+}
+
+// CloneError is returned by Serialize() when a value cannot be cloned or transferred.
+type CloneError struct {
+	Message string
+}
+
+func (e *CloneError) Error() string {
+	return e.Message
+}
+
+// NewDataCloneError creates an error that resembles a 'DataCloneError' DOMException.
+func NewDataCloneError(r *goja.Runtime, msg string) *goja.Object {
+	ctor, _ := r.Get("Error").(*goja.Object)
+	e, err := r.New(ctor, r.ToValue(msg))
+	if err != nil {
+		panic(err)
+	}
+	e.DefineDataProperty("name", r.ToValue("DataCloneError"), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	e.DefineDataProperty("code", r.ToValue(25), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_FALSE)
+	return e
+}
+
+// ErrorValue converts an error returned by Serialize() into a value that can be thrown: a 'DataCloneError' for
+// a *CloneError or the value of a *goja.Exception.
+func ErrorValue(r *goja.Runtime, err error) goja.Value {
+	switch err := err.(type) {
+	case *CloneError:
+		return NewDataCloneError(r, err.Message)
+	case *goja.Exception:
+		return err.Value()
+	}
+	return r.NewGoError(err)
+}
+
+type serializer struct {
+	r        *goja.Runtime
+	h        *helpers
+	memory   map[*goja.Object]*object
+	transfer map[*goja.Object]goja.ArrayBuffer
+}
+
+var (
+	typeArrayBuffer = reflect.TypeOf(goja.ArrayBuffer{})
+	typeMap         = reflect.TypeOf([][2]interface{}(nil))
+	typeSet         = reflect.TypeOf([]interface{}(nil))
+	typeBigInt      = reflect.TypeOf((*big.Int)(nil))
+	typeObject      = reflect.TypeOf(map[string]interface{}(nil))
+)
+
+// Serialize creates a runtime-independent copy of the value. The ArrayBuffers in the transfer list are not copied,
+// their contents are moved into the Data and the buffers are detached.
+//
+// If the value (or any value it references) cannot be cloned, or the transfer list is invalid, a *CloneError is
+// returned. Exceptions thrown by the getters of the serialized objects are returned as *goja.Exception.
+// Must be called from the goroutine that runs the runtime.
+func Serialize(r *goja.Runtime, v goja.Value, transfer []goja.Value) (data *Data, err error) {
+	s := &serializer{
+		r:      r,
+		h:      getHelpers(r),
+		memory: make(map[*goja.Object]*object),
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			switch x := x.(type) {
+			case *CloneError:
+				err = x
+			case *goja.Exception:
+				err = x
+			default:
+				panic(x)
+			}
+		}
+	}()
+	for _, t := range transfer {
+		s.addTransfer(t)
+	}
+	root := s.serialize(v)
+	for _, ab := range s.transfer {
+		ab.Detach()
+	}
+	return &Data{root: root}, nil
+}
+
+func (s *serializer) addTransfer(v goja.Value) {
+	o, ok := v.(*goja.Object)
+	if ok {
+		if ab, ok := o.Export().(goja.ArrayBuffer); ok {
+			if s.transfer == nil {
+				s.transfer = make(map[*goja.Object]goja.ArrayBuffer)
+			}
+			if _, exists := s.transfer[o]; exists {
+				panic(&CloneError{Message: "ArrayBuffer occurs in the transfer array more than once"})
+			}
+			if ab.Detached() {
+				panic(&CloneError{Message: "An ArrayBuffer is detached and could not be cloned."})
+			}
+			s.transfer[o] = ab
+			return
+		}
+	}
+	panic(&CloneError{Message: "Value not transferable"})
+}
+
+func (s *serializer) notCloneable(desc string) {
+	panic(&CloneError{Message: desc + " could not be cloned."})
+}
+
+func (s *serializer) serialize(v goja.Value) interface{} {
+	o, ok := v.(*goja.Object)
+	if !ok {
+		if _, ok := v.(*goja.Symbol); ok {
+			s.notCloneable(v.String())
+		}
+		return v
+	}
+	if obj := s.memory[o]; obj != nil {
+		return obj
+	}
+	obj := &object{}
+	s.memory[o] = obj
+
+	switch o.ClassName() {
+	case "Array":
+		obj.kind = kindArray
+		obj.length = o.Get("length").ToInteger()
+		s.serializeProps(o, obj)
+		return obj
+	case "Error":
+		s.serializeError(o, obj)
+		return obj
+	case "Date":
+		obj.kind = kindDate
+		obj.value = call(s.h.time, o)
+		return obj
+	case "RegExp":
+		obj.kind = kindRegExp
+		res := call(s.h.regExp, o).(*goja.Object)
+		obj.value = res.Get("0")
+		obj.flags = res.Get("1").String()
+		return obj
+	case "Boolean":
+		s.serializePrimitive(o, obj, kindBoolean, "Boolean")
+		return obj
+	case "Number":
+		s.serializePrimitive(o, obj, kindNumber, "Number")
+		return obj
+	case "String":
+		s.serializePrimitive(o, obj, kindString, "String")
+		return obj
+	case "Function", "AsyncFunction", "GeneratorFunction":
+		s.notCloneable(o.String())
+	}
+
+	switch typ := o.ExportType(); {
+	case typ == typeArrayBuffer:
+		ab := o.Export().(goja.ArrayBuffer)
+		if ab.Detached() {
+			s.notCloneable("An ArrayBuffer is detached and")
+		}
+		obj.kind = kindArrayBuffer
+		if _, ok := s.transfer[o]; ok {
+			obj.data = ab.Bytes()
+		} else {
+			obj.data = append([]byte(nil), ab.Bytes()...)
+		}
+	case typ == typeMap:
+		obj.kind = kindMap
+		s.serializeEntries(call(s.h.mapEntries, o).(*goja.Object), obj)
+	case typ == typeSet:
+		obj.kind = kindSet
+		s.serializeEntries(call(s.h.setValues, o).(*goja.Object), obj)
+	case typ == typeBigInt:
+		s.serializePrimitive(o, obj, kindBigInt, "BigInt")
+	case typ == typeObject:
+		if s.isInstance(o, s.h.dataViewProto) {
+			if res, ok := call(s.h.dataView, o).(*goja.Object); ok {
+				obj.kind = kindDataView
+				s.serializeView(res, obj)
+				return obj
+			}
+		}
+		if (s.isInstance(o, s.h.weakMapProto) || s.isInstance(o, s.h.weakSetProto)) &&
+			call(s.h.isWeakCollection, o).ToBoolean() {
+			s.notCloneable("#<" + s.constructorName(o) + ">")
+		}
+		obj.kind = kindObject
+		s.serializeProps(o, obj)
+	case typ.Kind() == reflect.Slice:
+		if res, ok := call(s.h.typedArray, o).(*goja.Object); ok {
+			obj.kind = kindTypedArray
+			obj.name = res.Get("0").String()
+			if obj.name == "Uint8Array" && s.constructorName(o) == "Buffer" {
+				obj.flags = "Buffer"
+			}
+			s.serializeView(res, obj)
+			return obj
+		}
+		s.notCloneable("#<" + s.constructorName(o) + ">")
+	default:
+		s.notCloneable("#<" + s.constructorName(o) + ">")
+	}
+	return obj
+}
+
+// isInstance returns true if proto is in the prototype chain of the object.
+func (s *serializer) isInstance(o, proto *goja.Object) bool {
+	for p := o.Prototype(); p != nil; p = p.Prototype() {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *serializer) constructorName(o *goja.Object) string {
+	if p := o.Prototype(); p != nil {
+		if c, ok := p.Get("constructor").(*goja.Object); ok {
+			if name := c.Get("name"); name != nil {
+				if n, ok := name.Export().(string); ok && n != "" {
+					return n
+				}
+			}
+		}
+	}
+	return "Object"
+}
+
+func (s *serializer) serializeProps(o *goja.Object, obj *object) {
+	keys := o.Keys()
+	obj.props = make([]property, 0, len(keys))
+	for _, key := range keys {
+		v := o.Get(key)
+		if v == nil {
+			// deleted by a getter
+			continue
+		}
+		obj.props = append(obj.props, property{key: key, value: s.serialize(v)})
+	}
+}
+
+func (s *serializer) serializePrimitive(o *goja.Object, obj *object, k kind, name string) {
+	obj.kind = k
+	obj.value = call(s.h.primitive, s.r.ToValue(name), o)
+}
+
+func (s *serializer) serializeEntries(list *goja.Object, obj *object) {
+	n := list.Get("length").ToInteger()
+	obj.entries = make([]interface{}, n)
+	for i := int64(0); i < n; i++ {
+		obj.entries[i] = s.serialize(list.Get(fmt.Sprint(i)))
+	}
+}
+
+// serializeView serializes the [buffer, offset, length] part of the result of the typedArray or dataView helper.
+func (s *serializer) serializeView(res *goja.Object, obj *object) {
+	o := 0
+	if obj.kind == kindTypedArray {
+		o = 1
+	}
+	buffer, ok := s.serialize(res.Get(fmt.Sprint(o))).(*object)
+	if !ok || buffer.kind != kindArrayBuffer {
+		s.notCloneable("#<" + obj.name + ">")
+	}
+	obj.buffer = buffer
+	obj.offset = res.Get(fmt.Sprint(o + 1)).ToInteger()
+	obj.length = res.Get(fmt.Sprint(o + 2)).ToInteger()
+}
+
+func (s *serializer) serializeError(o *goja.Object, obj *object) {
+	obj.kind = kindError
+	info := call(s.h.errorInfo, o).(*goja.Object)
+	obj.name = info.Get("0").String()
+	obj.message = info.Get("1")
+	obj.stack = info.Get("2")
+	obj.hasCause = info.Get("3").ToBoolean()
+	if obj.hasCause {
+		obj.cause = s.serialize(info.Get("4"))
+	}
+}
+
+// Deserialize creates the value in the runtime. Must be called from the goroutine that runs the runtime and
+// only once for each Data.
+func (d *Data) Deserialize(r *goja.Runtime) goja.Value {
+	ds := &deserializer{
+		r:           r,
+		h:           getHelpers(r),
+		memory:      make(map[*object]goja.Value),
+		nodeBuffers: d.nodeBuffers,
+	}
+	return ds.deserialize(d.root)
+}
+
+type deserializer struct {
+	r           *goja.Runtime
+	h           *helpers
+	memory      map[*object]goja.Value
+	nodeBuffers bool
+}
+
+func (ds *deserializer) deserialize(v interface{}) goja.Value {
+	obj, ok := v.(*object)
+	if !ok {
+		if v == nil {
+			return goja.Undefined()
+		}
+		return v.(goja.Value)
+	}
+	if res := ds.memory[obj]; res != nil {
+		return res
+	}
+	r := ds.r
+	var res *goja.Object
+	switch obj.kind {
+	case kindObject:
+		res = r.NewObject()
+		ds.memory[obj] = res
+		ds.deserializeProps(obj, res)
+	case kindArray:
+		res = r.NewArray()
+		ds.memory[obj] = res
+		res.Set("length", obj.length)
+		ds.deserializeProps(obj, res)
+	case kindBoolean, kindNumber, kindString, kindBigInt:
+		res = call(ds.h.newWrapper, obj.value).(*goja.Object)
+		ds.memory[obj] = res
+	case kindDate:
+		res = call(ds.h.newDate, obj.value).(*goja.Object)
+		ds.memory[obj] = res
+	case kindRegExp:
+		res = call(ds.h.newRegExp, obj.value, r.ToValue(obj.flags)).(*goja.Object)
+		ds.memory[obj] = res
+	case kindMap:
+		res = call(ds.h.newMap).(*goja.Object)
+		ds.memory[obj] = res
+		for i := 0; i+1 < len(obj.entries); i += 2 {
+			call(ds.h.mapSet, res, ds.deserialize(obj.entries[i]), ds.deserialize(obj.entries[i+1]))
+		}
+	case kindSet:
+		res = call(ds.h.newSet).(*goja.Object)
+		ds.memory[obj] = res
+		for _, e := range obj.entries {
+			call(ds.h.setAdd, res, ds.deserialize(e))
+		}
+	case kindError:
+		res = call(ds.h.newError, r.ToValue(obj.name), obj.message, obj.stack).(*goja.Object)
+		ds.memory[obj] = res
+		if obj.hasCause {
+			call(ds.h.setCause, res, ds.deserialize(obj.cause))
+		}
+	case kindArrayBuffer:
+		res = r.ToValue(r.NewArrayBuffer(obj.data)).(*goja.Object)
+		ds.memory[obj] = res
+	case kindTypedArray:
+		if obj.flags == "Buffer" && ds.nodeBuffers {
+			res = buffer.WrapBytes(r, viewBytes(obj))
+			ds.memory[obj] = res
+			break
+		}
+		buffer := ds.deserialize(obj.buffer)
+		res = call(ds.h.newTypedArray, r.ToValue(obj.name), buffer, r.ToValue(obj.offset), r.ToValue(obj.length)).(*goja.Object)
+		ds.memory[obj] = res
+	case kindDataView:
+		buffer := ds.deserialize(obj.buffer)
+		res = call(ds.h.newDataView, buffer, r.ToValue(obj.offset), r.ToValue(obj.length)).(*goja.Object)
+		ds.memory[obj] = res
+	}
+	return res
+}
+
+func (ds *deserializer) deserializeProps(obj *object, res *goja.Object) {
+	for _, p := range obj.props {
+		res.DefineDataProperty(p.key, ds.deserialize(p.value), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_TRUE)
+	}
+}
@@ -0,0 +1,154 @@
+package structuredclone
+
+import (
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src := goja.New()
+	v, err := src.RunString(`
+	const obj = {
+		num: 1.5, str: "s", big: 10n, bool: true, nul: null, undef: undefined,
+		date: new Date(1000), re: /a+/gi, map: new Map([[1, "one"]]), set: new Set(["x"]),
+		arr: [1, , 3], wrapped: [Object(2), Object("w"), Object(false), Object(3n)],
+		err: new RangeError("bad", { cause: "why" }),
+		u16: new Uint16Array([1, 2, 3]).subarray(1),
+		dv: new DataView(new ArrayBuffer(8), 2, 4),
+	};
+	obj.arr.extra = "e";
+	obj.self = obj;
+	obj.shared = [obj.map, obj.map];
+	obj;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Serialize(src, v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := goja.New()
+	dst.Set("obj", data.Deserialize(dst))
+	res, err := dst.RunString(`
+	const checks = [
+		obj.num === 1.5, obj.str === "s", obj.big === 10n, obj.bool === true, obj.nul === null,
+		"undef" in obj && obj.undef === undefined,
+		obj.date instanceof Date && obj.date.getTime() === 1000,
+		obj.re instanceof RegExp && obj.re.source === "a+" && obj.re.flags === "gi",
+		obj.map instanceof Map && obj.map.get(1) === "one",
+		obj.set instanceof Set && obj.set.has("x"),
+		Array.isArray(obj.arr) && obj.arr.length === 3 && !(1 in obj.arr) && obj.arr[2] === 3 && obj.arr.extra === "e",
+		typeof obj.wrapped[0] === "object" && obj.wrapped[0].valueOf() === 2 && obj.wrapped[1].valueOf() === "w",
+		obj.wrapped[2].valueOf() === false && obj.wrapped[3].valueOf() === 3n,
+		obj.err instanceof RangeError && obj.err.message === "bad" && obj.err.cause === "why",
+		obj.u16 instanceof Uint16Array && obj.u16.length === 2 && obj.u16[0] === 2 && obj.u16.buffer.byteLength === 6,
+		obj.dv instanceof DataView && obj.dv.byteOffset === 2 && obj.dv.byteLength === 4,
+		obj.self === obj, obj.shared[0] === obj.map && obj.shared[1] === obj.map,
+	];
+	checks.indexOf(false);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := res.ToInteger(); i != -1 {
+		t.Fatalf("check %d failed", i)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	r := goja.New()
+	v, err := r.RunString(`
+	var buf = new Uint8Array([1, 2, 3]).buffer;
+	({ buf });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Serialize(r, v, []goja.Value{r.Get("buf")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Set("cloned", data.Deserialize(r))
+	res, err := r.RunString(`buf.byteLength + "," + Array.from(new Uint8Array(cloned.buf)).join()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "0,1,2,3" {
+		t.Fatal(s)
+	}
+
+	if _, err := Serialize(r, r.Get("buf"), nil); err == nil {
+		t.Fatal("detached buffer has been cloned")
+	}
+	if _, err := Serialize(r, r.ToValue(1), []goja.Value{r.ToValue(1)}); err == nil {
+		t.Fatal("invalid transfer list has been accepted")
+	}
+}
+
+func TestNotCloneable(t *testing.T) {
+	r := goja.New()
+	for _, script := range []string{
+		"(function f() {})",
+		"Symbol('s')",
+		"({ p: Promise.resolve() })",
+		"[new WeakMap()]",
+		"new Proxy({}, {})",
+	} {
+		v, err := r.RunString(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Serialize(r, v, nil)
+		if _, ok := err.(*CloneError); !ok {
+			t.Fatalf("%s: %v", script, err)
+		}
+		r.Set("e", ErrorValue(r, err))
+		res, _ := r.RunString("e.name + ',' + e.code")
+		if s := res.String(); s != "DataCloneError,25" {
+			t.Fatal(s)
+		}
+	}
+
+	v, err := r.RunString(`({ get x() { throw new Error("getter"); } })`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Serialize(r, v, nil)
+	if ex, ok := err.(*goja.Exception); !ok || ex.Value().ToObject(r).Get("message").String() != "getter" {
+		t.Fatal(err)
+	}
+}
+
+func TestEnable(t *testing.T) {
+	r := goja.New()
+	Enable(r)
+	res, err := r.RunString(`
+	const log = [];
+	const src = { d: new Date(5), m: new Map([["k", [1]]]) };
+	src.self = src;
+	const c = structuredClone(src);
+	log.push(c !== src, c.self === c, c.d.getTime(), c.m.get("k")[0]);
+
+	const buf = new ArrayBuffer(2);
+	const moved = structuredClone(buf, { transfer: [buf] });
+	log.push(buf.byteLength, moved.byteLength);
+
+	for (const f of [() => structuredClone(), () => structuredClone(Symbol()), () => structuredClone(1, 2)]) {
+		try {
+			f();
+		} catch (e) {
+			log.push(e.code);
+		}
+	}
+	log.join();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "true,true,5,1,0,2,ERR_MISSING_ARGS,25,ERR_INVALID_ARG_TYPE" {
+		t.Fatal(s)
+	}
+}
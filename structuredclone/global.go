@@ -0,0 +1,33 @@
+package structuredclone
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/errors"
+)
+
+// Enable adds the global structuredClone(value[, { transfer }]) function to the runtime.
+func Enable(runtime *goja.Runtime) {
+	runtime.Set("structuredClone", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) == 0 {
+			panic(errors.NewTypeError(runtime, errors.ErrCodeMissingArgs, "The \"value\" argument must be specified"))
+		}
+		var transfer []goja.Value
+		switch opts := call.Argument(1).(type) {
+		case *goja.Object:
+			if t := opts.Get("transfer"); t != nil && !goja.IsUndefined(t) {
+				if err := runtime.ExportTo(t, &transfer); err != nil {
+					panic(errors.NewNotCorrectTypeError(runtime, "options.transfer", "Array"))
+				}
+			}
+		default:
+			if !goja.IsUndefined(opts) && !goja.IsNull(opts) {
+				panic(errors.NewNotCorrectTypeError(runtime, "options", "object"))
+			}
+		}
+		data, err := Serialize(runtime, call.Arguments[0], transfer)
+		if err != nil {
+			panic(ErrorValue(runtime, err))
+		}
+		return data.Deserialize(runtime)
+	})
+}
@@ -0,0 +1,219 @@
+package structuredclone
+
+import (
+	"github.com/dop251/goja"
+)
+
+// The helpers use the built-in functions captured when they are created, so that the scripts cannot interfere by
+// replacing them. They are used for the values whose internal slots are not accessible from Go.
+var helpersPrg = goja.MustCompile("node:internal/structuredclone", `(function() {
+	var apply = Reflect.apply, getOwnPropertyDescriptor = Object.getOwnPropertyDescriptor;
+	var defineProperty = Object.defineProperty, create = Object.create;
+	function getter(o, name) {
+		return getOwnPropertyDescriptor(o, name).get;
+	}
+	var typedArrayProto = Object.getPrototypeOf(Uint8Array.prototype);
+	var taTag = getter(typedArrayProto, Symbol.toStringTag), taBuffer = getter(typedArrayProto, "buffer"),
+		taOffset = getter(typedArrayProto, "byteOffset"), taLength = getter(typedArrayProto, "length");
+	var dvBuffer = getter(DataView.prototype, "buffer"), dvOffset = getter(DataView.prototype, "byteOffset"),
+		dvLength = getter(DataView.prototype, "byteLength");
+	var mapForEach = Map.prototype.forEach, mapSet = Map.prototype.set;
+	var setForEach = Set.prototype.forEach, setAdd = Set.prototype.add;
+	var weakMapHas = WeakMap.prototype.has, weakSetHas = WeakSet.prototype.has;
+	var getTime = Date.prototype.getTime;
+	var reSource = getter(RegExp.prototype, "source"), reFlags = getter(RegExp.prototype, "flags");
+	var valueOf = {
+		Boolean: Boolean.prototype.valueOf,
+		Number: Number.prototype.valueOf,
+		String: String.prototype.valueOf,
+		BigInt: BigInt.prototype.valueOf
+	};
+	var typedArrayCtors = {
+		Int8Array: Int8Array, Uint8Array: Uint8Array, Uint8ClampedArray: Uint8ClampedArray,
+		Int16Array: Int16Array, Uint16Array: Uint16Array, Int32Array: Int32Array, Uint32Array: Uint32Array,
+		Float32Array: Float32Array, Float64Array: Float64Array,
+		BigInt64Array: BigInt64Array, BigUint64Array: BigUint64Array
+	};
+	var errorCtors = {
+		Error: Error, EvalError: EvalError, RangeError: RangeError, ReferenceError: ReferenceError,
+		SyntaxError: SyntaxError, TypeError: TypeError, URIError: URIError
+	};
+	var hasOwn = Object.prototype.hasOwnProperty;
+	var DataViewCtor = DataView, MapCtor = Map, SetCtor = Set, DateCtor = Date, RegExpCtor = RegExp;
+	var ObjectCtor = Object;
+	return {
+		dataViewProto: DataView.prototype,
+		weakMapProto: WeakMap.prototype,
+		weakSetProto: WeakSet.prototype,
+		typedArray: function(v) {
+			var tag = apply(taTag, v, []);
+			if (tag !== undefined) {
+				return [tag, apply(taBuffer, v, []), apply(taOffset, v, []), apply(taLength, v, [])];
+			}
+		},
+		dataView: function(v) {
+			try {
+				return [apply(dvBuffer, v, []), apply(dvOffset, v, []), apply(dvLength, v, [])];
+			} catch (e) {
+			}
+		},
+		isWeakCollection: function(v) {
+			try {
+				apply(weakMapHas, v, [{}]);
+				return true;
+			} catch (e) {
+			}
+			try {
+				apply(weakSetHas, v, [{}]);
+				return true;
+			} catch (e) {
+			}
+			return false;
+		},
+		mapEntries: function(m) {
+			var res = [];
+			apply(mapForEach, m, [function(value, key) {
+				res[res.length] = key;
+				res[res.length] = value;
+			}]);
+			return res;
+		},
+		setValues: function(s) {
+			var res = [];
+			apply(setForEach, s, [function(value) {
+				res[res.length] = value;
+			}]);
+			return res;
+		},
+		primitive: function(kind, v) {
+			return apply(valueOf[kind], v, []);
+		},
+		time: function(d) {
+			return apply(getTime, d, []);
+		},
+		regExp: function(re) {
+			return [apply(reSource, re, []), apply(reFlags, re, [])];
+		},
+		error: function(e) {
+			var name = e.name;
+			if (typeof name !== "string" || !apply(hasOwn, errorCtors, [name])) {
+				name = "Error";
+			}
+			var desc = getOwnPropertyDescriptor(e, "message");
+			var message = desc !== undefined && "value" in desc ? String(desc.value) : undefined;
+			var stack = e.stack;
+			if (typeof stack !== "string") {
+				stack = undefined;
+			}
+			desc = getOwnPropertyDescriptor(e, "cause");
+			return [name, message, stack, desc !== undefined && "value" in desc, desc && desc.value];
+		},
+		newTypedArray: function(name, buffer, offset, length) {
+			return new typedArrayCtors[name](buffer, offset, length);
+		},
+		newDataView: function(buffer, offset, length) {
+			return new DataViewCtor(buffer, offset, length);
+		},
+		newMap: function() {
+			return new MapCtor();
+		},
+		mapSet: function(m, key, value) {
+			apply(mapSet, m, [key, value]);
+		},
+		newSet: function() {
+			return new SetCtor();
+		},
+		setAdd: function(s, value) {
+			apply(setAdd, s, [value]);
+		},
+		newDate: function(t) {
+			return new DateCtor(t);
+		},
+		newRegExp: function(source, flags) {
+			return new RegExpCtor(source, flags);
+		},
+		newWrapper: function(v) {
+			return ObjectCtor(v);
+		},
+		newError: function(name, message, stack) {
+			var e = create(errorCtors[name].prototype);
+			if (message !== undefined) {
+				defineProperty(e, "message", {value: message, writable: true, configurable: true});
+			}
+			if (stack !== undefined) {
+				defineProperty(e, "stack", {value: stack, writable: true, configurable: true});
+			}
+			return e;
+		},
+		setCause: function(e, cause) {
+			defineProperty(e, "cause", {value: cause, writable: true, configurable: true});
+		}
+	};
+})()`, true)
+
+type helpers struct {
+	dataViewProto, weakMapProto, weakSetProto *goja.Object
+
+	typedArray, dataView, isWeakCollection, mapEntries, setValues, primitive, time, regExp, errorInfo goja.Callable
+
+	newTypedArray, newDataView, newMap, mapSet, newSet, setAdd, newDate, newRegExp, newWrapper, newError,
+	setCause goja.Callable
+}
+
+var symHelpers = goja.NewSymbol("structuredclone")
+
+// getHelpers returns the helpers for the runtime creating them if necessary. They are kept in a non-enumerable
+// symbol property of the global object.
+func getHelpers(r *goja.Runtime) *helpers {
+	global := r.GlobalObject()
+	if v := global.GetSymbol(symHelpers); v != nil {
+		if h, ok := v.Export().(*helpers); ok {
+			return h
+		}
+	}
+	v, err := r.RunProgram(helpersPrg)
+	if err != nil {
+		panic(err)
+	}
+	o := v.(*goja.Object)
+	fn := func(name string) goja.Callable {
+		f, _ := goja.AssertFunction(o.Get(name))
+		return f
+	}
+	h := &helpers{
+		dataViewProto:    o.Get("dataViewProto").(*goja.Object),
+		weakMapProto:     o.Get("weakMapProto").(*goja.Object),
+		weakSetProto:     o.Get("weakSetProto").(*goja.Object),
+		typedArray:       fn("typedArray"),
+		dataView:         fn("dataView"),
+		isWeakCollection: fn("isWeakCollection"),
+		mapEntries:       fn("mapEntries"),
+		setValues:        fn("setValues"),
+		primitive:        fn("primitive"),
+		time:             fn("time"),
+		regExp:           fn("regExp"),
+		errorInfo:        fn("error"),
+		newTypedArray:    fn("newTypedArray"),
+		newDataView:      fn("newDataView"),
+		newMap:           fn("newMap"),
+		mapSet:           fn("mapSet"),
+		newSet:           fn("newSet"),
+		setAdd:           fn("setAdd"),
+		newDate:          fn("newDate"),
+		newRegExp:        fn("newRegExp"),
+		newWrapper:       fn("newWrapper"),
+		newError:         fn("newError"),
+		setCause:         fn("setCause"),
+	}
+	global.DefineDataPropertySymbol(symHelpers, r.ToValue(h), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	return h
+}
+
+// call calls a helper, re-throwing the exceptions.
+func call(fn goja.Callable, args ...goja.Value) goja.Value {
+	v, err := fn(nil, args...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,804 @@
+package structuredclone
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// The V8 ValueSerializer wire format (see src/objects/value-serializer.cc in V8).
+const (
+	v8Version = 15
+
+	v8TagVersion           = 0xFF
+	v8TagPadding           = 0x00
+	v8TagVerifyObjectCount = '?'
+	v8TagTheHole           = '-'
+	v8TagUndefined         = '_'
+	v8TagNull              = '0'
+	v8TagTrue              = 'T'
+	v8TagFalse             = 'F'
+	v8TagInt32             = 'I'
+	v8TagUint32            = 'U'
+	v8TagDouble            = 'N'
+	v8TagBigInt            = 'Z'
+	v8TagUtf8String        = 'S'
+	v8TagOneByteString     = '"'
+	v8TagTwoByteString     = 'c'
+	v8TagObjectReference   = '^'
+	v8TagBeginJSObject     = 'o'
+	v8TagEndJSObject       = '{'
+	v8TagBeginSparseArray  = 'a'
+	v8TagEndSparseArray    = '@'
+	v8TagBeginDenseArray   = 'A'
+	v8TagEndDenseArray     = '$'
+	v8TagDate              = 'D'
+	v8TagTrueObject        = 'y'
+	v8TagFalseObject       = 'x'
+	v8TagNumberObject      = 'n'
+	v8TagBigIntObject      = 'z'
+	v8TagStringObject      = 's'
+	v8TagRegExp            = 'R'
+	v8TagBeginMap          = ';'
+	v8TagEndMap            = ':'
+	v8TagBeginSet          = '\''
+	v8TagEndSet            = ','
+	v8TagArrayBuffer       = 'B'
+	v8TagResizableBuffer   = '~'
+	v8TagArrayBufferView   = 'V'
+	v8TagError             = 'r'
+	v8TagHostObject        = '\\'
+
+	v8ErrorEvalError      = 'E'
+	v8ErrorRangeError     = 'R'
+	v8ErrorReferenceError = 'F'
+	v8ErrorSyntaxError    = 'S'
+	v8ErrorTypeError      = 'T'
+	v8ErrorURIError       = 'U'
+	v8ErrorMessage        = 'm'
+	v8ErrorCause          = 'c'
+	v8ErrorStack          = 's'
+	v8ErrorEnd            = '.'
+)
+
+var v8RegExpFlagBits = map[rune]uint64{
+	'g': 1 << 0,
+	'i': 1 << 1,
+	'm': 1 << 2,
+	'y': 1 << 3,
+	'u': 1 << 4,
+	's': 1 << 5,
+	'l': 1 << 6,
+	'd': 1 << 7,
+	'v': 1 << 8,
+}
+
+// The order of the flags as returned by RegExp.prototype.flags
+const regExpFlagsOrder = "dgimsuvy"
+
+var errorTags = map[string]byte{
+	"EvalError":      v8ErrorEvalError,
+	"RangeError":     v8ErrorRangeError,
+	"ReferenceError": v8ErrorReferenceError,
+	"SyntaxError":    v8ErrorSyntaxError,
+	"TypeError":      v8ErrorTypeError,
+	"URIError":       v8ErrorURIError,
+}
+
+// The views are written as host objects, the same way nodejs' v8.DefaultSerializer does. The index identifies the
+// constructor.
+var hostViewTypes = []string{"Int8Array", "Uint8Array", "Uint8ClampedArray", "Int16Array", "Uint16Array",
+	"Int32Array", "Uint32Array", "Float32Array", "Float64Array", "DataView", "Buffer", "BigInt64Array",
+	"BigUint64Array"}
+
+var v8ViewTags = map[byte]string{
+	'b': "Int8Array",
+	'B': "Uint8Array",
+	'C': "Uint8ClampedArray",
+	'w': "Int16Array",
+	'W': "Uint16Array",
+	'd': "Int32Array",
+	'D': "Uint32Array",
+	'f': "Float32Array",
+	'F': "Float64Array",
+	'q': "BigInt64Array",
+	'Q': "BigUint64Array",
+	'?': "DataView",
+}
+
+var elementSizes = map[string]int64{
+	"Int8Array":         1,
+	"Uint8Array":        1,
+	"Uint8ClampedArray": 1,
+	"Int16Array":        2,
+	"Uint16Array":       2,
+	"Int32Array":        4,
+	"Uint32Array":       4,
+	"Float32Array":      4,
+	"Float64Array":      8,
+	"BigInt64Array":     8,
+	"BigUint64Array":    8,
+	"DataView":          1,
+}
+
+// primitives is only used to create primitive values, which do not belong to a particular runtime.
+var primitives = goja.New()
+
+// ErrInvalidV8Data is returned by DecodeV8() if the data is malformed or uses a feature that is not supported.
+var ErrInvalidV8Data = errors.New("unable to deserialize cloned data")
+
+// EncodeV8 encodes the data using the V8 ValueSerializer format, the same way v8.serialize() does in nodejs
+// (i.e. the typed arrays, DataViews and Buffers are written as host objects, so they can only be read by
+// v8.deserialize()).
+func (d *Data) EncodeV8() []byte {
+	w := &v8Writer{
+		ids: make(map[*object]uint64),
+	}
+	w.buf = append(w.buf, v8TagVersion, v8Version)
+	w.writeValue(d.root)
+	return w.buf
+}
+
+type v8Writer struct {
+	buf    []byte
+	ids    map[*object]uint64
+	nextId uint64
+}
+
+func (w *v8Writer) writeVarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *v8Writer) writeDouble(f float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(f))
+}
+
+func (w *v8Writer) writeValue(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.buf = append(w.buf, v8TagUndefined)
+	case *object:
+		w.writeObject(v)
+	case goja.Value:
+		w.writePrimitive(v)
+	}
+}
+
+func (w *v8Writer) writePrimitive(v goja.Value) {
+	switch {
+	case goja.IsUndefined(v):
+		w.buf = append(w.buf, v8TagUndefined)
+	case goja.IsNull(v):
+		w.buf = append(w.buf, v8TagNull)
+	case goja.IsString(v):
+		w.writeString(v)
+	default:
+		switch e := v.Export().(type) {
+		case bool:
+			if e {
+				w.buf = append(w.buf, v8TagTrue)
+			} else {
+				w.buf = append(w.buf, v8TagFalse)
+			}
+		case int64:
+			w.writeNumber(float64(e))
+		case float64:
+			w.writeNumber(e)
+		case *big.Int:
+			w.buf = append(w.buf, v8TagBigInt)
+			w.writeBigInt(e)
+		}
+	}
+}
+
+func (w *v8Writer) writeNumber(f float64) {
+	if i := int32(f); float64(i) == f && (f != 0 || !math.Signbit(f)) {
+		w.buf = append(w.buf, v8TagInt32)
+		w.writeVarint(uint64(uint32((i << 1) ^ (i >> 31))))
+		return
+	}
+	w.buf = append(w.buf, v8TagDouble)
+	w.writeDouble(f)
+}
+
+// writeBigInt writes the bitfield (the length in bytes and the sign) followed by the little-endian 64-bit digits.
+func (w *v8Writer) writeBigInt(i *big.Int) {
+	be := i.Bytes()
+	n := (len(be) + 7) / 8 * 8
+	var bitfield uint64
+	if i.Sign() < 0 {
+		bitfield = 1
+	}
+	bitfield |= uint64(n) << 1
+	w.writeVarint(bitfield)
+	for j := len(be) - 1; j >= 0; j-- {
+		w.buf = append(w.buf, be[j])
+	}
+	for j := len(be); j < n; j++ {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *v8Writer) writeString(v goja.Value) {
+	s := v.(goja.String)
+	n := s.Length()
+	oneByte := true
+	for i := 0; i < n; i++ {
+		if s.CharAt(i) > 0xFF {
+			oneByte = false
+			break
+		}
+	}
+	if oneByte {
+		w.buf = append(w.buf, v8TagOneByteString)
+		w.writeVarint(uint64(n))
+		for i := 0; i < n; i++ {
+			w.buf = append(w.buf, byte(s.CharAt(i)))
+		}
+		return
+	}
+	// the two-byte characters are aligned
+	if (len(w.buf)+1+varintLen(uint64(n*2)))&1 != 0 {
+		w.buf = append(w.buf, v8TagPadding)
+	}
+	w.buf = append(w.buf, v8TagTwoByteString)
+	w.writeVarint(uint64(n * 2))
+	for i := 0; i < n; i++ {
+		w.buf = binary.LittleEndian.AppendUint16(w.buf, s.CharAt(i))
+	}
+}
+
+func (w *v8Writer) writeGoString(s string) {
+	w.writeString(primitives.ToValue(s))
+}
+
+func varintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// writeKey writes a property key, the array indexes are written as numbers.
+func (w *v8Writer) writeKey(key string) {
+	if i, ok := arrayIndex(key); ok && i <= math.MaxInt32 {
+		w.writeNumber(float64(i))
+		return
+	}
+	w.writeGoString(key)
+}
+
+func arrayIndex(key string) (int64, bool) {
+	i, err := strconv.ParseUint(key, 10, 32)
+	if err != nil || i == math.MaxUint32 || strconv.FormatUint(i, 10) != key {
+		return 0, false
+	}
+	return int64(i), true
+}
+
+func (w *v8Writer) writeObject(obj *object) {
+	if id, ok := w.ids[obj]; ok {
+		w.buf = append(w.buf, v8TagObjectReference)
+		w.writeVarint(id)
+		return
+	}
+	w.ids[obj] = w.nextId
+	w.nextId++
+
+	switch obj.kind {
+	case kindObject:
+		w.buf = append(w.buf, v8TagBeginJSObject)
+		w.writeProps(obj.props)
+		w.buf = append(w.buf, v8TagEndJSObject)
+		w.writeVarint(uint64(len(obj.props)))
+	case kindArray:
+		w.writeArray(obj)
+	case kindBoolean:
+		if obj.value.ToBoolean() {
+			w.buf = append(w.buf, v8TagTrueObject)
+		} else {
+			w.buf = append(w.buf, v8TagFalseObject)
+		}
+	case kindNumber:
+		w.buf = append(w.buf, v8TagNumberObject)
+		w.writeDouble(obj.value.ToFloat())
+	case kindBigInt:
+		w.buf = append(w.buf, v8TagBigIntObject)
+		w.writeBigInt(obj.value.Export().(*big.Int))
+	case kindString:
+		w.buf = append(w.buf, v8TagStringObject)
+		w.writeString(obj.value)
+	case kindDate:
+		w.buf = append(w.buf, v8TagDate)
+		w.writeDouble(obj.value.ToFloat())
+	case kindRegExp:
+		w.buf = append(w.buf, v8TagRegExp)
+		w.writeString(obj.value)
+		var flags uint64
+		for _, f := range obj.flags {
+			flags |= v8RegExpFlagBits[f]
+		}
+		w.writeVarint(flags)
+	case kindMap:
+		w.buf = append(w.buf, v8TagBeginMap)
+		for _, e := range obj.entries {
+			w.writeValue(e)
+		}
+		w.buf = append(w.buf, v8TagEndMap)
+		w.writeVarint(uint64(len(obj.entries)))
+	case kindSet:
+		w.buf = append(w.buf, v8TagBeginSet)
+		for _, e := range obj.entries {
+			w.writeValue(e)
+		}
+		w.buf = append(w.buf, v8TagEndSet)
+		w.writeVarint(uint64(len(obj.entries)))
+	case kindError:
+		w.buf = append(w.buf, v8TagError)
+		if tag, ok := errorTags[obj.name]; ok {
+			w.buf = append(w.buf, tag)
+		}
+		if !goja.IsUndefined(obj.message) {
+			w.buf = append(w.buf, v8ErrorMessage)
+			w.writeString(obj.message)
+		}
+		if !goja.IsUndefined(obj.stack) {
+			w.buf = append(w.buf, v8ErrorStack)
+			w.writeString(obj.stack)
+		}
+		if obj.hasCause {
+			w.buf = append(w.buf, v8ErrorCause)
+			w.writeValue(obj.cause)
+		}
+		w.buf = append(w.buf, v8ErrorEnd)
+	case kindArrayBuffer:
+		w.buf = append(w.buf, v8TagArrayBuffer)
+		w.writeVarint(uint64(len(obj.data)))
+		w.buf = append(w.buf, obj.data...)
+	case kindTypedArray, kindDataView:
+		name := obj.name
+		if obj.kind == kindDataView {
+			name = "DataView"
+		} else if obj.flags == "Buffer" {
+			name = "Buffer"
+		}
+		var index int
+		for i, n := range hostViewTypes {
+			if n == name {
+				index = i
+				break
+			}
+		}
+		data := viewBytes(obj)
+		w.buf = append(w.buf, v8TagHostObject)
+		w.writeVarint(uint64(index))
+		w.writeVarint(uint64(len(data)))
+		w.buf = append(w.buf, data...)
+	}
+}
+
+// viewBytes returns the part of the ArrayBuffer that the view covers.
+func viewBytes(obj *object) []byte {
+	size := obj.length
+	if obj.kind == kindTypedArray {
+		size *= elementSizes[obj.name]
+	}
+	return obj.buffer.data[obj.offset : obj.offset+size]
+}
+
+func (w *v8Writer) writeProps(props []property) {
+	for _, p := range props {
+		w.writeKey(p.key)
+		w.writeValue(p.value)
+	}
+}
+
+// writeArray writes an array without holes as dense, otherwise as sparse (the same way V8 does).
+func (w *v8Writer) writeArray(obj *object) {
+	dense := int64(len(obj.props)) >= obj.length
+	if dense {
+		for i := int64(0); i < obj.length; i++ {
+			if obj.props[i].key != strconv.FormatInt(i, 10) {
+				dense = false
+				break
+			}
+		}
+	}
+	if !dense {
+		w.buf = append(w.buf, v8TagBeginSparseArray)
+		w.writeVarint(uint64(obj.length))
+		w.writeProps(obj.props)
+		w.buf = append(w.buf, v8TagEndSparseArray)
+		w.writeVarint(uint64(len(obj.props)))
+		w.writeVarint(uint64(obj.length))
+		return
+	}
+	w.buf = append(w.buf, v8TagBeginDenseArray)
+	w.writeVarint(uint64(obj.length))
+	for _, p := range obj.props[:obj.length] {
+		w.writeValue(p.value)
+	}
+	rest := obj.props[obj.length:]
+	w.writeProps(rest)
+	w.buf = append(w.buf, v8TagEndDenseArray)
+	w.writeVarint(uint64(len(rest)))
+	w.writeVarint(uint64(obj.length))
+}
+
+// DecodeV8 decodes the data encoded in the V8 ValueSerializer format (for example, by v8.serialize() in nodejs).
+// As with v8.deserialize(), the host objects written by nodejs for the typed arrays, DataViews and Buffers are
+// supported, the Buffers are restored as Buffers. Returns ErrInvalidV8Data if the data is malformed or uses
+// a feature that cannot be supported (such as a SharedArrayBuffer or a transferred ArrayBuffer).
+func DecodeV8(b []byte) (data *Data, err error) {
+	r := &v8Reader{
+		buf:  b,
+		objs: make(map[uint64]*object),
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			if x != errMalformed {
+				panic(x)
+			}
+			data, err = nil, ErrInvalidV8Data
+		}
+	}()
+	if r.peekTag() == v8TagVersion {
+		r.pos++
+		r.version = r.readVarint()
+		if r.version > v8Version {
+			return nil, ErrInvalidV8Data
+		}
+	}
+	root := r.readValue()
+	return &Data{root: root, nodeBuffers: true}, nil
+}
+
+var errMalformed = errors.New("malformed")
+
+type v8Reader struct {
+	buf     []byte
+	pos     int
+	version uint64
+	objs    map[uint64]*object
+	nextId  uint64
+}
+
+func (r *v8Reader) fail() {
+	panic(errMalformed)
+}
+
+func (r *v8Reader) readByte() byte {
+	if r.pos >= len(r.buf) {
+		r.fail()
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *v8Reader) readBytes(n uint64) []byte {
+	if n > uint64(len(r.buf)-r.pos) {
+		r.fail()
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
+
+func (r *v8Reader) readVarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.fail()
+	}
+	r.pos += n
+	return v
+}
+
+func (r *v8Reader) readDouble() float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(r.readBytes(8)))
+}
+
+// peekTag returns the next tag skipping the padding, or 0 at the end of data.
+func (r *v8Reader) peekTag() byte {
+	for r.pos < len(r.buf) {
+		if r.buf[r.pos] != v8TagPadding {
+			return r.buf[r.pos]
+		}
+		r.pos++
+	}
+	return 0
+}
+
+func (r *v8Reader) readTag() byte {
+	for {
+		if b := r.readByte(); b != v8TagPadding {
+			return b
+		}
+	}
+}
+
+func (r *v8Reader) addObject(obj *object) *object {
+	r.objs[r.nextId] = obj
+	r.nextId++
+	return obj
+}
+
+func (r *v8Reader) readValue() interface{} {
+	tag := r.readTag()
+	switch tag {
+	case v8TagVerifyObjectCount:
+		r.readVarint()
+		return r.readValue()
+	case v8TagUndefined:
+		return goja.Undefined()
+	case v8TagNull:
+		return goja.Null()
+	case v8TagTrue:
+		return primitives.ToValue(true)
+	case v8TagFalse:
+		return primitives.ToValue(false)
+	case v8TagInt32:
+		v := uint32(r.readVarint())
+		return primitives.ToValue(int64(int32(v>>1) ^ -int32(v&1)))
+	case v8TagUint32:
+		return primitives.ToValue(int64(uint32(r.readVarint())))
+	case v8TagDouble:
+		return primitives.ToValue(r.readDouble())
+	case v8TagBigInt:
+		return primitives.ToValue(r.readBigInt())
+	case v8TagUtf8String, v8TagOneByteString, v8TagTwoByteString:
+		return r.readStringBody(tag)
+	case v8TagObjectReference:
+		obj := r.objs[r.readVarint()]
+		if obj == nil {
+			r.fail()
+		}
+		return obj
+	case v8TagBeginJSObject:
+		obj := r.addObject(&object{kind: kindObject})
+		r.readProps(obj, v8TagEndJSObject)
+		return obj
+	case v8TagBeginSparseArray:
+		obj := r.addObject(&object{kind: kindArray})
+		obj.length = int64(r.readVarint())
+		r.readProps(obj, v8TagEndSparseArray)
+		r.readVarint()
+		return obj
+	case v8TagBeginDenseArray:
+		obj := r.addObject(&object{kind: kindArray})
+		obj.length = int64(r.readVarint())
+		for i := int64(0); i < obj.length; i++ {
+			if r.peekTag() == v8TagTheHole {
+				r.pos++
+				continue
+			}
+			obj.props = append(obj.props, property{key: strconv.FormatInt(i, 10), value: r.readValue()})
+		}
+		r.readProps(obj, v8TagEndDenseArray)
+		r.readVarint()
+		return obj
+	case v8TagDate:
+		obj := r.addObject(&object{kind: kindDate})
+		obj.value = primitives.ToValue(r.readDouble())
+		return obj
+	case v8TagTrueObject, v8TagFalseObject:
+		obj := r.addObject(&object{kind: kindBoolean})
+		obj.value = primitives.ToValue(tag == v8TagTrueObject)
+		return obj
+	case v8TagNumberObject:
+		obj := r.addObject(&object{kind: kindNumber})
+		obj.value = primitives.ToValue(r.readDouble())
+		return obj
+	case v8TagBigIntObject:
+		obj := r.addObject(&object{kind: kindBigInt})
+		obj.value = primitives.ToValue(r.readBigInt())
+		return obj
+	case v8TagStringObject:
+		obj := r.addObject(&object{kind: kindString})
+		obj.value = r.readString()
+		return obj
+	case v8TagRegExp:
+		obj := r.addObject(&object{kind: kindRegExp})
+		obj.value = r.readString()
+		bits := r.readVarint()
+		var flags []byte
+		for _, f := range regExpFlagsOrder {
+			if bits&v8RegExpFlagBits[f] != 0 {
+				flags = append(flags, byte(f))
+			}
+		}
+		obj.flags = string(flags)
+		return obj
+	case v8TagBeginMap, v8TagBeginSet:
+		obj := &object{kind: kindMap}
+		end := byte(v8TagEndMap)
+		if tag == v8TagBeginSet {
+			obj.kind = kindSet
+			end = v8TagEndSet
+		}
+		r.addObject(obj)
+		for r.peekTag() != end {
+			obj.entries = append(obj.entries, r.readValue())
+		}
+		r.pos++
+		if r.readVarint() != uint64(len(obj.entries)) {
+			r.fail()
+		}
+		return obj
+	case v8TagArrayBuffer, v8TagResizableBuffer:
+		obj := r.addObject(&object{kind: kindArrayBuffer})
+		n := r.readVarint()
+		if tag == v8TagResizableBuffer {
+			r.readVarint()
+		}
+		obj.data = append([]byte(nil), r.readBytes(n)...)
+		if r.peekTag() == v8TagArrayBufferView {
+			r.pos++
+			return r.readView(obj)
+		}
+		return obj
+	case v8TagError:
+		return r.readError()
+	case v8TagHostObject:
+		return r.readHostObject()
+	}
+	r.fail()
+	return nil
+}
+
+func (r *v8Reader) readBigInt() *big.Int {
+	bitfield := r.readVarint()
+	b := r.readBytes(bitfield >> 1)
+	// the digits are little-endian
+	be := make([]byte, len(b))
+	for i, c := range b {
+		be[len(b)-1-i] = c
+	}
+	i := new(big.Int).SetBytes(be)
+	if bitfield&1 != 0 {
+		i.Neg(i)
+	}
+	return i
+}
+
+func (r *v8Reader) readString() goja.Value {
+	tag := r.readTag()
+	if tag != v8TagUtf8String && tag != v8TagOneByteString && tag != v8TagTwoByteString {
+		r.fail()
+	}
+	return r.readStringBody(tag)
+}
+
+func (r *v8Reader) readStringBody(tag byte) goja.Value {
+	b := r.readBytes(r.readVarint())
+	switch tag {
+	case v8TagUtf8String:
+		if !utf8.Valid(b) {
+			r.fail()
+		}
+		return primitives.ToValue(string(b))
+	case v8TagOneByteString:
+		chars := make([]uint16, len(b))
+		for i, c := range b {
+			chars[i] = uint16(c)
+		}
+		return goja.StringFromUTF16(chars)
+	}
+	if len(b)&1 != 0 {
+		r.fail()
+	}
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return goja.StringFromUTF16(chars)
+}
+
+// readProps reads the key, value pairs until the end tag, followed by the number of properties.
+func (r *v8Reader) readProps(obj *object, end byte) {
+	n := 0
+	for r.peekTag() != end {
+		var key string
+		switch k := r.readValue().(type) {
+		case goja.Value:
+			if !goja.IsString(k) && !goja.IsNumber(k) {
+				r.fail()
+			}
+			key = k.String()
+		default:
+			r.fail()
+		}
+		obj.props = append(obj.props, property{key: key, value: r.readValue()})
+		n++
+	}
+	r.pos++
+	if r.readVarint() != uint64(n) {
+		r.fail()
+	}
+}
+
+func (r *v8Reader) readView(buffer *object) *object {
+	subtag := r.readByte()
+	offset, size := int64(r.readVarint()), int64(r.readVarint())
+	if r.version >= 14 {
+		r.readVarint()
+	}
+	name, ok := v8ViewTags[subtag]
+	if !ok {
+		r.fail()
+	}
+	return r.addObject(r.newView(name, buffer, offset, size))
+}
+
+// newView creates a typed array or a DataView, the range is in bytes.
+func (r *v8Reader) newView(name string, buffer *object, offset, size int64) *object {
+	elemSize := elementSizes[name]
+	if offset < 0 || size < 0 || offset+size > int64(len(buffer.data)) || size%elemSize != 0 ||
+		offset%elemSize != 0 {
+		r.fail()
+	}
+	if name == "DataView" {
+		return &object{kind: kindDataView, buffer: buffer, offset: offset, length: size}
+	}
+	return &object{kind: kindTypedArray, name: name, buffer: buffer, offset: offset, length: size / elemSize}
+}
+
+// readHostObject reads a view written by nodejs' v8.DefaultSerializer.
+func (r *v8Reader) readHostObject() *object {
+	id := r.nextId
+	r.nextId++
+	index := r.readVarint()
+	if index >= uint64(len(hostViewTypes)) {
+		r.fail()
+	}
+	buffer := &object{kind: kindArrayBuffer}
+	buffer.data = append([]byte(nil), r.readBytes(r.readVarint())...)
+	name := hostViewTypes[index]
+	var obj *object
+	if name == "Buffer" {
+		obj = r.newView("Uint8Array", buffer, 0, int64(len(buffer.data)))
+		obj.flags = "Buffer"
+	} else {
+		obj = r.newView(name, buffer, 0, int64(len(buffer.data)))
+	}
+	r.objs[id] = obj
+	return obj
+}
+
+func (r *v8Reader) readError() *object {
+	obj := r.addObject(&object{
+		kind:    kindError,
+		name:    "Error",
+		message: goja.Undefined(),
+		stack:   goja.Undefined(),
+	})
+	for {
+		switch tag := r.readVarint(); tag {
+		case v8ErrorEvalError, v8ErrorRangeError, v8ErrorReferenceError, v8ErrorSyntaxError, v8ErrorTypeError,
+			v8ErrorURIError:
+			for name, t := range errorTags {
+				if uint64(t) == tag {
+					obj.name = name
+				}
+			}
+		case v8ErrorMessage:
+			obj.message = r.readString()
+		case v8ErrorStack:
+			obj.stack = r.readString()
+		case v8ErrorCause:
+			obj.hasCause = true
+			obj.cause = r.readValue()
+		case v8ErrorEnd:
+			return obj
+		default:
+			r.fail()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package structuredclone
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestEncodeV8(t *testing.T) {
+	// the expected values have been produced by v8.serialize() in nodejs
+	tests := []struct {
+		src, hex string
+	}{
+		{`({a: 1})`, "ff0f6f22016149027b01"},
+		{`"abc"`, "ff0f2203616263"},
+		{`"ф"`, "ff0f63024404"},
+		{`[1, 2]`, "ff0f410249024904240002"},
+		{`[1, , 3]`, "ff0f61034900490249044906400203"},
+		{`-1.5`, "ff0f4e000000000000f8bf"},
+		{`10n`, "ff0f5a100a00000000000000"},
+		{`new Date(0)`, "ff0f440000000000000000"},
+		{`/a/gi`, "ff0f5222016103"},
+		{`new Map([[1, true]])`, "ff0f3b4902543a02"},
+		{`new Set(["x"])`, "ff0f272201782c01"},
+		{`new Uint8Array([1, 2])`, "ff0f5c01020102"},
+		{`new BigInt64Array([1n])`, "ff0f5c0b080100000000000000"},
+		{`(() => { const o = {}; return [o, o]; })()`, "ff0f41026f7b005e01240002"},
+	}
+	for _, test := range tests {
+		r := goja.New()
+		v, err := r.RunString(test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := Serialize(r, v, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := hex.EncodeToString(data.EncodeV8()); res != test.hex {
+			t.Errorf("%s: %s, expected %s", test.src, res, test.hex)
+		}
+		b, _ := hex.DecodeString(test.hex)
+		data, err = DecodeV8(b)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if res := data.EncodeV8(); !bytes.Equal(res, b) {
+			t.Errorf("%s: re-encoded as %x", test.src, res)
+		}
+	}
+}
+
+func TestV8RoundTrip(t *testing.T) {
+	src := goja.New()
+	v, err := src.RunString(`
+	const obj = {
+		num: 1.5, int: -7, str: "sф", big: -(2n ** 70n), bool: false, nul: null, undef: undefined,
+		date: new Date(1000), re: /a+/gimsuy, map: new Map([[1, "one"]]), set: new Set(["x"]),
+		arr: [1, , 3], wrapped: [Object(2), Object("w"), Object(false), Object(3n)],
+		err: new RangeError("bad", { cause: "why" }),
+		u16: new Uint16Array([1, 2, 3]).subarray(1),
+		dv: new DataView(new ArrayBuffer(8), 2, 4),
+		ab: new ArrayBuffer(3),
+	};
+	obj.arr.extra = "e";
+	obj.self = obj;
+	obj.shared = [obj.map, obj.map];
+	obj;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Serialize(src, v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = DecodeV8(data.EncodeV8())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := goja.New()
+	dst.Set("obj", data.Deserialize(dst))
+	res, err := dst.RunString(`
+	const checks = [
+		obj.num === 1.5, obj.int === -7, obj.str === "sф", obj.big === -(2n ** 70n), obj.bool === false,
+		obj.nul === null, "undef" in obj && obj.undef === undefined,
+		obj.date instanceof Date && obj.date.getTime() === 1000,
+		obj.re instanceof RegExp && obj.re.source === "a+" && obj.re.flags === "gimsuy",
+		obj.map instanceof Map && obj.map.get(1) === "one",
+		obj.set instanceof Set && obj.set.has("x"),
+		Array.isArray(obj.arr) && obj.arr.length === 3 && !(1 in obj.arr) && obj.arr[2] === 3 && obj.arr.extra === "e",
+		typeof obj.wrapped[0] === "object" && obj.wrapped[0].valueOf() === 2 && obj.wrapped[1].valueOf() === "w",
+		obj.wrapped[2].valueOf() === false && obj.wrapped[3].valueOf() === 3n,
+		obj.err instanceof RangeError && obj.err.message === "bad" && obj.err.cause === "why",
+		obj.u16 instanceof Uint16Array && obj.u16.length === 2 && obj.u16[0] === 2 && obj.u16[1] === 3,
+		obj.dv instanceof DataView && obj.dv.byteLength === 4,
+		obj.ab instanceof ArrayBuffer && obj.ab.byteLength === 3,
+		obj.self === obj, obj.shared[0] === obj.map && obj.shared[1] === obj.map,
+	];
+	checks.indexOf(false);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := res.ToInteger(); i != -1 {
+		t.Fatalf("check %d failed", i)
+	}
+}
+
+func TestDecodeV8Invalid(t *testing.T) {
+	for _, s := range []string{"", "ff", "ff0f6f", "ff0f22ff", "ff0f5e05", "ff105f", "ff0f5c0104ff", "ff0f3f"} {
+		b, _ := hex.DecodeString(s)
+		if _, err := DecodeV8(b); err != ErrInvalidV8Data {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+}
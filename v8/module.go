@@ -0,0 +1,53 @@
+// Package v8 implements a subset of the nodejs 'v8' core module: the serialization API.
+//
+// The values are encoded using the V8 ValueSerializer format, so the data produced by v8.serialize() in nodejs can
+// be read by v8.deserialize() and vice versa. The set of supported types is the same as for structuredClone().
+package v8
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/buffer"
+	"github.com/dop251/goja_nodejs/require"
+	"github.com/dop251/goja_nodejs/structuredclone"
+)
+
+const ModuleName = "v8"
+
+type v8Module struct {
+	r *goja.Runtime
+}
+
+func (m *v8Module) serialize(call goja.FunctionCall) goja.Value {
+	data, err := structuredclone.Serialize(m.r, call.Argument(0), nil)
+	if err != nil {
+		panic(structuredclone.ErrorValue(m.r, err))
+	}
+	return buffer.WrapBytes(m.r, data.EncodeV8())
+}
+
+func (m *v8Module) deserialize(call goja.FunctionCall) goja.Value {
+	b := buffer.GetApi(m.r).RequiredBufferArgument(call, "buffer", 0)
+	data, err := structuredclone.DecodeV8(b)
+	if err != nil {
+		ctor, _ := m.r.Get("Error").(*goja.Object)
+		e, err := m.r.New(ctor, m.r.ToValue("Unable to deserialize cloned data."))
+		if err != nil {
+			panic(err)
+		}
+		panic(e)
+	}
+	return data.Deserialize(m.r)
+}
+
+func Require(runtime *goja.Runtime, module *goja.Object) {
+	m := &v8Module{
+		r: runtime,
+	}
+	exports := module.Get("exports").(*goja.Object)
+	exports.Set("serialize", m.serialize)
+	exports.Set("deserialize", m.deserialize)
+}
+
+func init() {
+	require.RegisterCoreModule(ModuleName, Require)
+}
@@ -0,0 +1,60 @@
+package v8
+
+import (
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+func TestSerialize(t *testing.T) {
+	vm := goja.New()
+	new(require.Registry).Enable(vm)
+
+	_, err := vm.RunString(`
+	const v8 = require("node:v8");
+	const { Buffer } = require("buffer");
+
+	const buf = v8.serialize({ a: 1 });
+	if (!(buf instanceof Buffer) || buf.toString("hex") !== "ff0f6f22016149027b01") {
+		throw new Error(buf.toString("hex"));
+	}
+
+	// produced by v8.serialize({ b: Buffer.from("hi"), dv: new DataView(new ArrayBuffer(4), 1, 2) }) in nodejs
+	const o = v8.deserialize(Buffer.from("ff0f6f2201625c0a026869220264765c090200007b02", "hex"));
+	if (!(o.b instanceof Buffer) || o.b.toString() !== "hi") {
+		throw new Error("Buffer has not been restored");
+	}
+	if (!(o.dv instanceof DataView) || o.dv.byteLength !== 2) {
+		throw new Error("DataView has not been restored");
+	}
+
+	const m = new Map([[1, new Set([2n])]]);
+	m.set("self", m);
+	const m1 = v8.deserialize(v8.serialize(m));
+	if (m1.get(1).has(2n) !== true || m1.get("self") !== m1) {
+		throw new Error("Map has not been restored");
+	}
+
+	try {
+		v8.serialize(() => {});
+		throw new Error("function has been serialized");
+	} catch (e) {
+		if (e.name !== "DataCloneError") {
+			throw e;
+		}
+	}
+
+	try {
+		v8.deserialize(Buffer.from([0xff, 0x0f, 0x6f]));
+		throw new Error("invalid data has been deserialized");
+	} catch (e) {
+		if (e.message !== "Unable to deserialize cloned data.") {
+			throw e;
+		}
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}